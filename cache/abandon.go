@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// generator is the form in which the cache calls generators, with a context cancelled once every caller
+// waiting on the generation has abandoned it under CancelAbandoned.
+type generator func(ctx context.Context, key interface{}) (interface{}, error)
+
+// withoutContext adapts a generator that takes no context.
+func withoutContext(generate func(interface{}) (interface{}, error)) generator {
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		return generate(key)
+	}
+}
+
+// GetContext behaves like Get, but passes the generator a context. With CancelAbandoned set the context is
+// cancelled once every caller waiting on the generation has timed out; otherwise it is never cancelled.
+func (c *Cache) GetContext(key interface{}, ttl time.Duration, generate func(ctx context.Context, key interface{}) (interface{}, error)) func() (interface{}, error) {
+	return c.get(key, GetOptions{TTL: ttl}, generate)
+}
+
+// interested wraps the retrieval function of a caller waiting on item, tracking its interest under CancelAbandoned.
+// The cache must be locked.
+func (c *Cache) interested(item *cacheItem, retrieve func() (interface{}, error)) func() (interface{}, error) {
+	if !c.CancelAbandoned || !item.pending {
+		return retrieve
+	}
+	item.interest++
+	var once sync.Once
+	return func() (interface{}, error) {
+		val, err := retrieve()
+		once.Do(func() {
+			c.loseInterest(item, err == ErrTimeout || contextError(err))
+		})
+		return val, err
+	}
+}
+
+// loseInterest records that a caller is done with item, cancelling its generation if the caller timed out
+// or gave up with its context and no other caller is still waiting.
+func (c *Cache) loseInterest(item *cacheItem, abandoned bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	item.interest--
+	if abandoned && item.interest == 0 && item.pending && item.cancel != nil {
+		item.abandoned = true
+		item.cancel()
+		c.stats.Cancellations++
+	}
+}
+
+// contextError reports whether err comes from a cancelled or expired context, which says nothing of the origin.
+func contextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultAppendLimit is the number of values an Append entry retains when AppendLimit is zero.
+const DefaultAppendLimit = 100
+
+// ErrNotAccumulator is returned by Append when key holds a value not created by Append.
+var ErrNotAccumulator = errors.New("Cache entry is not an accumulator")
+
+func (c *Cache) appendLimit() int {
+	if c.AppendLimit > 0 {
+		return c.AppendLimit
+	}
+	return DefaultAppendLimit
+}
+
+// Append adds value to the list of recent values held under key, dropping the oldest values
+// beyond AppendLimit. The entry is created with the given ttl if missing or expired,
+// and later appends leave its expiry untouched.
+//
+// The entry's value is a []interface{} ordered oldest first, which may be read with Get or GetIfPresent.
+// Each append stores a new slice, so slices already returned are never modified.
+// Storage is accounted incrementally and is approximate once values are dropped.
+func (c *Cache) Append(key, value interface{}, ttl time.Duration) error {
+	ttl = c.lifetime(ttl)
+	size := c.sizeOf(key, value)
+	c.lockMutable()
+	defer c.unlock()
+	item, ok := c.data[key]
+	if ok && (c.expired(item) || item.err != nil) {
+		c.remove(key)
+		ok = false
+	}
+	if !ok {
+		if ttl == 0 {
+			return nil
+		}
+		if c.oversized(key) {
+			c.stats.OversizedKeys++
+			return nil
+		}
+		item = &cacheItem{val: []interface{}{value}, future: &sync.WaitGroup{}, created: c.now(), size: size, origin: c.newOrigin(SourceSet, "", c.callerPC(1))}
+		c.setTTL(item, ttl)
+		c.insert(key, item)
+		return nil
+	}
+	values, isList := item.val.([]interface{})
+	if item.pending || !isList {
+		return ErrNotAccumulator
+	}
+	next := make([]interface{}, 0, len(values)+1)
+	if dropped := len(values) + 1 - c.appendLimit(); dropped > 0 {
+		shrink := item.size / uint64(len(values)) * uint64(dropped)
+		item.size -= shrink
+		c.storage -= shrink
+		values = values[dropped:]
+	}
+	item.val = append(append(next, values...), value)
+	item.size += size
+	c.storage += size
+	c.touched(key, item)
+	c.published(key, item)
+	c.pruneWhile(c.overLimit)
+	return nil
+}
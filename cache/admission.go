@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// AdmissionPolicy decides whether new keys are worth storing when storing them would evict another entry.
+type AdmissionPolicy interface {
+	// Record notes an access to key. It is called for every Get, hit or miss.
+	Record(key interface{})
+	// Admit reports whether candidate should replace victim in the cache.
+	Admit(candidate, victim interface{}) bool
+}
+
+// admissionWindow is implemented by admission policies that let every new key in through a small window,
+// as in W-TinyLFU, filtering keys only as they leave it.
+type admissionWindow interface {
+	// Enter adds key to the window, returning the key it displaces from the window, if any.
+	Enter(key interface{}) (interface{}, bool)
+}
+
+// admit reports whether key may be inserted, consulting Admission if inserting it would evict a live entry. The cache must be locked.
+//
+// With a windowed policy key is always admitted, and the key it displaces from the window instead competes
+// with the pruner's victim, being evicted itself if it is the less popular of the two.
+func (c *Cache) admit(key interface{}) bool {
+	if c.Admission == nil {
+		return true
+	}
+	window, windowed := c.Admission.(admissionWindow)
+	var graduate interface{}
+	graduated := false
+	if windowed {
+		graduate, graduated = window.Enter(key)
+	}
+	if !c.full() {
+		return true
+	}
+	victim := c.pruneCandidate(nil)
+	if victim == nil {
+		return true
+	}
+	if item := c.data[victim]; item.ttl == 0 || c.expired(item) {
+		return true
+	}
+	if windowed {
+		if graduated && graduate != victim && c.data[graduate] != nil && c.evictable(graduate, nil) && !c.Admission.Admit(graduate, victim) {
+			c.evict(graduate)
+			c.stats.Rejections++
+		}
+		return true
+	}
+	return c.Admission.Admit(key, victim)
+}
+
+const (
+	sketchDepth = 4
+	sketchMax   = 15 // Counters saturate, as in the 4-bit counters of the TinyLFU paper
+)
+
+// TinyLFU is an AdmissionPolicy admitting a key only if it has been requested more often than the entry it would evict.
+//
+// Access frequencies are estimated with a count-min sketch and periodically halved so the estimate
+// tracks recent popularity, keeping scans of one-off keys from flushing a frequently used working set.
+// There is no separate admission window, so once the cache is full a new key is usually stored on its
+// second request rather than its first; WindowTinyLFU adds the window of W-TinyLFU.
+type TinyLFU struct {
+	mutex     sync.Mutex
+	counters  [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+
+	// Hash, if set before the policy is used, replaces the default key hash.
+	Hash func(key interface{}) uint64
+}
+
+// NewTinyLFU creates a TinyLFU sized for a cache holding about capacity entries.
+func NewTinyLFU(capacity int) *TinyLFU {
+	if capacity < 1 {
+		capacity = 1
+	}
+	width := uint64(1)
+	for width < 8*uint64(capacity) { // Wide enough that keys seen but not cached rarely share every counter
+		width <<= 1
+	}
+	t := &TinyLFU{mask: width - 1, resetAt: 10 * capacity}
+	for i := range t.counters {
+		t.counters[i] = make([]uint8, width)
+	}
+	return t
+}
+
+// index returns the counter used for a hashed key in the given row.
+func (t *TinyLFU) index(h uint64, row int) uint64 {
+	h1, h2 := h&0xffffffff, h>>32|1
+	return (h1 + uint64(row)*h2) & t.mask
+}
+
+// Record increments the estimated frequency of key.
+func (t *TinyLFU) Record(key interface{}) {
+	h := hashWith(t.Hash, key)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i := range t.counters {
+		if j := t.index(h, i); t.counters[i][j] < sketchMax {
+			t.counters[i][j]++
+		}
+	}
+	t.additions++
+	if t.additions >= t.resetAt {
+		t.age()
+	}
+}
+
+// age halves every counter so that old popularity fades.
+func (t *TinyLFU) age() {
+	for i := range t.counters {
+		for j := range t.counters[i] {
+			t.counters[i][j] >>= 1
+		}
+	}
+	t.additions /= 2
+}
+
+// Estimate returns the estimated number of recent accesses to key.
+func (t *TinyLFU) Estimate(key interface{}) int {
+	h := hashWith(t.Hash, key)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	estimate := uint8(sketchMax)
+	for i := range t.counters {
+		if n := t.counters[i][t.index(h, i)]; n < estimate {
+			estimate = n
+		}
+	}
+	return int(estimate)
+}
+
+// Admit reports whether candidate has been accessed more often than victim.
+func (t *TinyLFU) Admit(candidate, victim interface{}) bool {
+	return t.Estimate(candidate) > t.Estimate(victim)
+}
+
+// WindowTinyLFU is a TinyLFU fronted by an admission window, as in W-TinyLFU. New keys are always stored,
+// passing through a window of about 1% of the cache; as a key leaves the window it is kept only if it has
+// been requested more often than the entry the cache would otherwise evict. Bursts of new keys therefore get
+// a chance to build up frequency, while a scan still displaces little more than the window.
+type WindowTinyLFU struct {
+	*TinyLFU
+	mutex    sync.Mutex
+	window   *list.List // Keys recently let in, newest first
+	elements map[interface{}]*list.Element
+	size     int
+}
+
+// NewWindowTinyLFU creates a WindowTinyLFU sized for a cache holding about capacity entries.
+func NewWindowTinyLFU(capacity int) *WindowTinyLFU {
+	size := capacity / 100
+	if size < 1 {
+		size = 1
+	}
+	return &WindowTinyLFU{TinyLFU: NewTinyLFU(capacity), window: list.New(), elements: make(map[interface{}]*list.Element), size: size}
+}
+
+// Enter adds key to the window, returning the key it displaces, if any.
+func (w *WindowTinyLFU) Enter(key interface{}) (interface{}, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if e, ok := w.elements[key]; ok {
+		w.window.MoveToFront(e)
+		return nil, false
+	}
+	w.elements[key] = w.window.PushFront(key)
+	if w.window.Len() <= w.size {
+		return nil, false
+	}
+	oldest := w.window.Remove(w.window.Back())
+	delete(w.elements, oldest)
+	return oldest, true
+}
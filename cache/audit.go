@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"reflect"
+	"time"
+)
+
+// Audit configures background verification of cached values against their source of truth, catching entries
+// left stale by missed invalidations. Every Interval one resident entry is sampled and regenerated with Generate,
+// outside the cache, and the fresh value compared with the cached one. The cached entry is never changed.
+type Audit struct {
+	Interval time.Duration
+	Generate func(key interface{}) (interface{}, error)
+
+	// Fingerprint, if set, identifies values for comparison in place of Cache.Fingerprint.
+	// Values are compared with reflect.DeepEqual if neither is set.
+	Fingerprint func(value interface{}) uint64
+
+	// OnDivergence, if set, is called with each sampled key whose cached value differs from its source.
+	OnDivergence func(key, cached, fresh interface{})
+}
+
+// audit samples one resident entry and verifies it against Audit.Generate in the background.
+func (c *Cache) audit() {
+	c.lockMap()
+	audit := c.Audit
+	if audit == nil || c.closed {
+		c.unlock()
+		return
+	}
+	var key, cached interface{}
+	var sampled *cacheItem
+	for k, item := range c.data { // Map iteration order makes this a random sample
+		if c.visible(item) && !item.stale {
+			key, cached, sampled = k, item.val, item
+			break
+		}
+	}
+	if sampled == nil {
+		c.unlock()
+		return
+	}
+	created := sampled.created
+	c.inflight.Add(1)
+	c.unlock()
+	go func() {
+		defer c.inflight.Done()
+		fresh, err := c.guard(key, func() (interface{}, error) { return audit.Generate(key) })
+		cached := c.expanded(cached)
+		diverged := err == nil && !c.sameValue(audit, cached, fresh)
+		c.lockMap()
+		if c.data[key] != sampled || !sampled.created.Equal(created) {
+			c.unlock() // Replaced while it was verified; the comparison means nothing
+			return
+		}
+		c.stats.Audits++
+		if err != nil {
+			c.stats.AuditErrors++
+		} else if diverged {
+			c.stats.AuditDivergences++
+			if onDivergence := audit.OnDivergence; onDivergence != nil {
+				c.events = append(c.events, func() { onDivergence(key, cached, fresh) })
+			}
+		}
+		c.unlock()
+	}()
+}
+
+// sameValue reports whether a cached value matches one freshly generated by an audit.
+func (c *Cache) sameValue(audit *Audit, cached, fresh interface{}) bool {
+	fingerprint := audit.Fingerprint
+	if fingerprint == nil {
+		fingerprint = c.Fingerprint
+	}
+	if fingerprint != nil {
+		return fingerprint(cached) == fingerprint(fresh)
+	}
+	return reflect.DeepEqual(cached, fresh)
+}
@@ -0,0 +1,31 @@
+package cache
+
+import "sync"
+
+// refreshRetained starts a refresh of every entry with a retained generator that is past its refresh point.
+// Only entries in retained are checked, so caches holding mostly Set or stored values aren't scanned in full.
+func (c *Cache) refreshRetained() {
+	c.lockMap()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
+	now := c.now()
+	for key, item := range c.retained {
+		if c.data[key] != item {
+			delete(c.retained, key) // Replaced without passing through remove
+			continue
+		}
+		if item.pending || item.refresh != nil || item.created.IsZero() || item.err != nil || item.ttl == 0 || c.expired(item) || isFieldSet(item.val) {
+			continue
+		}
+		if item.created.Add(c.refreshAge(item.ttl)).After(now) {
+			continue
+		}
+		var refresh sync.WaitGroup
+		refresh.Add(1)
+		item.refresh = &refresh
+		c.refreshStarted(key)
+		c.spawnGenerate(key, item, item.generate, &refresh)
+	}
+}
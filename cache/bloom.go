@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+// ErrAbsent is returned for keys known not to exist at the origin.
+// Generators may return it to record the key in the cache's Absent filter.
+var ErrAbsent = errors.New("Key is absent")
+
+// BloomFilter is a compact, probabilistic record of keys known to be absent at the origin.
+//
+// MightContain never misses a key passed to MarkAbsent, but may falsely report other keys with a
+// probability fixed when the filter is created. Keys cannot be removed individually; Reset clears the filter.
+type BloomFilter struct {
+	mutex  sync.RWMutex
+	bits   []uint64
+	hashes uint64
+
+	// Hash, if set before the filter is used, replaces the default key hash.
+	Hash func(key interface{}) uint64
+}
+
+// NewBloomFilter sizes a filter to hold expected keys with the given false positive rate.
+func NewBloomFilter(expected int, falsePositiveRate float64) *BloomFilter {
+	if expected < 1 {
+		expected = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	bits := math.Ceil(-float64(expected) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := math.Max(1, math.Round(bits/float64(expected)*math.Ln2))
+	return &BloomFilter{bits: make([]uint64, (uint64(bits)+63)/64), hashes: uint64(hashes)}
+}
+
+// positions calls fn with each bit index for a key, using double hashing.
+func (b *BloomFilter) positions(key interface{}, fn func(word int, mask uint64)) {
+	h := hashWith(b.Hash, key)
+	h1, h2 := h&0xffffffff, h>>32|1
+	n := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % n
+		fn(int(bit/64), 1<<(bit%64))
+	}
+}
+
+// MarkAbsent records that key does not exist at the origin.
+func (b *BloomFilter) MarkAbsent(key interface{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.positions(key, func(word int, mask uint64) {
+		b.bits[word] |= mask
+	})
+}
+
+// MightContain reports whether key may have been marked absent. A false result is definite.
+func (b *BloomFilter) MightContain(key interface{}) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	found := true
+	b.positions(key, func(word int, mask uint64) {
+		found = found && b.bits[word]&mask != 0
+	})
+	return found
+}
+
+// Reset forgets every key marked absent.
+func (b *BloomFilter) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i := range b.bits {
+		b.bits[i] = 0
+	}
+}
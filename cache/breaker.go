@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned in place of calling a generator while its circuit breaker is open.
+var ErrCircuitOpen = errors.New("Circuit breaker open")
+
+// CircuitBreaker stops calling generators after repeated failures, failing fast until the origin recovers.
+//
+// After Threshold consecutive failures the circuit opens and generations fail with ErrCircuitOpen, so entries
+// with StaleOnError keep serving their stale value and refreshes keep the current one. Once Cooldown has passed
+// a single generation is let through as a probe: success closes the circuit and failure reopens it.
+type CircuitBreaker struct {
+	Threshold int           // Consecutive failures opening the circuit, 5 if zero
+	Cooldown  time.Duration // How long the circuit stays open before probing, one second if zero
+
+	// Group, if set, keeps a separate circuit for each group of keys, such as a key prefix naming the origin.
+	Group func(key interface{}) string
+
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures int
+	openedAt time.Time // Zero while closed
+	probing  bool
+}
+
+func (b *CircuitBreaker) threshold() int {
+	if b.Threshold > 0 {
+		return b.Threshold
+	}
+	return 5
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown > 0 {
+		return b.Cooldown
+	}
+	return time.Second
+}
+
+// circuit returns the circuit for key. The breaker must be locked.
+func (b *CircuitBreaker) circuit(key interface{}) *circuit {
+	group := ""
+	if b.Group != nil {
+		group = b.Group(key)
+	}
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	cb, ok := b.circuits[group]
+	if !ok {
+		cb = &circuit{}
+		b.circuits[group] = cb
+	}
+	return cb
+}
+
+// Open reports whether the circuit for key is currently open.
+func (b *CircuitBreaker) Open(key interface{}) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return !b.circuit(key).openedAt.IsZero()
+}
+
+// allow reports whether a generation for key may run, and whether it is the probe of an open circuit.
+func (b *CircuitBreaker) allow(key interface{}, now time.Time) (allowed, probe bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	cb := b.circuit(key)
+	if cb.openedAt.IsZero() {
+		return true, false
+	}
+	if cb.probing || now.Sub(cb.openedAt) < b.cooldown() {
+		return false, false
+	}
+	cb.probing = true
+	return true, true
+}
+
+// record updates the circuit for key with the outcome of a generation.
+func (b *CircuitBreaker) record(key interface{}, probe bool, err error, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	cb := b.circuit(key)
+	if probe {
+		cb.probing = false
+	}
+	if contextError(err) {
+		return // The generation was cancelled rather than failing
+	}
+	if err == nil {
+		cb.failures = 0
+		cb.openedAt = time.Time{}
+		return
+	}
+	cb.failures++
+	if probe || cb.failures >= b.threshold() {
+		cb.openedAt = now
+	}
+}
+
+// throughBreaker wraps generate so that it is only called while Breaker allows it.
+func (c *Cache) throughBreaker(generate generator) generator {
+	breaker := c.Breaker
+	return func(ctx context.Context, key interface{}) (val interface{}, err error) {
+		allowed, probe := breaker.allow(key, c.now())
+		if !allowed {
+			return nil, ErrCircuitOpen
+		}
+		defer func() {
+			r := recover()
+			if r != nil {
+				err = fmt.Errorf("Generator panicked: %v", r)
+			}
+			breaker.record(key, probe, err, c.now()) // Even for a panic, so that a probe is never left running
+			if r != nil {
+				panic(r) // Handled under Recover
+			}
+		}()
+		return generate(ctx, key)
+	}
+}
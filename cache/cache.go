@@ -8,38 +8,125 @@ It does this by creating a cacheItem which contains a wait condition on the valu
 package cache
 
 import (
+	"context"
 	"errors"
+	"math"
+	"math/rand"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ericpauley/go-utils/memory"
 )
 
 type cacheItem struct {
-	lastUsed time.Time
-	created  time.Time
-	ttl      time.Duration
-	val      interface{}
-	err      error
-	future   *sync.WaitGroup
-	refresh  *sync.WaitGroup
-	size     uint64
+	lastUsed    time.Time
+	created     time.Time
+	ttl         time.Duration
+	val         interface{}
+	err         error
+	future      *sync.WaitGroup
+	refresh     *sync.WaitGroup
+	size        uint64
+	stale       bool // The item is expired and serving its last good value while regeneration is retried
+	indexed     interface{}
+	cost        uint64 // The caller-supplied size, overriding Sizer
+	keySize     uint64 // Storage accounted to the key while the item is in the map
+	pending     bool   // Callers must wait on future for a value
+	fingerprint uint64
+	delta       time.Duration // How long the current value took to generate
+	jitter      float64       // The entry's TTL multiplier under TTLJitter, zero until chosen
+	waiters     int
+	origin      *origin            // Set when TrackOrigin is enabled
+	interest    int                // Callers still waiting on a pending generation, under CancelAbandoned
+	cancel      context.CancelFunc // Cancels the pending generation's context
+	failures    int                // Consecutive failed regenerations of the current value
+	generate    generator          // Retained for background refresh under RefreshInterval
+	started     time.Time          // When the generation producing the current or pending value began
+	provenance  []string           // The tiers the current value was read through, set only for values from a ProvenanceStore
+	hits        uint64             // Gets and GetIfPresent calls answered by the entry
+	minDelta    time.Duration      // First generations quicker than this aren't kept, under MinGenerationTime
+	labels      map[string]string  // Caller metadata attached when the entry was created
+	priority    int                // Lower priorities are evicted first
+	abandoned   bool               // The pending generation was cancelled under CancelAbandoned
+	superseded  bool               // A Set replaced the entry while it was generated, so Store keeps the Set's value
+	expiry      *expiryEntry       // The entry's place in the expiry queue, nil if it can't expire
+}
+
+func (c *Cache) lastTouched(item *cacheItem) time.Time {
+	if !item.lastUsed.IsZero() && c.ExtendOnUse && item.err == nil {
+		return item.lastUsed
+	}
+	return item.created
 }
 
 func (c *Cache) expired(item *cacheItem) bool {
-	used := item.created
-	if !item.lastUsed.IsZero() && c.ExtendOnUse {
-		used = item.lastUsed
+	used := c.lastTouched(item)
+	return !used.IsZero() && item.ttl != 0 && used.Add(item.ttl).Before(c.now())
+}
+
+// inStaleGrace reports whether an item holds a good value that may still be served under StaleOnError.
+func (c *Cache) inStaleGrace(item *cacheItem) bool {
+	if item.ttl == NoExpiry {
+		return c.StaleOnError > 0 && item.err == nil // Adding the grace period would overflow
 	}
-	return !used.IsZero() && item.ttl != 0 && used.Add(item.ttl).Before(time.Now())
+	used := c.lastTouched(item)
+	return c.StaleOnError > 0 && item.err == nil && !used.IsZero() && item.ttl != 0 && used.Add(item.ttl+c.StaleOnError).After(c.now())
 }
 
 func (c *Cache) shouldRefresh(item *cacheItem) bool {
-	return c.Refresh && !item.created.IsZero() && item.ttl != 0 && item.created.Add(item.ttl/2).Before(time.Now())
+	if item.stale {
+		return true // Keep retrying the generator while serving a stale value
+	}
+	if !c.Refresh || item.created.IsZero() || item.ttl == 0 || isFieldSet(item.val) {
+		return false // Regenerating a field set would drop its fields
+	}
+	if c.RefreshBeta > 0 {
+		// XFetch: refresh with a probability rising towards expiry, scaled by how long generation takes
+		early := time.Duration(float64(item.delta) * c.RefreshBeta * -math.Log(1-rand.Float64()))
+		return !c.now().Add(early).Before(item.created.Add(item.ttl))
+	}
+	return item.created.Add(c.refreshAge(item.ttl)).Before(c.now())
+}
+
+// producedAt returns when the generation of item's current or pending value began, or when it was stored.
+func (c *Cache) producedAt(item *cacheItem) time.Time {
+	if !item.started.IsZero() {
+		return item.started
+	}
+	return item.created
+}
+
+// setTTL sets item's TTL, scaled by a TTLJitter factor chosen once per entry. The cache must be locked.
+func (c *Cache) setTTL(item *cacheItem, ttl time.Duration) {
+	if c.TTLJitter > 0 && item.jitter == 0 {
+		item.jitter = 1 + c.TTLJitter*(2*rand.Float64()-1)
+	}
+	if item.jitter != 0 && ttl != 0 && ttl != NoExpiry {
+		ttl = time.Duration(float64(ttl) * item.jitter)
+	}
+	item.ttl = ttl
+}
+
+// refreshAge returns how old an entry with the given ttl must be before Get refreshes it.
+func (c *Cache) refreshAge(ttl time.Duration) time.Duration {
+	if c.RefreshAfter > 0 {
+		return c.RefreshAfter
+	}
+	if c.RefreshFraction > 0 {
+		return time.Duration(float64(ttl) * c.RefreshFraction)
+	}
+	return ttl / 2
 }
 
 // Cache implements a cache
 type Cache struct {
+	// Published for ApproxSize and ApproxStorage whenever the lock is released.
+	// These come first to keep them 64-bit aligned for atomic access on 32-bit platforms.
+	approxEntries int64
+	approxStorage uint64
+
 	data        map[interface{}]*cacheItem
 	MaxSize     int
 	MaxStorage  uint64
@@ -49,86 +136,730 @@ type Cache struct {
 	GetTimeout  time.Duration
 	Recover     bool
 	storage     uint64
+	tombstones  map[interface{}]time.Time // When keys were deleted on the leader, for Apply
+	uncached    uint64                    // Gets made with a zero TTL, for Validate
+	expvarName  string                    // Published by NewCache once the configuration is valid, for WithExpvar
+	slots       chan struct{}             // Bounds concurrent generations under MaxConcurrentGenerations
+	frozen      bool                      // Writes wait while Freeze is held
+	thawed      *sync.Cond                // Signalled by Thaw
+	cardinality *hyperLogLog              // Keys requested, under TrackCardinality
+	latencies   *latencyTracker
+	subscribers map[int]func(Change) // Subscribe callbacks by ID
+	subscribed  int                  // The last subscriber ID issued
+	writes      chan func() error    // Store writes queued under WriteBehind
+	flushing    sync.WaitGroup       // Running WriteBehind flushers
+	listening   []func()             // Cancels the subscriptions made by Listen and FollowPeers
+	storageCap  *uint64              // Replaces MaxStorage as the storage limit once SetMaxStorage is called
+	pressured   uint64               // Lower storage limit set by MemoryPressure, zero if none
+	pinned      map[interface{}]bool // Keys exempt from eviction
+	priorities  map[int]int          // Settled, unpinned entries at each GetOptions.Priority, which the pruner may evict
+	expiries    expiryQueue          // Entries that can expire, soonest first
+
+	// AcceptRefresh, if set, is consulted before a refreshed value replaces the current one.
+	// Returning false keeps the current value and leaves the entry due for another refresh on the next Get.
+	// It is called with the cache locked and must not call back into the cache.
+	AcceptRefresh func(old, new interface{}) bool
+
+	// StaleOnError keeps serving the last successfully generated value for up to this long past its expiry
+	// when regenerating it fails, instead of propagating the error.
+	StaleOnError time.Duration
+
+	// ZeroTTL chooses what a TTL of zero means for Get, Set and the other calls taking one: by default the value
+	// is returned but not cached; under ZeroTTLForever it is cached without expiring, as with NoExpiry.
+	ZeroTTL ZeroTTLMode
+
+	// ErrorTTL caches failed generations for this long before the generator is retried.
+	// When zero errors are returned only to the callers waiting on that generation, as are context errors
+	// and those wrapped by DoNotCache always.
+	ErrorTTL time.Duration
+
+	// OnHitInspect, if set, is called whenever Get returns an existing value.
+	// A positive newTTL shortens the entry's remaining life to at most newTTL, and returning ok as false
+	// discards the value and regenerates it. It is called with the cache locked and must not call back into the cache.
+	OnHitInspect func(key, value interface{}, meta EntryInfo) (newTTL time.Duration, ok bool)
+
+	// SecondaryKey, if set, extracts a secondary index key from each generated value (nil for none),
+	// allowing entries to be found with LookupSecondary and removed with InvalidateSecondary.
+	SecondaryKey func(value interface{}) interface{}
+	secondary    map[interface{}]interface{}
+	peak         int // The largest len(data) since the map was last rebuilt
+
+	// MaxWaiters caps the number of Gets waiting on a single in-flight generation.
+	// Gets beyond the cap are answered immediately according to WaiterOverflow.
+	MaxWaiters     int
+	WaiterOverflow OverflowPolicy
+	OverflowValue  interface{} // The value returned under OverflowDefault
+
+	// CompactRatio rebuilds the internal map once the number of entries falls below this fraction of its peak,
+	// releasing the memory Go maps retain after mass eviction. Zero disables automatic compaction.
+	CompactRatio float64
+
+	// Sizer, if set, replaces the reflection-based estimate of each value's storage cost.
+	// It is only consulted when MaxStorage is set.
+	Sizer func(key, value interface{}) uint64
+
+	// PurgeInterval, if set, starts a background goroutine purging expired entries at this interval.
+	// The goroutine is started on first use and stopped by Close.
+	PurgeInterval time.Duration
+	janitor       chan struct{}
+	closed        bool
+
+	// OnExpire, if set, is called with each successfully generated value purged because it expired, whether by
+	// the PurgeInterval goroutine, Purge or the incremental purging done by Get. It is called before OnEvict,
+	// once the cache lock is released.
+	OnExpire func(key, value interface{})
+
+	// RefreshInterval, if set, retains the generator of each entry created by Get and checks entries at this
+	// interval from the same background goroutine, refreshing those past their refresh point (see RefreshFraction)
+	// whether or not they are being used. Entries refreshed this way do not expire until refreshing fails or they
+	// are evicted, so choose an interval well below the TTL and rely on MaxSize to drop unused keys.
+	RefreshInterval time.Duration
+	retained        map[interface{}]*cacheItem // Entries holding a generator for RefreshInterval
+
+	// Fingerprint, if set, identifies values so that refreshes producing an unchanged value reuse its size
+	// instead of estimating it again. Zero is treated as "no fingerprint".
+	Fingerprint func(value interface{}) uint64
+	stats       Stats
+
+	// MemoryPressure, if set, lowers the storage limit below MaxStorage while the process's heap is near
+	// its high-water mark, shedding entries to make room for the rest of the process. MaxStorage must be set.
+	MemoryPressure *MemoryPressure
+
+	// Audit, if set, verifies a sample of cached values against their source in the background,
+	// counting divergences in Stats. See Audit.
+	Audit *Audit
+
+	// OnEvict, if set, is called with each successfully generated value that leaves the cache,
+	// whether through pruning, expiry, deletion or Close. It is called after the cache lock is released.
+	OnEvict func(key, value interface{})
+
+	// OnStore and OnRelease, if set, bracket the life of each value held by the cache, for values wrapping
+	// resources that must be cleaned up. OnStore is called when a value is stored, whether generated, refreshed
+	// or set, and OnRelease exactly once when it stops being held: when a refresh or Set replaces it, or when
+	// its entry is evicted, expired, deleted, cleared or closed. Values that are generated but never stored,
+	// such as refreshes rejected by AcceptRefresh, are not reported. Append, Increment and Patch update values in
+	// place and are reported once, with the latest value on release. Both are called after the cache lock is released.
+	OnStore   func(key, value interface{})
+	OnRelease func(key, value interface{})
+
+	// OnEvictCandidate, if set, is asked before an entry is pruned to make room and may veto the eviction.
+	// Each entry is vetoed at most once per prune, and at most EvictVetoBudget vetoes (default 8) are honored,
+	// after which candidates are evicted regardless. It is called with the cache locked and must not call back into the cache.
+	OnEvictCandidate func(key interface{}, meta EntryInfo) EvictDecision
+	EvictVetoBudget  int
+
+	// Faults, if set, injects delays and failures for soak testing.
+	Faults   FaultInjector
+	events   []func() // Callbacks to run once the lock is released
+	inflight sync.WaitGroup
+
+	// AppendLimit bounds the number of values retained by Append, DefaultAppendLimit if zero.
+	AppendLimit int
+
+	// RefreshBeta, if positive, replaces the half-TTL refresh trigger with probabilistic early expiration (XFetch).
+	// Each Get refreshes an entry when now - delta*RefreshBeta*ln(rand()) passes its expiry, where delta is how long
+	// the value took to generate, spreading refreshes out across callers and processes. 1.0 is a sensible default;
+	// larger values refresh earlier. Only used when Refresh is set.
+	RefreshBeta float64
+
+	// TTLJitter spreads out the expiry of entries created together by scaling each entry's TTL by a random factor
+	// within ±TTLJitter (0.1 for ±10%), chosen once per entry. Zero disables jitter; it must be below 1.
+	TTLJitter float64
+
+	// RefreshFraction sets how far through its TTL an entry must be before Get refreshes it, 0.5 if zero.
+	// RefreshAfter, if set, instead refreshes entries once they are that old, regardless of TTL.
+	RefreshFraction float64
+	RefreshAfter    time.Duration
+
+	// Absent, if set, records keys whose generator returned ErrAbsent. Later misses for those keys fail
+	// with ErrAbsent without invoking the generator. False positives make present keys appear absent
+	// until the filter is Reset, so size it generously and reset it when the origin's key set changes.
+	Absent *BloomFilter
+
+	// Admission, if set, decides whether a newly generated key may displace the entry the pruner would evict for it.
+	// Rejected keys are still generated for their callers but not stored. It is called with the cache locked.
+	Admission AdmissionPolicy
+	rejected  map[interface{}]*cacheItem // Pending generations of keys Admission turned away
+
+	// Codec encodes keys and values for SaveTo and LoadFrom, GobCodec if nil.
+	Codec Codec
+
+	// SchemaVersion is recorded in snapshots, and LoadFrom ignores snapshots saved under another version.
+	// Bump it whenever cached value types change incompatibly.
+	SchemaVersion string
+
+	// TrackOrigin records how each entry was created, its namespace and the calling function, for Info and Dump.
+	// Capturing the caller costs a stack walk per new entry.
+	TrackOrigin bool
+
+	// Store, if set, is a second tier consulted on a miss before the generator is called.
+	// Generated values are written through to it with the Get's TTL, as are Set and Delete.
+	Store SecondaryStore
+
+	// Spill, if set, receives entries evicted to make room while they still have time to live, instead of them being
+	// dropped, and is consulted on a miss before Store and the generator. A reloaded entry is removed from Spill
+	// and keeps its original generation time. The diskstore subpackage provides a bounded on-disk Spill.
+	Spill ProvenanceStore
+
+	// Scope, if set, is a process-level label such as a region or partition ("us-east/blue") that qualifies
+	// keys shared through Store, snapshots and Invalidator as ScopedKey values, so that processes in different
+	// scopes can share a store, snapshot or channel without reading or invalidating each other's entries.
+	// Local keys are unaffected.
+	Scope string
+
+	// Eviction, if set, chooses the entries pruned to make room in place of the sampled LRU, which also prefers
+	// expired entries. Entries vetoed by OnEvictCandidate are reported to it as accessed.
+	Eviction EvictionPolicy
+	// DeterministicEviction, without an Eviction policy, replaces the sampled LRU with an exact one that scans
+	// every entry and breaks ties by key, so tests asserting on which entries are evicted don't depend on Go's
+	// randomized map iteration. Each eviction costs time proportional to the number of entries.
+	DeterministicEviction bool
+
+	// OnHit and OnMiss, if set, are called for each Get that finds an existing entry or starts a generation.
+	// OnRefreshStart and OnRefreshEnd, if set, are called when an existing entry's value begins regenerating
+	// and when the generator returns, with its error. All are called after the cache lock is released.
+	OnHit          func(key interface{})
+	OnMiss         func(key interface{})
+	OnRefreshStart func(key interface{})
+	OnRefreshEnd   func(key interface{}, err error)
+
+	// Hash, if set, replaces the default key hash used by KeyDistribution, for instance with a seeded maphash
+	// to reproduce a production distribution in tests. BloomFilter and TinyLFU take their own Hash.
+	Hash func(key interface{}) uint64
+
+	// MaxConcurrentGenerations, if positive, bounds how many generators (including refreshes and GetMulti batches)
+	// run at once. Further misses queue until a generator finishes, and GetTimeout still applies while queued.
+	// Generators that wait on other keys of the same cache can deadlock once every slot is taken.
+	MaxConcurrentGenerations int
+
+	// SetPolicy decides between a Set and a generation of the same key that is already in flight.
+	SetPolicy SetPolicy
+
+	// CancelAbandoned cancels the context passed to a GetContext generator once every caller waiting on it
+	// has timed out, discarding the result. When unset generations always run to completion and are cached.
+	CancelAbandoned bool
+
+	// MaxRefreshFailures, if positive, evicts an entry once this many consecutive attempts to regenerate its value
+	// have failed, instead of continuing to serve the old value, and calls OnRefreshExhausted with the last error
+	// after the cache lock is released.
+	MaxRefreshFailures int
+	OnRefreshExhausted func(key interface{}, err error)
+
+	// Retry, if set, retries failed generations before their error is reported to the waiting callers,
+	// so that transient failures don't send every caller back to the generator.
+	Retry *RetryPolicy
+
+	// HedgeDelay, if positive, starts a second generator call for a key whose first call hasn't returned
+	// within the delay, answering with whichever succeeds first and cancelling the other's context.
+	// Setting it around the generator's 95th percentile latency trims the tail at the cost of a few
+	// percent more generator calls. Generators must tolerate running concurrently for the same key.
+	HedgeDelay time.Duration
+
+	// RateLimit, if set, throttles generator calls: a generation it doesn't allow fails with ErrThrottled
+	// without calling the generator, so entries with StaleOnError keep serving their stale value and refreshes
+	// keep the current one. KeyRateLimit, if set, returns a further limiter for a key, such as one shared by
+	// keys with the same prefix, or nil to apply RateLimit alone. Hedging calls are throttled too, and
+	// skipped when refused. Values read from Store or Spill are not throttled.
+	RateLimit    RateLimiter
+	KeyRateLimit func(key interface{}) RateLimiter
+
+	// Breaker, if set, stops calling generators for Get after repeated failures. See CircuitBreaker.
+	Breaker *CircuitBreaker
+
+	// Clock, if set, replaces the system clock for expiry, refresh, timeouts and backoff, and drives the
+	// PurgeInterval, RefreshInterval, Audit and MemoryPressure tickers.
+	Clock Clock
+
+	// TrackCardinality counts the distinct keys requested with Get for KeyCardinality, in a fixed 16KB.
+	TrackCardinality bool
+
+	// OnPanic, if set, is called with the value and stack trace of each generator panic that Recover converts
+	// into an error, before callers receive the error. For GetMulti's batch generator, key holds the []interface{}
+	// of keys requested.
+	OnPanic func(key interface{}, recovered interface{}, stack []byte)
+
+	// TrackLatency records the latency of generations started by Get in each GetOptions.Namespace,
+	// for GenerationLatency and SuggestedTimeout.
+	TrackLatency bool
+	// AutoTimeout, if set, tracks latency as TrackLatency and gives Gets without their own Timeout the
+	// SuggestedTimeout for their namespace, clamped to the bounds, instead of GetTimeout.
+	// GetTimeout still applies until enough generations have been seen.
+	AutoTimeout *TimeoutBounds
+
+	// MinGenerationTime, if positive, keeps only values whose first generation took at least this long;
+	// cheaper values are returned to their callers but not cached, leaving MaxStorage to the values that
+	// save time. NamespaceMinGenerationTime overrides it for Gets in a GetOptions.Namespace. Values read
+	// from Store or Spill are kept regardless.
+	MinGenerationTime          time.Duration
+	NamespaceMinGenerationTime map[string]time.Duration
+
+	// Replica makes the cache a read-only follower of a leader cache, holding only entries given to Apply.
+	// Get never calls its generator, returning ErrNotReplicated for keys without a replicated entry.
+	Replica bool
+	// MaxStaleness, if set, bounds how long after generation on the leader a replicated value is served;
+	// older values are treated as missing until a newer one arrives.
+	MaxStaleness time.Duration
+
+	// WriteBehind, if set, queues Store writes to be applied in the background instead of making callers wait.
+	WriteBehind *WriteBehind
+
+	// MaxKeySize, if set, stops keys larger than this many bytes from being cached: Gets for them call the
+	// generator without coalescing, and Sets, Increments, Appends, loaded and replicated entries are discarded.
+	// Strings are measured by length, other keys with memory.Sizeof.
+	MaxKeySize int
+
+	// Compression, if set, keeps large string and []byte values compressed while they are cached.
+	Compression *Compression
+
+	// Invalidator, if set, is told of every key removed by Delete and InvalidateFunc or replaced by Set and Patch,
+	// so that other caches can drop their copies. Call Listen to receive other caches' invalidations.
+	Invalidator Invalidator
+	// Announcer, if set, is told the key of every Get miss, so that caches calling FollowPeers can prefetch the
+	// keys their peers needed, smoothing load when traffic shifts between instances. Any Invalidator transport
+	// can carry announcements, on a channel separate from invalidations.
+	Announcer Invalidator
+}
+
+// full reports whether the cache must evict an entry before another can be added.
+func (c *Cache) full() bool {
+	limit := c.storageLimit()
+	return len(c.data) > 0 && (len(c.data) >= c.MaxSize || (limit != 0 && c.storage > limit))
+}
+
+// pruneCandidate returns Eviction's victim or, without a policy, samples entries not in vetoed for one to evict,
+// preferring expired entries and then the least recently used. It returns nil if the only candidate is vetoed.
+// Pinned keys are never returned, and while entries of different priorities are held only those of the lowest
+// are; when the policy's victim is pinned or of a higher priority, entries are sampled instead.
+func (c *Cache) pruneCandidate(vetoed map[interface{}]bool) interface{} {
+	lowest, prioritized := c.lowestPriority(vetoed) // Without a settled candidate, the sampler takes whatever it can
+	if c.Eviction != nil {
+		// A policy ignoring accesses, like FIFO, can keep offering a vetoed key, so the sampler picks past it
+		if key, ok := c.Eviction.Victim(); ok && c.data[key] != nil && !c.pinned[key] && c.data[key].priority <= lowest && !vetoed[key] {
+			return key
+		}
+	}
+	if c.DeterministicEviction {
+		return c.orderedCandidate(vetoed)
+	}
+	checked := 0
+	var candidateKey interface{}
+	for k, v := range c.data {
+		if !c.evictable(k, vetoed) {
+			continue
+		} else if v.ttl == 0 || c.expired(v) { // Expired keys are immediate candidates for removal
+			return k
+		} else if prioritized && v.priority > lowest {
+			continue
+		} else if candidateKey == nil {
+			candidateKey = k
+		} else if c.data[candidateKey].lastUsed.IsZero() {
+			candidateKey = k
+		} else if !v.lastUsed.IsZero() && v.lastUsed.Before(c.data[candidateKey].lastUsed) {
+			candidateKey = k
+		}
+		checked++
+		if checked >= 5 {
+			break
+		}
+	}
+	return candidateKey
 }
 
 func (c *Cache) prune() {
-	for len(c.data) > 0 && (len(c.data) >= c.MaxSize || (c.MaxStorage != 0 && c.storage > c.MaxStorage)) {
-		checked := 0
-		var candidateKey interface{}
-		for k, v := range c.data {
-			if v.ttl == 0 || c.expired(v) { // Expired keys are immediate candidates for removal
-				candidateKey = k
-				break
-			} else if candidateKey == nil {
-				candidateKey = k
-			} else if c.data[candidateKey].lastUsed.IsZero() {
-				candidateKey = k
-			} else if !v.lastUsed.IsZero() && v.lastUsed.Before(c.data[candidateKey].lastUsed) {
-				candidateKey = k
+	c.pruneWhile(c.full)
+}
+
+// pruneWhile evicts entries until over reports false. The cache must be locked.
+func (c *Cache) pruneWhile(over func() bool) {
+	var vetoed map[interface{}]bool
+	vetoes := 0
+	for over() {
+		candidateKey := c.pruneCandidate(vetoed)
+		if candidateKey == nil && vetoed == nil { // Everything left is pinned
+			return
+		}
+		if candidateKey == nil { // Everything left was vetoed, stop honoring vetoes
+			vetoed, vetoes = nil, c.evictVetoBudget()
+			continue
+		}
+		if c.vetoEviction(candidateKey, vetoes) {
+			if vetoed == nil {
+				vetoed = make(map[interface{}]bool)
 			}
-			checked++
-			if checked >= 5 {
-				break
+			vetoed[candidateKey] = true
+			vetoes++
+			if c.Eviction != nil {
+				c.Eviction.OnAccess(candidateKey) // Let the policy offer another victim
 			}
+			continue
 		}
-		c.remove(candidateKey)
+		c.evict(candidateKey)
+	}
+}
+
+// evict removes an entry to make room, spilling it first. The cache must be locked.
+func (c *Cache) evict(key interface{}) {
+	c.spill(key, c.data[key])
+	c.remove(key)
+	c.stats.Evictions++
+}
+
+// insert makes room for and adds a new item to the cache.
+func (c *Cache) insert(key interface{}, item *cacheItem) {
+	c.prune()
+	c.data[key] = item
+	if c.counted(key, item) {
+		c.countPriority(item.priority, 1)
+	}
+	item.keySize = c.keyStorage(key)
+	c.storage += item.size + item.keySize
+	if c.Eviction != nil {
+		c.Eviction.OnAdd(key)
+	}
+	if !item.created.IsZero() && item.err == nil {
+		c.stored(key, item) // Stored directly rather than generated
+	}
+	c.schedule(key, item)
+	if item.generate != nil {
+		if c.retained == nil {
+			c.retained = make(map[interface{}]*cacheItem)
+		}
+		c.retained[key] = item
+	}
+	if len(c.data) > c.peak {
+		c.peak = len(c.data)
 	}
 }
 
 func (c *Cache) remove(candidateKey interface{}) {
-	c.storage -= c.data[candidateKey].size
+	item := c.data[candidateKey]
+	c.evicted(candidateKey, item)
+	c.storage -= item.size + item.keySize
+	c.unindex(candidateKey, item)
+	if c.counted(candidateKey, item) {
+		c.countPriority(item.priority, -1)
+	}
+	c.unschedule(item)
 	delete(c.data, candidateKey)
+	if c.retained[candidateKey] == item {
+		delete(c.retained, candidateKey)
+	}
+	if c.Eviction != nil {
+		c.Eviction.OnRemove(candidateKey)
+	}
+}
+
+func (c *Cache) generateItem(ctx context.Context, key interface{}, item *cacheItem, generate generator, future *sync.WaitGroup) {
+	c.settleItem(ctx, key, item, func(ctx context.Context, key interface{}) (interface{}, error) {
+		return c.retry(ctx, key, generate)
+	}, future)
 }
 
-func (c *Cache) generateItem(key interface{}, item *cacheItem, generate func(interface{}) (interface{}, error), future *sync.WaitGroup) {
+// settleItem calls generate once for item, without retries, and stores its result, completing future.
+func (c *Cache) settleItem(ctx context.Context, key interface{}, item *cacheItem, generate generator, future *sync.WaitGroup) {
 	var val interface{}
 	var err error
+	if c.Faults != nil {
+		c.sleep(c.Faults.GenerateDelay(key))
+	}
+	start := c.now()
 	func() {
 		if c.Recover {
 			defer func() {
 				if r := recover(); r != nil {
 					val = nil
-					err = errors.New("Unknown Error")
+					err = c.recovered(key, r)
 				}
 			}()
 		}
-		val, err = generate(key)
-
+		if c.Faults != nil && c.Faults.ForcePanic(key) {
+			panic("Injected generator panic")
+		}
+		val, err = generate(ctx, key)
 	}()
-	var size uint64
-	if val != nil && c.MaxStorage > 0 {
-		func() {
-			defer func() {
-				recover()
-			}()
-			size = memory.Sizeof(val)
-		}()
+	elapsed := c.since(start)
+	var created time.Time
+	var provenance []string
+	if p, ok := val.(provenanced); ok {
+		// Read from another tier; keep the original generation time so the value's lifetime isn't extended
+		val, provenance = p.val, p.provenance
+		if !p.generated.IsZero() {
+			start, created = p.generated, p.generated
+		}
 	}
+	raw := val
+	val = c.compress(val)
+	size, fingerprint, reused := c.measure(key, item, raw, val)
 	c.lockMap()
-	defer c.mutex.Unlock()
+	defer c.unlock()
+	if item.cost != 0 && item.cost != size {
+		size, fingerprint, reused = item.cost, 0, false // A Get joining the generation raised its Cost
+	}
+	if onEnd := c.OnRefreshEnd; onEnd != nil && !item.created.IsZero() {
+		defer func() {
+			c.events = append(c.events, func() { onEnd(key, err) })
+		}()
+	}
+	c.stats.recordGeneration(elapsed, err)
+	if err == ErrAbsent && c.Absent != nil {
+		c.Absent.MarkAbsent(key)
+	}
+	c.setPending(key, item, false)
+	if c.rejected[key] == item {
+		delete(c.rejected, key)
+	}
+	if item.abandoned {
+		// Nobody is waiting for the cancelled generation, so its result isn't kept
+		item.abandoned = false
+		if c.data[key] == item {
+			c.remove(key)
+		}
+		item.val, item.err = nil, err
+		item.refresh = nil
+		future.Done()
+		return
+	}
+	if reused {
+		c.stats.SizingsSkipped++
+	} else if val != nil && c.MaxStorage > 0 && item.cost == 0 {
+		c.stats.Sizings++
+	}
+	if err != nil && !item.created.IsZero() && item.err == nil {
+		item.failures++
+		if c.MaxRefreshFailures > 0 && item.failures >= c.MaxRefreshFailures {
+			// Give up on the entry rather than serve an ever older value
+			if c.data[key] == item {
+				c.remove(key)
+			}
+			item.val, item.err = nil, err
+			item.refresh = nil
+			if onExhausted := c.OnRefreshExhausted; onExhausted != nil {
+				c.events = append(c.events, func() { onExhausted(key, err) })
+			}
+			future.Done()
+			return
+		}
+	} else if err == nil {
+		item.failures = 0
+	}
+	if item.refresh != nil && err == nil && c.AcceptRefresh != nil && !c.AcceptRefresh(c.expanded(item.val), raw) {
+		// Keep serving the current value; created is left alone so the next Get retries the refresh
+		item.refresh = nil
+		future.Done()
+		return
+	}
+	if err != nil && item.stale {
+		// Fall back to the previous value; created is left alone so the grace period still runs out
+		item.refresh = nil
+		future.Done()
+		return
+	}
+	item.stale = false
+	installed := false
 	if err == nil || item.refresh == nil { // Only propogate errors if this isn't a refresh
+		cached := c.data[key] == item
+		if cached {
+			c.released(key, item) // The previous value, if any
+		}
 		item.val, item.err = val, err
-		if c.data[key] == item { // Only update if item is still in the cache
+		if cached { // Only update if item is still in the cache
+			installed = err == nil
 			c.storage -= item.size
 			c.storage += size
+			c.index(key, item)
 		}
 		item.size = size
+		item.fingerprint = fingerprint
+		item.delta = elapsed
+		item.started = start
+		item.provenance = provenance
+		if item.origin != nil && !item.created.IsZero() {
+			item.origin.source = SourceRefresh
+		}
+	}
+	if item.err == nil && item.ttl != 0 && item.created.IsZero() && provenance == nil && elapsed < item.minDelta && !isFieldSet(item.val) {
+		item.ttl = 0 // Cheap enough to regenerate; dropped below
+		c.stats.CheapValues++
 	}
-	if item.refresh == nil && (item.err != nil || item.ttl == 0) {
+	if item.refresh == nil && item.err != nil && item.ttl != 0 && c.ErrorTTL > 0 && cacheableError(item.err) {
+		item.ttl = c.ErrorTTL // Negatively cache the error
+	} else if item.refresh == nil && (item.err != nil || item.ttl == 0) {
 		if c.data[key] == item {
 			c.remove(key) // Don't allow anything else to use this error/instant result
 		}
 	}
-	item.created = time.Now()
+	if created.IsZero() {
+		created = c.now()
+	}
+	item.created = created
 	item.refresh = nil // Clear out a refresh channel if there is one
+	if installed && c.data[key] == item {
+		c.stored(key, item)
+	}
+	if c.data[key] == item {
+		c.schedule(key, item)
+	}
 	future.Done()
 }
 
+// refreshStarted queues OnRefreshStart for key. The cache must be locked.
+func (c *Cache) refreshStarted(key interface{}) {
+	if onStart := c.OnRefreshStart; onStart != nil {
+		c.events = append(c.events, func() { onStart(key) })
+	}
+}
+
+// guard calls fn, converting panics into errors if Recover is set.
+func (c *Cache) guard(key interface{}, fn func() (interface{}, error)) (val interface{}, err error) {
+	if c.Recover {
+		defer func() {
+			if r := recover(); r != nil {
+				val = nil
+				err = c.recovered(key, r)
+			}
+		}()
+	}
+	return fn()
+}
+
+// recovered reports a panic recovered from the generator for key to OnPanic, returning the error callers receive instead.
+func (c *Cache) recovered(key, r interface{}) error {
+	if c.OnPanic != nil {
+		c.OnPanic(key, r, debug.Stack())
+	}
+	return errors.New("Unknown Error")
+}
+
+// sizeOf estimates the storage used by val, or zero if storage isn't limited.
+func (c *Cache) sizeOf(key, val interface{}) (size uint64) {
+	if v, ok := val.(*compressed); ok && c.MaxStorage > 0 {
+		return uint64(len(v.data))
+	}
+	if val != nil && c.MaxStorage > 0 {
+		defer func() {
+			if recover() != nil {
+				size = 0
+			}
+		}()
+		if c.Faults != nil && c.Faults.FailSizing(key) {
+			panic("Injected sizing failure")
+		}
+		if c.Sizer != nil {
+			return c.Sizer(key, val)
+		}
+		size = memory.Sizeof(val)
+	}
+	return
+}
+
+// keySize measures key for MaxKeySize and MaxStorage.
+func keySize(key interface{}) uint64 {
+	if s, ok := key.(string); ok {
+		return uint64(len(s))
+	}
+	return memory.Sizeof(key)
+}
+
+// keyStorage returns the storage accounted to key, or zero if storage isn't limited.
+func (c *Cache) keyStorage(key interface{}) uint64 {
+	if c.MaxStorage == 0 {
+		return 0
+	}
+	return keySize(key)
+}
+
+// oversized reports whether key exceeds MaxKeySize.
+func (c *Cache) oversized(key interface{}) bool {
+	return c.MaxKeySize > 0 && keySize(key) > uint64(c.MaxKeySize)
+}
+
+// minGenerationTime returns how long a first generation for a Get in namespace must take for its value to be kept.
+func (c *Cache) minGenerationTime(namespace string) time.Duration {
+	if d, ok := c.NamespaceMinGenerationTime[namespace]; ok {
+		return d
+	}
+	return c.MinGenerationTime
+}
+
+// measure sizes a value generated for item, reusing the item's current size if Fingerprint reports the value unchanged.
+// raw is the value as generated and val the form in which it is cached.
+func (c *Cache) measure(key interface{}, item *cacheItem, raw, val interface{}) (size, fingerprint uint64, reused bool) {
+	c.mutex.Lock()
+	cost, previous, previousSize := item.cost, item.fingerprint, item.size
+	c.mutex.Unlock()
+	if cost != 0 {
+		return cost, 0, false
+	}
+	if c.Fingerprint != nil && raw != nil && c.MaxStorage > 0 {
+		fingerprint = c.Fingerprint(raw)
+		if previous != 0 && previous == fingerprint {
+			return previousSize, fingerprint, true
+		}
+	}
+	return c.sizeOf(key, val), fingerprint, false
+}
+
+// spawnGenerate runs generateItem in a goroutine that Close waits for. The cache must be locked.
+func (c *Cache) spawnGenerate(key interface{}, item *cacheItem, generate generator, future *sync.WaitGroup) {
+	allow := c.rateLimited()
+	if c.HedgeDelay > 0 {
+		generate = c.throughHedge(generate, allow)
+	}
+	generate = c.throughTiers(generate, item, allow)
+	if future == item.future {
+		item.started = c.now()
+	}
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if c.CancelAbandoned && future == item.future { // Refreshes have no waiters to abandon them
+		ctx, cancel = context.WithCancel(ctx)
+		item.cancel = cancel
+	}
+	slots := c.generationSlots()
+	c.inflight.Add(1)
+	go func() {
+		defer c.inflight.Done()
+		defer cancel()
+		defer acquireSlot(slots)()
+		c.generateItem(ctx, key, item, generate, future)
+	}()
+}
+
+// throughTiers wraps generate in the RateLimit and Breaker checks and the Store and Spill lookups that every
+// generation of item passes through. The cache must be locked.
+func (c *Cache) throughTiers(generate generator, item *cacheItem, allow func(key interface{}) bool) generator {
+	if allow != nil {
+		generate = c.throughRateLimit(generate, allow)
+	}
+	if c.Breaker != nil {
+		generate = c.throughBreaker(generate)
+	}
+	if c.Store != nil {
+		generate = c.throughStore(generate, item)
+	}
+	if c.Spill != nil {
+		generate = c.throughSpill(generate)
+	}
+	return generate
+}
+
+// unlock releases the cache lock and then runs the callbacks queued while it was held.
+func (c *Cache) unlock() {
+	events := c.events
+	c.events = nil
+	atomic.StoreInt64(&c.approxEntries, int64(len(c.data)))
+	atomic.StoreUint64(&c.approxStorage, c.storage)
+	c.mutex.Unlock()
+	for _, event := range events {
+		event()
+	}
+}
+
 func (c *Cache) lockMap() {
 	c.mutex.Lock()
 	if c.data == nil {
 		c.data = make(map[interface{}]*cacheItem)
 	}
+	c.startJanitor()
 }
 
 // Get begins the process of fetching a specific cache item.
@@ -142,104 +873,565 @@ func (c *Cache) lockMap() {
 //
 // Expiration/Refresh conditions are evaluated immediately upon calling Get(),
 // the retrieval function returns the cache query as it was evaluated during the Get operation.
+//
+// A ttl of NoExpiry caches the value until it is evicted. A ttl of zero returns the value without caching it,
+// unless ZeroTTL is ZeroTTLForever.
 func (c *Cache) Get(key interface{}, ttl time.Duration, generate func(interface{}) (interface{}, error)) func() (interface{}, error) {
+	return c.get(key, GetOptions{TTL: ttl}, withoutContext(generate))
+}
+
+// GetWithCost behaves like Get, but accounts a newly generated value as using cost bytes of storage
+// instead of estimating its size.
+func (c *Cache) GetWithCost(key interface{}, ttl time.Duration, cost uint64, generate func(interface{}) (interface{}, error)) func() (interface{}, error) {
+	return c.get(key, GetOptions{TTL: ttl, Cost: cost}, withoutContext(generate))
+}
+
+// GetOptions configures a single call to GetWithOptions.
+//
+// Gets of a key whose first generation is still running share that generation, and their TTL and Cost are merged
+// into the entry regardless of arrival order: a zero TTL, asking for the value not to be cached, wins over any
+// other, then the longest TTL and the largest Cost. Timeout and Transform are never merged; each caller keeps
+// its own. Store is written with the TTL of the Get that started the generation.
+type GetOptions struct {
+	TTL       time.Duration // The entry's time to live, as passed to Get
+	Timeout   time.Duration // Overrides the cache's GetTimeout for this call if non-zero
+	Cost      uint64        // Overrides the estimated size of a newly generated value if non-zero
+	Namespace string        // Recorded in the origin of a newly generated entry when TrackOrigin is set
+
+	// Labels, if set, are attached to a newly generated entry and kept across its refreshes, for application
+	// bookkeeping such as the shard or schema version a value came from. They are reported in EntryInfo and
+	// Dump. The map is kept rather than copied, so it must not be modified afterwards.
+	Labels map[string]string
+
+	// Pinned pins key as Pin does before looking it up.
+	Pinned bool
+
+	// Priority ranks a newly generated entry for eviction: entries are only evicted while none of a lower
+	// priority remain, whatever their recency, so cheap-to-rebuild values can be given up before costly ones.
+	// Expired entries go first regardless. The default is zero, and SetPriority changes it later.
+	Priority int
+
+	// MinFreshness, if set, only accepts a value whose generation began after this time, regenerating the entry
+	// otherwise. A caller that has just written to the origin can pass the time of its write to read it back.
+	// A value found in Store is accepted regardless.
+	MinFreshness time.Time
+
+	// Transform, if set, is applied to the value before it is returned to this caller, leaving the cached value
+	// untouched, so callers can cheaply reshape a shared value. It must not modify its argument in place.
+	// Its error, if any, is returned to this caller alone. It is not applied to errors.
+	Transform func(interface{}) (interface{}, error)
+
+	source Source
+	caller uintptr
+	report *report         // Receives how the value was served, for GetResult
+	ctx    context.Context // Abandons the wait once done, for WarmGraph
+	batch  *cacheItem      // The entry GetMulti created for the key, waited on without counting a hit
+}
+
+// GetWithOptions behaves like Get, with per-call settings given by opts.
+func (c *Cache) GetWithOptions(key interface{}, opts GetOptions, generate func(interface{}) (interface{}, error)) func() (interface{}, error) {
+	return c.get(key, opts, withoutContext(generate))
+}
+
+// miss creates the entry for key, which missed the cache, and accounts for the miss. The cache must be locked.
+// The entry is left out of the cache if key is oversized or turned away by Admission; the caller starts its
+// generation, which completes future.
+func (c *Cache) miss(key interface{}, ttl time.Duration, opts GetOptions, generate generator) (item *cacheItem, future *sync.WaitGroup) {
+	future = &sync.WaitGroup{}
+	future.Add(1)
+	item = &cacheItem{val: nil, future: future, pending: true, cost: opts.Cost, origin: c.newOrigin(opts.source, opts.Namespace, opts.caller)}
+	item.minDelta = c.minGenerationTime(opts.Namespace)
+	item.labels = opts.Labels
+	item.priority = opts.Priority
+	c.setTTL(item, ttl)
+	if c.RefreshInterval > 0 {
+		item.generate = generate
+	}
+	if c.oversized(key) {
+		c.stats.OversizedKeys++
+	} else if c.pinned[key] || c.admit(key) {
+		c.insert(key, item)
+	} else {
+		c.stats.Rejections++
+		if c.rejected == nil {
+			c.rejected = make(map[interface{}]*cacheItem)
+		}
+		c.rejected[key] = item // Concurrent Gets wait on this generation, but its value isn't kept
+	}
+	c.stats.Misses++
+	if c.Announcer != nil && opts.source != SourcePrefetch {
+		c.events = append(c.events, func() { c.announce(key) })
+	}
+	if onMiss := c.OnMiss; onMiss != nil {
+		c.events = append(c.events, func() { onMiss(key) })
+	}
+	return item, future
+}
+
+func (c *Cache) get(key interface{}, opts GetOptions, generate generator) func() (interface{}, error) {
+	ttl := c.lifetime(opts.TTL)
+	if opts.caller == 0 {
+		opts.caller = c.callerPC(2) // The caller of Get or GetWithOptions
+	}
+	if transform := opts.Transform; transform != nil {
+		opts.Transform = nil // Applied once here rather than by each retry of the Get
+		return transformed(c.get(key, opts, generate), transform)
+	}
 	c.lockMap()
+	if c.closed {
+		defer c.unlock()
+		return func() (interface{}, error) {
+			return nil, ErrClosed
+		}
+	}
+	if c.Replica {
+		defer c.unlock()
+		return c.replicaGet(key)
+	}
+	if c.TrackCardinality {
+		if c.cardinality == nil {
+			c.cardinality = &hyperLogLog{}
+		}
+		c.cardinality.add(hashWith(c.Hash, key))
+	}
 	item, ok := c.data[key]
+	batch := opts.batch
+	if batch != nil {
+		item, ok = batch, true // GetMulti created the entry and accounted for its miss
+		opts.batch = nil       // A retried Get looks the key up afresh
+	}
+	if ok && !opts.MinFreshness.IsZero() && c.producedAt(item).Before(opts.MinFreshness) {
+		c.remove(key) // Callers already waiting on the entry still receive its value
+		ok = false
+	}
 	if !ok {
-		var future sync.WaitGroup
-		future.Add(1)
-		item = &cacheItem{val: nil, future: &future, ttl: ttl}
-		c.prune()
-		c.data[key] = item
-		go c.generateItem(key, item, generate, &future)
+		item, ok = c.rejected[key] // Share the generation of a key Admission turned away
+	}
+	if ok && item.abandoned {
+		// The generation was cancelled under CancelAbandoned; start afresh rather than share its context error
+		if c.data[key] == item {
+			c.remove(key)
+		} else {
+			delete(c.rejected, key)
+		}
+		ok = false
+	}
+	if !ok && c.Absent != nil && c.Absent.MightContain(key) {
+		defer c.unlock()
+		return func() (interface{}, error) {
+			return nil, ErrAbsent
+		}
+	}
+	if opts.Pinned {
+		c.pin(key)
+	}
+	if c.Admission != nil && batch == nil {
+		c.Admission.Record(key)
+	}
+	if ttl == 0 {
+		c.uncached++
+	}
+	untimed := generate // Passed on when retrying the Get, which times it afresh
+	if tracker := c.latency(); tracker != nil {
+		generate = c.timed(tracker, opts.Namespace, generate)
+		if opts.Timeout == 0 {
+			opts.Timeout = c.autoTimeout(opts.Namespace)
+		}
+	}
+	if opts.report != nil {
+		opts.report.served(ok) // A retried Get reports again, so a Get that ends up generating counts as a miss
+	}
+	generated := !ok || item == batch
+	if !ok {
+		var future *sync.WaitGroup
+		item, future = c.miss(key, ttl, opts, generate)
+		c.spawnGenerate(key, item, generate, future)
+	} else if item != batch {
+		if item.pending {
+			c.merge(item, ttl, opts.Cost)
+		}
+		c.stats.Hits++
+		item.hits++
+		if onHit := c.OnHit; onHit != nil {
+			c.events = append(c.events, func() { onHit(key) })
+		}
+	}
+	joined := item.pending // The Get shares the entry's first generation, so its options were merged
+	waiting := c.MaxWaiters > 0 && item.pending
+	if waiting && item.waiters >= c.MaxWaiters {
+		defer c.unlock()
+		return c.overflow(item)
+	}
+	if waiting {
+		item.waiters++
 	}
 	future := item.future
-	c.mutex.Unlock()
 	var result interface{}
 	var resErr error
 	resultWait := make(chan struct{})
+	ctx := opts.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	retrieve := c.interested(item, c.retrieval(ctx, key, opts.Timeout, resultWait, func() (interface{}, error) {
+		if resErr != nil {
+			return result, resErr
+		}
+		return c.expand(result)
+	}))
+	c.unlock()
 	go func() {
 		future.Wait()
 		c.lockMap()
-		if c.expired(item) {
+		if waiting {
+			item.waiters--
+		}
+		if c.expired(item) && !(item.stale && c.inStaleGrace(item)) {
 			if item.future != nil { // The item hasn't already been destroyed
 				item.future, item.refresh = item.refresh, nil // Atempt to promote the refresh routine to main provider
-				if item.future == nil {                       // There is no valid refresh routine
+				if item.future == nil && item == c.data[key] && c.inStaleGrace(item) && !c.closed {
+					// Regenerate in place so the current value survives a failure
+					var regenerate sync.WaitGroup
+					regenerate.Add(1)
+					item.future = &regenerate
+					c.setPending(key, item, true)
+					c.refreshStarted(key)
+					c.spawnGenerate(key, item, generate, &regenerate)
+				}
+				if item.future != nil {
+					c.setPending(key, item, true)
+				}
+				if item.future != nil && c.inStaleGrace(item) {
+					item.stale = true
+				} else if item.future == nil { // There is no valid refresh routine
 					if item == c.data[key] {
 						c.remove(key)
 					}
 				}
 			}
-			c.mutex.Unlock()
-			result, resErr = c.Get(key, ttl, generate)()
+			c.unlock()
+			result, resErr = c.get(key, opts, untimed)()
 			close(resultWait)
 			return
 		}
-		defer c.mutex.Unlock()
-		if c.shouldRefresh(item) && item.refresh == nil {
+		var inspectTTL time.Duration
+		if c.OnHitInspect != nil && !generated && item.err == nil {
+			var ok bool
+			if inspectTTL, ok = c.OnHitInspect(key, c.expanded(item.val), c.info(item)); !ok {
+				if item == c.data[key] {
+					c.remove(key)
+				}
+				c.unlock()
+				result, resErr = c.get(key, opts, untimed)()
+				close(resultWait)
+				return
+			}
+		}
+		defer c.unlock()
+		if c.shouldRefresh(item) && item.refresh == nil && !c.closed {
 			var refresh sync.WaitGroup
 			refresh.Add(1)
 			item.refresh = &refresh
-			go c.generateItem(key, item, generate, &refresh)
+			c.refreshStarted(key)
+			c.spawnGenerate(key, item, generate, &refresh)
+		}
+		if opts.report != nil && item.err == nil {
+			atomic.StoreInt64(&opts.report.staleness, int64(c.staleness(item)))
+		}
+		if item.err == nil && !joined { // Errors keep their ErrorTTL
+			c.setTTL(item, ttl)
+		}
+		if opts.report != nil && item.err == nil && c.data[key] == item {
+			atomic.StoreInt64(&opts.report.ttl, int64(item.ttl))
+		}
+		if generated {
+			item.lastUsed = c.now() // Insertion already informed Eviction
+		} else {
+			c.touched(key, item)
+		}
+		if inspectTTL > 0 && item.ttl != 0 {
+			if remaining := c.lastTouched(item).Add(item.ttl).Sub(item.lastUsed); inspectTTL < remaining {
+				item.ttl -= remaining - inspectTTL
+			}
+		}
+		if c.data[key] == item {
+			c.schedule(key, item)
 		}
-		item.ttl = ttl
-		item.lastUsed = time.Now()
 		result, resErr = item.val, item.err
 		close(resultWait)
 	}()
-	c.PurgeCount(5)
+	c.purgeUnlessFrozen(5)
+	return retrieve
+}
+
+// merge folds the options of a Get joining an entry's first generation into the entry. A zero TTL, asking for the
+// value not to be cached, outranks any other; otherwise the longest TTL and the largest Cost win. The cache must be locked.
+func (c *Cache) merge(item *cacheItem, ttl time.Duration, cost uint64) {
+	if ttl == 0 {
+		item.ttl = 0
+	} else if previous := item.ttl; previous != 0 {
+		c.setTTL(item, ttl)
+		if item.ttl < previous {
+			item.ttl = previous
+		}
+	}
+	if cost > item.cost {
+		item.cost = cost
+	}
+}
+
+// transformed applies transform to the values returned by retrieve.
+func transformed(retrieve func() (interface{}, error), transform func(interface{}) (interface{}, error)) func() (interface{}, error) {
 	return func() (interface{}, error) {
-		after := acquireTimer(c.GetTimeout)
-		defer releaseTimer(after)
-		if c.GetTimeout != 0 {
-			select {
-			case <-resultWait:
-				return result, resErr
-			case <-after.C:
-				return nil, errors.New("Generation timed out")
+		val, err := retrieve()
+		if err != nil {
+			return nil, err
+		}
+		return transform(val)
+	}
+}
+
+// retrieval builds the function returned by Get, waiting up to timeout (or GetTimeout if zero) for done before returning result.
+// The wait is given up with ctx's error once ctx is done.
+func (c *Cache) retrieval(ctx context.Context, key interface{}, timeout time.Duration, done <-chan struct{}, result func() (interface{}, error)) func() (interface{}, error) {
+	if timeout == 0 {
+		timeout = c.GetTimeout
+	}
+	forceTimeout := c.Faults != nil && c.Faults.ForceTimeout(key)
+	return func() (interface{}, error) {
+		if forceTimeout {
+			return nil, ErrTimeout
+		}
+		var after <-chan time.Time
+		if timeout != 0 {
+			var release func()
+			after, release = c.after(timeout)
+			defer release()
+		}
+		select {
+		case <-done:
+			return result()
+		case <-after:
+			return nil, ErrTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// SetPolicy determines what happens when Set is called for a key whose value is being generated or refreshed.
+type SetPolicy int
+
+const (
+	// SetWins stores the Set value at once. The generation's result is returned to the callers already
+	// waiting on it but is otherwise discarded.
+	SetWins SetPolicy = iota
+	// GenerationWins discards the Set, leaving the entry to be filled by the generation in flight.
+	GenerationWins
+)
+
+// Set stores value under key, replacing any existing entry without invoking a generator.
+// A ttl of zero removes the key instead, matching the uncached behavior of Get, unless ZeroTTL is ZeroTTLForever.
+// The write is passed through to Store, if set.
+//
+// If the key is being generated or refreshed, SetPolicy decides whether the Set or the generation wins.
+// Goroutines already waiting on a generation always receive its result.
+func (c *Cache) Set(key, value interface{}, ttl time.Duration) {
+	ttl = c.lifetime(ttl)
+	if c.set(key, value, ttl, nil) {
+		c.storeWrite(key, value, ttl)
+		c.replaced(key)
+	}
+}
+
+// SetWithLabels behaves like Set, attaching labels to the new entry. See GetOptions.Labels.
+func (c *Cache) SetWithLabels(key, value interface{}, ttl time.Duration, labels map[string]string) {
+	ttl = c.lifetime(ttl)
+	if c.set(key, value, ttl, labels) {
+		c.storeWrite(key, value, ttl)
+		c.replaced(key)
+	}
+}
+
+// set stores value locally with labels, reporting false if SetPolicy or MaxKeySize discarded it.
+func (c *Cache) set(key, value interface{}, ttl time.Duration, labels map[string]string) bool {
+	value = c.compress(value)
+	size := c.sizeOf(key, value)
+	c.lockMutable()
+	defer c.unlock()
+	if c.oversized(key) {
+		c.stats.OversizedKeys++
+		return false
+	}
+	if old, ok := c.data[key]; ok {
+		if old.pending || old.refresh != nil {
+			if c.SetPolicy == GenerationWins {
+				return false
 			}
+			old.superseded = true
 		}
-		<-resultWait
-		return result, resErr
+		c.remove(key)
+	}
+	if ttl == 0 {
+		return true
 	}
+	item := &cacheItem{val: value, future: &sync.WaitGroup{}, created: c.now(), size: size, origin: c.newOrigin(SourceSet, "", c.callerPC(2)), labels: labels}
+	c.setTTL(item, ttl)
+	c.insert(key, item)
+	c.index(key, item)
+	return true
 }
 
-// Purge finds and removes all expired cache entires from the cache, allowing the data to be freed by the garbage collector.
-func (c *Cache) Purge() {
+// GetIfPresent returns the value cached under key without invoking a generator.
+// Only completed, unexpired and successful entries are returned.
+func (c *Cache) GetIfPresent(key interface{}) (interface{}, bool) {
 	c.lockMap()
-	defer c.mutex.Unlock()
-	for key, val := range c.data {
-		if c.expired(val) {
-			c.remove(key)
-		}
+	item, ok := c.data[key]
+	if !ok || !c.visible(item) {
+		c.unlock()
+		return nil, false
 	}
+	item.hits++
+	c.touched(key, item)
+	val := item.val
+	c.unlock()
+	return c.expanded(val), true
 }
 
-// PurgeCount finds and removes all expired cache entires from the cache, checking at moust count items.
+// Touch restarts the expiry of the entry under key without regenerating its value, also replacing its TTL
+// if ttl is non-zero, and reports whether a completed, unexpired and successful entry was found.
+// The next Get of the key sets the TTL back to the one it passes.
+func (c *Cache) Touch(key interface{}, ttl time.Duration) bool {
+	c.lockMutable()
+	defer c.unlock()
+	item, ok := c.data[key]
+	if !ok || !c.visible(item) {
+		return false
+	}
+	if ttl != 0 {
+		c.setTTL(item, ttl)
+	}
+	item.created = c.now()
+	item.stale = false
+	c.touched(key, item)
+	c.schedule(key, item)
+	return true
+}
+
+// Peek returns the value cached under key like GetIfPresent, but without any side effects:
+// the entry's last use, eviction order and refresh state are left untouched.
+func (c *Cache) Peek(key interface{}) (interface{}, bool) {
+	c.lockMap()
+	item, ok := c.data[key]
+	if !ok || !c.visible(item) {
+		c.unlock()
+		return nil, false
+	}
+	val := item.val
+	c.unlock()
+	return c.expanded(val), true
+}
+
+// Purge finds and removes all expired cache entires from the cache, allowing the data to be freed by the garbage collector.
+// Expired entries are found through a queue ordered by deadline, so the cost depends on how many have expired
+// rather than on the size of the cache.
+func (c *Cache) Purge() {
+	c.lockMutable()
+	defer c.unlock()
+	c.purgeDue(-1)
+	c.maybeCompact()
+}
+
+// PurgeCount removes the count soonest-expired entries from the cache, if they have expired.
 func (c *Cache) PurgeCount(count int) {
-	processed := 0
+	if count <= 0 {
+		return
+	}
+	c.lockMutable()
+	defer c.unlock()
+	c.purgeDue(count)
+	c.maybeCompact()
+}
+
+// purgeUnlessFrozen removes up to count expired entries like PurgeCount, but skips purging while the cache is
+// frozen rather than waiting for Thaw, so that the purge made by each Get never blocks it.
+func (c *Cache) purgeUnlessFrozen(count int) {
 	c.lockMap()
-	defer c.mutex.Unlock()
-	for key, val := range c.data {
-		if c.expired(val) {
-			c.remove(key)
-		}
-		processed++
-		if processed >= count {
-			break
-		}
+	defer c.unlock()
+	if c.frozen {
+		return
+	}
+	c.purgeDue(count)
+	c.maybeCompact()
+}
+
+// purgeable reports whether Purge may remove an item: it has expired, isn't being served stale, and has no refresh
+// in flight that a Get would wait for instead of regenerating.
+func (c *Cache) purgeable(item *cacheItem) bool {
+	return c.expired(item) && !c.inStaleGrace(item) && item.refresh == nil
+}
+
+// expire removes an expired entry, queueing OnExpire. The cache must be locked.
+func (c *Cache) expire(key interface{}, item *cacheItem) {
+	if onExpire := c.OnExpire; onExpire != nil && !item.created.IsZero() && item.err == nil {
+		val := item.val
+		c.events = append(c.events, func() { onExpire(key, c.expanded(val)) })
 	}
+	c.remove(key)
+	c.stats.Expirations++
+}
+
+// Delete removes key from the cache and Store, returning whether it was present in the cache.
+// Goroutines already waiting on the entry still receive its value.
+func (c *Cache) Delete(key interface{}) bool {
+	c.storeWrite(key, nil, 0)
+	c.replaced(key)
+	c.lockMutable()
+	defer c.unlock()
+	if _, ok := c.data[key]; !ok {
+		return false
+	}
+	c.remove(key)
+	c.maybeCompact()
+	return true
 }
 
 // Clear removes all items from the cache.
 func (c *Cache) Clear() {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	c.awaitThaw()
+	defer c.unlock()
+	for key, item := range c.data {
+		c.evicted(key, item)
+		if c.Eviction != nil {
+			c.Eviction.OnRemove(key)
+		}
+	}
 	c.data = nil
+	c.peak = 0
+	c.secondary = nil
+	c.retained = nil
+	c.priorities = nil
+	c.expiries = nil
+	c.tombstones = nil
 	c.storage = 0
 }
 
+// ApproxSize returns the number of entries as of the last time the cache was unlocked, without locking it.
+// It is cheap enough for hot polling but may briefly lag Size.
+func (c *Cache) ApproxSize() int {
+	return int(atomic.LoadInt64(&c.approxEntries))
+}
+
+// ApproxStorage returns the estimated storage as of the last time the cache was unlocked, without locking it.
+// It is cheap enough for hot polling but may briefly lag Stats.
+func (c *Cache) ApproxStorage() uint64 {
+	return atomic.LoadUint64(&c.approxStorage)
+}
+
 // Size returns the number of cache entires (including unpurged expired entries) in the cache.
 func (c *Cache) Size() int {
+	c.mutex.Lock()
+	defer c.unlock()
 	return len(c.data)
 }
@@ -1,9 +1,16 @@
 package cache
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"expvar"
+	"fmt"
 	"math/rand"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -25,18 +32,18 @@ func expectConsistentCacheSize(t *testing.T, c *Cache) {
 	defer c.mutex.Unlock()
 	var s uint64
 	for _, v := range c.data {
-		s += v.size
+		s += v.size + v.keySize
 	}
 	if s != c.storage {
 		t.Fatal("Cache storage inconsistent")
 	}
 }
 
-func expectCacheValue(t *testing.T, c *Cache, key string, ttl time.Duration, val string, expected string, message string) string {
+func expectCacheValue(t *testing.T, c *Cache, key interface{}, ttl time.Duration, val interface{}, expected interface{}, message string) interface{} {
 	actual, err := c.Get(key, ttl, getGeneratorStub(val, nil))()
 	noError(t, err)
 	if actual != expected {
-		t.Fatalf("%s (%s != %s)", message, actual, expected)
+		t.Fatalf("%s (%v != %v)", message, actual, expected)
 	}
 	expectConsistentCacheSize(t, c)
 	return expected
@@ -188,7 +195,7 @@ func TestExpiredPurge(t *testing.T) {
 	c := &Cache{MaxSize: 1}
 	c.Purge()
 	var future sync.WaitGroup
-	c.data["test"] = &cacheItem{future: &future, ttl: 10 * time.Second, created: time.Now().Add(-75 * time.Second), val: "A"}
+	c.insert("test", &cacheItem{future: &future, ttl: 10 * time.Second, created: time.Now().Add(-75 * time.Second), val: "A"})
 	if !c.expired(c.data["test"]) {
 		t.Fatal("Expired cacheItem did not properly indicate expired()")
 	}
@@ -240,3 +247,2803 @@ func TestFuzz00000(t *testing.T) {
 	Fuzz([]byte(""))
 	Fuzz([]byte("AOIFuhiu9fgh39840hff"))
 }
+
+func TestAcceptRefresh(t *testing.T) {
+	c := &Cache{MaxSize: 1, Refresh: true, AcceptRefresh: func(old, new interface{}) bool {
+		return new != ""
+	}}
+	c.Purge()
+	var future sync.WaitGroup
+	c.data["test"] = &cacheItem{future: &future, ttl: 100 * time.Second, created: time.Now().Add(-75 * time.Second), val: "A"}
+	expectCacheValue(t, c, "test", 100*time.Second, "", "A", "Cache item was not present")
+	time.Sleep(1 * time.Millisecond)
+	expectCacheValue(t, c, "test", 100*time.Second, "B", "A", "Rejected refresh replaced cache item")
+	time.Sleep(1 * time.Millisecond)
+	expectCacheValue(t, c, "test", 100*time.Second, "C", "B", "Refresh was not retried after rejection")
+}
+
+func TestStaleOnError(t *testing.T) {
+	c := &Cache{MaxSize: 1, StaleOnError: 100 * time.Second}
+	c.Purge()
+	var future sync.WaitGroup
+	c.data["test"] = &cacheItem{future: &future, ttl: 10 * time.Second, created: time.Now().Add(-75 * time.Second), val: "A"}
+	c.Purge()
+	val, err := c.Get("test", 10*time.Second, getGeneratorStub(nil, errors.New("Test Error")))()
+	noError(t, err)
+	if val != "A" {
+		t.Fatal("Stale value was not served after failed regeneration")
+	}
+	waitRefresh(c, "test")
+	expectCacheValue(t, c, "test", 10*time.Second, "B", "A", "Stale value was not served while retrying")
+	waitRefresh(c, "test")
+	expectCacheValue(t, c, "test", 10*time.Second, "C", "B", "Stale value was not replaced by a successful retry")
+}
+
+// waitRefresh waits for the refresh in flight for key, if any, to complete.
+func waitRefresh(c *Cache, key interface{}) {
+	c.lockMap()
+	refresh := c.data[key].refresh
+	c.mutex.Unlock()
+	if refresh != nil {
+		refresh.Wait()
+	}
+}
+
+func TestStaleOnErrorGraceExpired(t *testing.T) {
+	c := &Cache{MaxSize: 1, StaleOnError: 1 * time.Second}
+	c.Purge()
+	var future sync.WaitGroup
+	c.data["test"] = &cacheItem{future: &future, ttl: 10 * time.Second, created: time.Now().Add(-75 * time.Second), val: "A"}
+	_, err := c.Get("test", 10*time.Second, getGeneratorStub(nil, errors.New("Test Error")))()
+	if err == nil {
+		t.Fatal("Stale value was served past the grace period")
+	}
+}
+
+func TestErrorTTL(t *testing.T) {
+	c := &Cache{MaxSize: 1, ErrorTTL: 10 * time.Millisecond}
+	_, err := c.Get("test", 100*time.Second, getGeneratorStub(nil, errors.New("Test Error")))()
+	if err == nil {
+		t.Fatal("Cache did not return generation error.")
+	}
+	_, err = c.Get("test", 100*time.Second, getGeneratorStub("A", nil))()
+	if err == nil {
+		t.Fatal("Cache did not retain generation error for ErrorTTL.")
+	}
+	time.Sleep(20 * time.Millisecond)
+	expectCacheValue(t, c, "test", 100*time.Second, "A", "A", "Cached error did not expire after ErrorTTL.")
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	c := &Cache{MaxSize: 3, SecondaryKey: func(value interface{}) interface{} {
+		return "email:" + value.(string)
+	}}
+	setCacheValue(t, c, "A", 100*time.Second, "a@example.com")
+	key, val, ok := c.LookupSecondary("email:a@example.com")
+	if !ok || key != "A" || val != "a@example.com" {
+		t.Fatal("Entry was not found by secondary key")
+	}
+	if !c.InvalidateSecondary("email:a@example.com") {
+		t.Fatal("Entry was not invalidated by secondary key")
+	}
+	if _, _, ok := c.LookupSecondary("email:a@example.com"); ok {
+		t.Fatal("Secondary index retained removed entry")
+	}
+	setCacheValue(t, c, "B", 100*time.Second, "b@example.com")
+	if !c.Delete("B") || c.Size() != 0 || len(c.secondary) != 0 {
+		t.Fatal("Deleting by primary key did not maintain the secondary index")
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	setCacheValue(t, c, "A", 100*time.Second, "A")
+	var calls [][]interface{}
+	var m sync.Mutex
+	vals, err := c.GetMulti([]interface{}{"A", "B", "C"}, 100*time.Second, func(keys []interface{}) (map[interface{}]interface{}, error) {
+		m.Lock()
+		defer m.Unlock()
+		calls = append(calls, keys)
+		time.Sleep(10 * time.Millisecond)
+		return map[interface{}]interface{}{"B": "B"}, nil
+	})()
+	if err != ErrNotGenerated {
+		t.Fatal("Key missing from batch was not reported")
+	}
+	if len(calls) != 1 || len(calls[0]) != 2 {
+		t.Fatalf("Misses were not coalesced into one batch (%v)", calls)
+	}
+	if vals["A"] != "A" || vals["B"] != "B" || len(vals) != 2 {
+		t.Fatalf("Unexpected batch result %v", vals)
+	}
+	expectCacheValue(t, c, "B", 100*time.Second, "test", "B", "Batch result was not cached")
+}
+
+func TestGetMultiStats(t *testing.T) {
+	var misses []interface{}
+	var m sync.Mutex
+	c := &Cache{MaxSize: 10, OnMiss: func(key interface{}) {
+		m.Lock()
+		defer m.Unlock()
+		misses = append(misses, key)
+	}}
+	c.Set("A", "A", 100*time.Second)
+	_, err := c.GetMulti([]interface{}{"A", "B", "C"}, 100*time.Second, func(keys []interface{}) (map[interface{}]interface{}, error) {
+		return map[interface{}]interface{}{"B": "B", "C": "C"}, nil
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Fatalf("Batch counted %d hits and %d misses", stats.Hits, stats.Misses)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if len(misses) != 2 {
+		t.Fatalf("OnMiss called for %v", misses)
+	}
+}
+
+func TestMaxWaiters(t *testing.T) {
+	c := &Cache{MaxSize: 1, MaxWaiters: 1}
+	release := make(chan struct{})
+	first := c.Get("test", 100*time.Second, func(interface{}) (interface{}, error) {
+		<-release
+		return "A", nil
+	})
+	if _, err := c.Get("test", 100*time.Second, getGeneratorStub("B", nil))(); err != ErrOverloaded {
+		t.Fatal("Waiter beyond MaxWaiters was not rejected")
+	}
+	c.WaiterOverflow = OverflowDefault
+	c.OverflowValue = "default"
+	if val, _ := c.Get("test", 100*time.Second, getGeneratorStub("B", nil))(); val != "default" {
+		t.Fatal("Overflow default value was not returned")
+	}
+	close(release)
+	if val, err := first(); val != "A" || err != nil {
+		t.Fatal("Waiter within MaxWaiters did not receive value")
+	}
+	expectCacheValue(t, c, "test", 100*time.Second, "B", "A", "Completed entry was affected by waiter cap")
+}
+
+func TestSetAndGetIfPresent(t *testing.T) {
+	c := &Cache{MaxSize: 2, MaxStorage: 1000}
+	if _, ok := c.GetIfPresent("test"); ok {
+		t.Fatal("GetIfPresent reported a missing key")
+	}
+	c.Set("test", "A", 100*time.Second)
+	if val, ok := c.GetIfPresent("test"); !ok || val != "A" {
+		t.Fatal("GetIfPresent did not return the Set value")
+	}
+	expectCacheValue(t, c, "test", 100*time.Second, "B", "A", "Get did not return the Set value")
+	c.Set("test", "C", 100*time.Second)
+	expectCacheValue(t, c, "test", 100*time.Second, "B", "C", "Set did not replace existing value")
+	c.Set("test", "D", 0)
+	if c.Size() != 0 {
+		t.Fatal("Set with zero TTL did not remove key")
+	}
+}
+
+func TestWarmGraph(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	var m sync.Mutex
+	var order []interface{}
+	generate := func(key interface{}) (interface{}, error) {
+		m.Lock()
+		defer m.Unlock()
+		order = append(order, key)
+		if key == "bad" {
+			return nil, errors.New("Test Error")
+		}
+		return key, nil
+	}
+	err := c.WarmGraph(context.Background(), []WarmTask{
+		{Key: "derived", TTL: 100 * time.Second, Generate: generate, DependsOn: []interface{}{"base"}},
+		{Key: "base", TTL: 100 * time.Second, Generate: generate},
+		{Key: "skipped", TTL: 100 * time.Second, Generate: generate, DependsOn: []interface{}{"bad"}},
+		{Key: "bad", TTL: 100 * time.Second, Generate: generate},
+		{Key: "loop", TTL: 100 * time.Second, Generate: generate, DependsOn: []interface{}{"loop"}},
+	}, 4)
+	errs, ok := err.(WarmErrors)
+	if !ok || len(errs) != 3 || errs["skipped"] != ErrDependencyFailed || errs["loop"] != ErrDependencyCycle {
+		t.Fatalf("Unexpected warm errors %v", err)
+	}
+	position := map[interface{}]int{}
+	for i, key := range order {
+		position[key] = i
+	}
+	if position["derived"] < position["base"] {
+		t.Fatalf("Dependent key generated before its dependency (%v)", order)
+	}
+	expectCacheValue(t, c, "derived", 100*time.Second, "test", "derived", "Warmed key was not cached")
+}
+
+func TestWarmGraphCancel(t *testing.T) {
+	c := &Cache{MaxSize: 10, CancelAbandoned: true}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	err := c.WarmGraph(ctx, []WarmTask{{Key: "A", TTL: 100 * time.Second, Generate: func(key interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return "a", nil
+	}}}, 1)
+	if errs, ok := err.(WarmErrors); !ok || errs["A"] != context.Canceled {
+		t.Fatalf("Unexpected warm errors %v", err)
+	}
+	if stats := c.Stats(); stats.Cancellations != 1 {
+		t.Fatal("Abandoned warm generation was not cancelled")
+	}
+}
+
+func TestNewCache(t *testing.T) {
+	c, err := NewCache(WithMaxSize(3), WithRefresh(), WithGetTimeout(time.Second))
+	noError(t, err)
+	if c.MaxSize != 3 || !c.Refresh || c.GetTimeout != time.Second {
+		t.Fatal("Options were not applied")
+	}
+	expectCacheValue(t, c, "test", 100*time.Second, "A", "A", "Constructed cache did not store value")
+	if _, err := NewCache(WithMaxSize(0)); err == nil {
+		t.Fatal("Invalid option was not rejected")
+	}
+	if c, _ := NewCache(); c.MaxSize != DefaultMaxSize {
+		t.Fatal("Default MaxSize was not applied")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	c := &Cache{MaxSize: 1000, CompactRatio: 0.5}
+	for i := 0; i < 100; i++ {
+		c.Set(i, i, 100*time.Second)
+	}
+	if c.peak != 100 {
+		t.Fatal("Peak size not tracked")
+	}
+	for i := 0; i < 60; i++ {
+		c.Delete(i)
+	}
+	if c.peak == 100 {
+		t.Fatal("Map was not compacted after shrinking below CompactRatio")
+	}
+	expectCacheValue(t, c, 99, 100*time.Second, "test", 99, "Compaction lost entries")
+}
+
+func TestSizer(t *testing.T) {
+	c := &Cache{MaxSize: 10, MaxStorage: 100, Sizer: func(key, value interface{}) uint64 {
+		return 10
+	}}
+	setCacheValue(t, c, "A", 100*time.Second, "A")
+	if c.storage != 11 { // Including the key
+		t.Fatal("Sizer was not used to size value")
+	}
+	_, err := c.GetWithCost("B", 100*time.Second, 50, getGeneratorStub("B", nil))()
+	noError(t, err)
+	if c.storage != 62 {
+		t.Fatal("Per-Get cost was not used to size value")
+	}
+	expectConsistentCacheSize(t, c)
+}
+
+func TestPurgeInterval(t *testing.T) {
+	c := &Cache{MaxSize: 10, PurgeInterval: 5 * time.Millisecond}
+	defer c.Close(context.Background())
+	setCacheValue(t, c, "A", 1*time.Millisecond, "A")
+	time.Sleep(50 * time.Millisecond)
+	if c.Size() != 0 {
+		t.Fatal("Janitor did not purge expired entry")
+	}
+}
+
+func TestFingerprintSkipsSizing(t *testing.T) {
+	sizings := 0
+	c := &Cache{MaxSize: 1, MaxStorage: 1000, Refresh: true,
+		Fingerprint: func(value interface{}) uint64 {
+			return uint64(len(value.(string)))
+		},
+		Sizer: func(key, value interface{}) uint64 {
+			sizings++
+			return 10
+		},
+	}
+	setCacheValue(t, c, "test", 100*time.Second, "A")
+	c.lockMap()
+	c.data["test"].created = time.Now().Add(-75 * time.Second)
+	c.mutex.Unlock()
+	expectCacheValue(t, c, "test", 100*time.Second, "B", "A", "Cache item was not present")
+	time.Sleep(10 * time.Millisecond)
+	expectCacheValue(t, c, "test", 100*time.Second, "C", "B", "Cache item was not refreshed")
+	if stats := c.Stats(); sizings != 1 || stats.Sizings != 1 || stats.SizingsSkipped != 1 {
+		t.Fatalf("Unchanged fingerprint did not skip sizing (%d, %+v)", sizings, stats)
+	}
+}
+
+func TestClose(t *testing.T) {
+	var evicted []interface{}
+	c := &Cache{MaxSize: 10, OnEvict: func(key, value interface{}) {
+		evicted = append(evicted, value)
+	}}
+	setCacheValue(t, c, "A", 100*time.Second, "A")
+	release := make(chan struct{})
+	pending := c.Get("B", 100*time.Second, func(interface{}) (interface{}, error) {
+		<-release
+		return "B", nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatal("Close did not wait for in-flight generation")
+	}
+	if _, err := c.Get("C", 100*time.Second, getGeneratorStub("C", nil))(); err != ErrClosed {
+		t.Fatal("Closed cache accepted Get")
+	}
+	close(release)
+	if val, _ := pending(); val != "B" {
+		t.Fatal("In-flight generation was not completed")
+	}
+	noError(t, c.Close(context.Background()))
+	if len(evicted) != 2 || c.Size() != 0 {
+		t.Fatalf("Close did not evict entries (%v)", evicted)
+	}
+}
+
+func TestOnEvictCandidate(t *testing.T) {
+	c := &Cache{MaxSize: 3, OnEvictCandidate: func(key interface{}, meta EntryInfo) EvictDecision {
+		if key == "A" {
+			return EvictVeto
+		}
+		return EvictApprove
+	}}
+	ttl := 100 * time.Second
+	setCacheValue(t, c, "A", ttl, "A")
+	setCacheValue(t, c, "B", ttl, "B")
+	setCacheValue(t, c, "C", ttl, "C")
+	setCacheValue(t, c, "D", ttl, "D")
+	expectCacheValue(t, c, "A", ttl, "test", "A", "Vetoed cache item A was evicted.")
+
+	c = &Cache{MaxSize: 2, OnEvictCandidate: func(key interface{}, meta EntryInfo) EvictDecision {
+		return EvictVeto
+	}}
+	setCacheValue(t, c, "A", ttl, "A")
+	setCacheValue(t, c, "B", ttl, "B")
+	setCacheValue(t, c, "C", ttl, "C")
+	if c.Size() != 2 {
+		t.Fatal("Vetoes exceeded the cache's size limit")
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := &Cache{MaxSize: 1}
+	setCacheValue(t, c, "A", 100*time.Second, "A")
+	setCacheValue(t, c, "A", 100*time.Second, "A")
+	setCacheValue(t, c, "B", 100*time.Second, "B")
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Evictions != 1 || stats.Generations != 2 || stats.Entries != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	if stats.HitRatio() != 1.0/3 {
+		t.Fatal("Hit ratio miscalculated")
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	faults := NewSeededFaults(1)
+	faults.PanicRate = 1
+	c := &Cache{MaxSize: 10, MaxStorage: 1000, Recover: true, Faults: faults}
+	if _, err := c.Get("A", 100*time.Second, getGeneratorStub("A", nil))(); err == nil {
+		t.Fatal("Injected panic was not surfaced as an error")
+	}
+	faults.PanicRate, faults.SizingRate = 0, 1
+	setCacheValue(t, c, "B", 100*time.Second, "B")
+	if info, _ := c.Info("B"); info.Size != 0 {
+		t.Fatal("Injected sizing failure did not zero size")
+	}
+	faults.SizingRate, faults.TimeoutRate = 0, 1
+	if _, err := c.Get("B", 100*time.Second, getGeneratorStub("B", nil))(); err != ErrTimeout {
+		t.Fatal("Injected timeout was not returned")
+	}
+}
+
+func TestGetField(t *testing.T) {
+	c := &Cache{MaxSize: 10, MaxStorage: 1000, Sizer: func(key, value interface{}) uint64 {
+		return 1
+	}}
+	generate := func(key, field interface{}) (interface{}, error) {
+		return key.(string) + "." + field.(string), nil
+	}
+	val, err := c.GetField("user", "name", 100*time.Second, generate)()
+	noError(t, err)
+	if val != "user.name" {
+		t.Fatal("Field was not generated")
+	}
+	c.GetField("user", "email", 100*time.Second, generate)()
+	val, _ = c.GetField("user", "name", 100*time.Second, func(key, field interface{}) (interface{}, error) {
+		return "other", nil
+	})()
+	if val != "user.name" {
+		t.Fatal("Field was not cached")
+	}
+	if c.Size() != 1 || c.Stats().Storage != 3+uint64(len("user")) {
+		t.Fatalf("Fields were not accounted to a single entry (%+v)", c.Stats())
+	}
+	expectConsistentCacheSize(t, c)
+	if val, _ := c.Peek("user"); !reflect.DeepEqual(val, map[interface{}]interface{}{"name": "user.name", "email": "user.email"}) {
+		t.Fatalf("Fields were not shown as a map: %#v", val)
+	}
+	var buf bytes.Buffer
+	noError(t, c.SaveTo(&buf))
+	restored := &Cache{MaxSize: 10}
+	noError(t, restored.LoadFrom(&buf))
+	if restored.Size() != 0 {
+		t.Fatal("Fields were saved to a snapshot")
+	}
+}
+
+func TestGetFieldFullCache(t *testing.T) {
+	c := &Cache{MaxSize: 2}
+	c.Set("other", "a", 100*time.Second)
+	for _, field := range []string{"name", "email"} {
+		_, err := c.GetField("user", field, 100*time.Second, func(key, field interface{}) (interface{}, error) {
+			return field, nil
+		})()
+		noError(t, err)
+	}
+	if c.Size() != 2 {
+		t.Fatalf("Generating fields on a full cache evicted an entry, %d remain", c.Size())
+	}
+}
+
+func TestGetFieldKept(t *testing.T) {
+	c := &Cache{MaxSize: 10, MinGenerationTime: time.Hour, Refresh: true}
+	var calls int32
+	generate := func(key, field interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return field, nil
+	}
+	for i := 0; i < 2; i++ {
+		val, err := c.GetField("user", "name", 100*time.Second, generate)()
+		noError(t, err)
+		if val != "name" {
+			t.Fatalf("Unexpected field %v", val)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatal("Field set was dropped under MinGenerationTime")
+	}
+	report := c.RefreshAll(nil, getGeneratorStub("refreshed", nil), RefreshOptions{})
+	if report.Refreshed != 0 {
+		t.Fatalf("Field set was refreshed: %+v", report)
+	}
+	if val, _ := c.Peek("user"); !reflect.DeepEqual(val, map[interface{}]interface{}{"name": "name"}) {
+		t.Fatalf("Fields were dropped by RefreshAll: %#v", val)
+	}
+}
+
+func TestGetWithOptionsTimeout(t *testing.T) {
+	c := &Cache{MaxSize: 2, GetTimeout: 1 * time.Second}
+	_, err := c.GetWithOptions("test", GetOptions{TTL: 100 * time.Second, Timeout: 10 * time.Millisecond}, func(interface{}) (interface{}, error) {
+		time.Sleep(100 * time.Millisecond)
+		return "A", nil
+	})()
+	if err != ErrTimeout {
+		t.Fatal("Per-call timeout was not applied")
+	}
+	val, err := c.GetWithOptions("test", GetOptions{TTL: 100 * time.Second}, getGeneratorStub("B", nil))()
+	noError(t, err)
+	if val != "A" {
+		t.Fatal("Cache GetTimeout was not used when no per-call timeout was given")
+	}
+}
+
+func TestAppend(t *testing.T) {
+	c := &Cache{MaxSize: 10, MaxStorage: 1000, AppendLimit: 3, Sizer: func(key, value interface{}) uint64 {
+		return 1
+	}}
+	for i := 0; i < 5; i++ {
+		noError(t, c.Append("events", i, 100*time.Second))
+	}
+	val, ok := c.GetIfPresent("events")
+	if values := val.([]interface{}); !ok || len(values) != 3 || values[0] != 2 || values[2] != 4 {
+		t.Fatalf("Unexpected accumulated values %v", val)
+	}
+	if c.Stats().Storage != 3+uint64(len("events")) {
+		t.Fatal("Accumulated storage not accounted")
+	}
+	setCacheValue(t, c, "plain", 100*time.Second, "A")
+	if c.Append("plain", 1, 100*time.Second) != ErrNotAccumulator {
+		t.Fatal("Append to a plain entry was not rejected")
+	}
+}
+
+func TestAppendFullCache(t *testing.T) {
+	c := &Cache{MaxSize: 2}
+	noError(t, c.Append("events", 1, 100*time.Second))
+	c.Set("other", "a", 100*time.Second)
+	noError(t, c.Append("events", 2, 100*time.Second))
+	if c.Size() != 2 {
+		t.Fatalf("Append on a full cache evicted an entry, %d remain", c.Size())
+	}
+}
+
+func TestXFetchRefresh(t *testing.T) {
+	c := &Cache{MaxSize: 1, Refresh: true, RefreshBeta: 1}
+	c.Purge()
+	var future sync.WaitGroup
+	fresh := &cacheItem{future: &future, ttl: 100 * time.Second, created: time.Now(), delta: time.Millisecond}
+	if c.shouldRefresh(fresh) {
+		t.Fatal("XFetch refreshed a fresh cheap entry")
+	}
+	slow := &cacheItem{future: &future, ttl: 100 * time.Second, created: time.Now().Add(-99 * time.Second), delta: 1000 * time.Hour}
+	if !c.shouldRefresh(slow) {
+		t.Fatal("XFetch did not refresh an expensive entry near expiry")
+	}
+}
+
+func TestRefreshThreshold(t *testing.T) {
+	var future sync.WaitGroup
+	item := &cacheItem{future: &future, ttl: 100 * time.Second, created: time.Now().Add(-70 * time.Second)}
+	c := &Cache{Refresh: true, RefreshFraction: 0.8}
+	if c.shouldRefresh(item) {
+		t.Fatal("Entry refreshed before RefreshFraction of its TTL")
+	}
+	c.RefreshFraction = 0.6
+	if !c.shouldRefresh(item) {
+		t.Fatal("Entry not refreshed after RefreshFraction of its TTL")
+	}
+	c.RefreshAfter = 80 * time.Second
+	if c.shouldRefresh(item) {
+		t.Fatal("RefreshAfter did not take precedence over RefreshFraction")
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	w := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		w.Add(1)
+		go func() {
+			defer w.Done()
+			for i := 0; i < 100; i++ {
+				c.Increment("hits", 1, 100*time.Second)
+			}
+		}()
+	}
+	w.Wait()
+	if count := c.Increment("hits", 0, 100*time.Second); count != 1000 {
+		t.Fatalf("Concurrent increments were lost (%d)", count)
+	}
+	c.Increment("window", 5, 1*time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if count := c.Increment("window", 1, 1*time.Millisecond); count != 1 {
+		t.Fatal("Expired counter was not reset")
+	}
+}
+
+func TestIncrementPending(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	started, release := make(chan struct{}), make(chan struct{})
+	result := c.Get("hits", 100*time.Second, func(interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return int64(10), nil
+	})
+	<-started
+	counted := make(chan int64)
+	go func() { counted <- c.Increment("hits", 1, 100*time.Second) }()
+	close(release)
+	if _, err := result(); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-counted; n != 11 {
+		t.Fatalf("Increment did not wait for the generation, counted %d", n)
+	}
+	if val, _ := c.GetIfPresent("hits"); val != int64(11) {
+		t.Fatalf("Generation overwrote the counter with %v", val)
+	}
+}
+
+func TestAbsentFilter(t *testing.T) {
+	c := &Cache{MaxSize: 10, Absent: NewBloomFilter(100, 0.01)}
+	calls := 0
+	generate := func(interface{}) (interface{}, error) {
+		calls++
+		return nil, ErrAbsent
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("missing", 100*time.Second, generate)(); err != ErrAbsent {
+			t.Fatal("Absent key was not reported")
+		}
+	}
+	if calls != 1 {
+		t.Fatal("Known-absent key invoked the generator again")
+	}
+	expectCacheValue(t, c, "present", 100*time.Second, "A", "A", "Present key was reported absent")
+	c.Absent.Reset()
+	if c.Absent.MightContain("missing") {
+		t.Fatal("Reset did not clear the filter")
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	c := &Cache{MaxSize: 11, Admission: NewTinyLFU(10)}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 10; i++ {
+			expectCacheValue(t, c, i, 100*time.Second, i, i, "Hot key generated wrong value")
+		}
+	}
+	for i := 100; i < 200; i++ {
+		expectCacheValue(t, c, i, 100*time.Second, i, i, "Scanned key generated wrong value")
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := c.GetIfPresent(i); !ok {
+			t.Fatalf("Hot key %d was evicted by a scan", i)
+		}
+	}
+	if c.Stats().Rejections == 0 {
+		t.Fatal("Scanned keys were not rejected")
+	}
+}
+
+func TestRejectedSingleFlight(t *testing.T) {
+	c := &Cache{MaxSize: 11, Admission: NewTinyLFU(10)}
+	for round := 0; round < 3; round++ {
+		for i := 0; i <= 10; i++ {
+			expectCacheValue(t, c, i, 100*time.Second, i, i, "Hot key generated wrong value")
+		}
+	}
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := c.Get("cold", 100*time.Second, func(key interface{}) (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(20 * time.Millisecond)
+				return "c", nil
+			})()
+			if err != nil || val != "c" {
+				t.Errorf("Unexpected result for rejected key: %v, %v", val, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("Rejected key was generated %d times", calls)
+	}
+	if _, ok := c.GetIfPresent("cold"); ok {
+		t.Fatal("Rejected key was kept")
+	}
+}
+
+func TestWindowTinyLFUAdmission(t *testing.T) {
+	c := &Cache{MaxSize: 100, Admission: NewWindowTinyLFU(100)}
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 90; i++ {
+			expectCacheValue(t, c, i, 100*time.Second, i, i, "Hot key generated wrong value")
+		}
+	}
+	for i := 1000; i < 1500; i++ {
+		expectCacheValue(t, c, i, 100*time.Second, i, i, "Scanned key generated wrong value")
+		if _, ok := c.GetIfPresent(i); !ok {
+			t.Fatalf("New key %d was not let in through the window", i)
+		}
+	}
+	for i := 0; i < 90; i++ {
+		if _, ok := c.GetIfPresent(i); !ok {
+			t.Fatalf("Hot key %d was evicted by a scan", i)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	setCacheValue(t, c, "B", 100*time.Second, "b")
+	setCacheValue(t, c, "C", 10*time.Millisecond, "c")
+	time.Sleep(20 * time.Millisecond)
+	var buf bytes.Buffer
+	noError(t, c.SaveTo(&buf))
+
+	restored := &Cache{MaxSize: 10}
+	setCacheValue(t, restored, "B", 100*time.Second, "newer")
+	noError(t, restored.LoadFrom(&buf))
+	if val, ok := restored.GetIfPresent("A"); !ok || val != "a" {
+		t.Fatal("Snapshot entry was not restored")
+	}
+	if val, _ := restored.GetIfPresent("B"); val != "newer" {
+		t.Fatal("Restore replaced an existing entry")
+	}
+	if _, ok := restored.GetIfPresent("C"); ok {
+		t.Fatal("Expired entry was restored")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if _, err := NewCache(WithSizer(func(key, value interface{}) uint64 { return 1 })); err == nil {
+		t.Fatal("Sizer without MaxStorage was accepted")
+	}
+	c, err := NewCache(WithRefresh(), WithGetTimeout(time.Millisecond))
+	noError(t, err)
+	for i := 0; i < 3; i++ {
+		c.Get(i, 0, func(interface{}) (interface{}, error) {
+			time.Sleep(5 * time.Millisecond)
+			return nil, nil
+		})()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := c.Validate(); err == nil {
+		t.Fatal("Slow generation and zero TTL Gets were not reported")
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	c := &Cache{MaxSize: 10, TrackOrigin: true, Refresh: true}
+	_, err := c.GetWithOptions("A", GetOptions{TTL: 20 * time.Millisecond, Namespace: "users"}, getGeneratorStub("a", nil))()
+	noError(t, err)
+	c.Set("B", "b", 100*time.Second)
+	meta, ok := c.Info("A")
+	if !ok || meta.Source != SourceMiss || meta.Namespace != "users" || !strings.Contains(meta.Caller, "TestOrigin") {
+		t.Fatalf("Unexpected origin %+v", meta)
+	}
+	if meta, _ := c.Info("B"); meta.Source != SourceSet {
+		t.Fatalf("Unexpected origin %+v", meta)
+	}
+	time.Sleep(15 * time.Millisecond)
+	c.Get("A", 20*time.Millisecond, getGeneratorStub("a", nil))()
+	time.Sleep(5 * time.Millisecond)
+	if meta, _ := c.Info("A"); meta.Source != SourceRefresh {
+		t.Fatalf("Refresh was not recorded: %+v", meta)
+	}
+	var dump bytes.Buffer
+	noError(t, c.Dump(&dump))
+	if !strings.Contains(dump.String(), "source=set") {
+		t.Fatalf("Unexpected dump %q", dump.String())
+	}
+}
+
+type mapStore struct {
+	mutex sync.Mutex
+	data  map[interface{}]interface{}
+}
+
+func (s *mapStore) Get(key interface{}) (interface{}, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	val, ok := s.data[key]
+	return val, ok, nil
+}
+
+func (s *mapStore) Set(key, value interface{}, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data[key] = value
+	return nil
+}
+
+func (s *mapStore) Delete(key interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func TestSecondaryStore(t *testing.T) {
+	store := &mapStore{data: map[interface{}]interface{}{"A": "stored"}}
+	c := &Cache{MaxSize: 10, Store: store}
+	expectCacheValue(t, c, "A", 100*time.Second, "generated", "stored", "Store was not consulted on a miss")
+	expectCacheValue(t, c, "B", 100*time.Second, "b", "b", "Generator was not called")
+	if val, _, _ := store.Get("B"); val != "b" {
+		t.Fatal("Generated value was not written through")
+	}
+	c.Delete("A")
+	if _, ok, _ := store.Get("A"); ok {
+		t.Fatal("Delete was not passed through")
+	}
+	if c.Stats().StoreHits != 1 {
+		t.Fatal("Store hit was not counted")
+	}
+}
+
+func TestGetMultiStore(t *testing.T) {
+	store := &mapStore{data: map[interface{}]interface{}{"A": "stored"}}
+	c := &Cache{MaxSize: 10, Store: store}
+	var requested []interface{}
+	vals, err := c.GetMulti([]interface{}{"A", "B"}, 100*time.Second, func(keys []interface{}) (map[interface{}]interface{}, error) {
+		requested = keys
+		return map[interface{}]interface{}{"A": "generated", "B": "b"}, nil
+	})()
+	noError(t, err)
+	if vals["A"] != "stored" || vals["B"] != "b" || len(requested) != 1 || requested[0] != "B" {
+		t.Fatalf("Store was not consulted before the batch (%v requested, %v returned)", requested, vals)
+	}
+	if val, _, _ := store.Get("B"); val != "b" {
+		t.Fatal("Batch value was not written through")
+	}
+}
+
+type tierStore struct {
+	mapStore
+	entries map[interface{}]StoredEntry
+}
+
+func (s *tierStore) Tier() string { return "l2" }
+
+func (s *tierStore) GetEntry(key interface{}) (StoredEntry, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[key]
+	return entry, ok, nil
+}
+
+func (s *tierStore) SetEntry(key interface{}, entry StoredEntry, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[key] = entry
+	return nil
+}
+
+func TestProvenanceStore(t *testing.T) {
+	generated := time.Now().Add(-40 * time.Second)
+	store := &tierStore{entries: map[interface{}]StoredEntry{
+		"A": {Value: "stored", Generated: generated, Provenance: []string{"peer"}},
+		"B": {Value: "stale", Generated: time.Now().Add(-150 * time.Second)},
+	}}
+	c := &Cache{MaxSize: 10, Store: store}
+	expectCacheValue(t, c, "A", 100*time.Second, "generated", "stored", "Store was not consulted on a miss")
+	info, _ := c.Info("A")
+	if !info.Created.Equal(generated) || info.Remaining > 60*time.Second {
+		t.Fatalf("Lifetime restarted at the cache tier: %+v", info)
+	}
+	if len(info.Provenance) != 2 || info.Provenance[0] != "peer" || info.Provenance[1] != "l2" {
+		t.Fatalf("Unexpected provenance %v", info.Provenance)
+	}
+	expectCacheValue(t, c, "B", 100*time.Second, "b", "b", "Expired store entry was used")
+	if entry, _, _ := store.GetEntry("B"); entry.Value != "b" || time.Since(entry.Generated) > time.Second {
+		t.Fatalf("Generated value was not written through with its generation time: %+v", entry)
+	}
+	if info, _ := c.Info("B"); info.Provenance != nil {
+		t.Fatal("Locally generated value has provenance")
+	}
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	policies := []struct {
+		name    string
+		policy  EvictionPolicy
+		evicted string
+	}{
+		{"LRU", NewLRUPolicy(), "B"},
+		{"FIFO", NewFIFOPolicy(), "A"},
+		{"LFU", NewLFUPolicy(), "B"},
+		{"SIEVE", NewSIEVEPolicy(), "B"},
+		{"CLOCK", NewClockPolicy(0), "B"},
+		{"bounded CLOCK", NewClockPolicy(1), "B"},
+	}
+	for _, p := range policies {
+		c := &Cache{MaxSize: 3, Eviction: p.policy}
+		for _, key := range []string{"A", "B", "C", "A", "C", "D"} {
+			setCacheValue(t, c, key, 100*time.Second, key)
+		}
+		for _, key := range []string{"A", "B", "C", "D"} {
+			if _, ok := c.GetIfPresent(key); ok == (key == p.evicted) {
+				t.Fatalf("%s: expected %s to be evicted", p.name, p.evicted)
+			}
+		}
+	}
+}
+
+func TestClockSweep(t *testing.T) {
+	for _, sweep := range []int{0, 1} {
+		p := NewClockPolicy(sweep)
+		for _, key := range []string{"A", "B", "C"} {
+			p.OnAdd(key)
+			p.OnAccess(key)
+		}
+		expected := map[int]string{0: "A", 1: "B"}[sweep]
+		if victim, _ := p.Victim(); victim != expected {
+			t.Fatalf("Sweep of %d chose %v, expected %s", sweep, victim, expected)
+		}
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	c := &Cache{MaxSize: 10, SchemaVersion: "v1", Codec: VersionedCodec{Version: "v1"}}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	var buf bytes.Buffer
+	noError(t, c.SaveTo(&buf))
+	snapshot := buf.Bytes()
+
+	restored := &Cache{MaxSize: 10, SchemaVersion: "v2"}
+	noError(t, restored.LoadFrom(bytes.NewReader(snapshot)))
+	if restored.Size() != 0 {
+		t.Fatal("Snapshot from another schema version was loaded")
+	}
+	restored = &Cache{MaxSize: 10, SchemaVersion: "v1", Codec: VersionedCodec{Version: "v1.1"}}
+	noError(t, restored.LoadFrom(bytes.NewReader(snapshot)))
+	if restored.Size() != 0 {
+		t.Fatal("Entry from another codec version was loaded")
+	}
+	restored = &Cache{MaxSize: 10, SchemaVersion: "v1", Codec: VersionedCodec{Version: "v1"}}
+	noError(t, restored.LoadFrom(bytes.NewReader(snapshot)))
+	if val, _ := restored.GetIfPresent("A"); val != "a" {
+		t.Fatal("Snapshot from the same schema version was not loaded")
+	}
+}
+
+func TestScope(t *testing.T) {
+	store := &mapStore{data: make(map[interface{}]interface{})}
+	east := &Cache{MaxSize: 10, Store: store, Scope: "east"}
+	west := &Cache{MaxSize: 10, Store: store, Scope: "west"}
+	expectCacheValue(t, east, "A", 100*time.Second, "east", "east", "Generator was not called")
+	expectCacheValue(t, west, "A", 100*time.Second, "west", "west", "Store entry leaked across scopes")
+
+	var buf bytes.Buffer
+	noError(t, east.SaveTo(&buf))
+	snapshot := buf.Bytes()
+	restored := &Cache{MaxSize: 10, Scope: "west"}
+	noError(t, restored.LoadFrom(bytes.NewReader(snapshot)))
+	if restored.Size() != 0 {
+		t.Fatal("Snapshot entry leaked across scopes")
+	}
+	restored = &Cache{MaxSize: 10, Scope: "east"}
+	noError(t, restored.LoadFrom(bytes.NewReader(snapshot)))
+	if val, _ := restored.GetIfPresent("A"); val != "east" {
+		t.Fatal("Snapshot entry was not restored in its scope")
+	}
+}
+
+func TestRange(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	setCacheValue(t, c, "B", 100*time.Second, "b")
+	c.Get("C", 100*time.Second, getGeneratorStub("c", errors.New("Failed")))()
+	if keys := c.Keys(); len(keys) != 2 {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+	seen := make(map[interface{}]interface{})
+	c.Range(func(key, value interface{}, meta EntryInfo) bool {
+		if meta.Remaining <= 0 || meta.Remaining > 100*time.Second || meta.Age < 0 {
+			t.Fatalf("Unexpected info %+v", meta)
+		}
+		c.Delete(key) // Range must not hold the lock
+		seen[key] = value
+		return true
+	})
+	if len(seen) != 2 || seen["A"] != "a" || seen["B"] != "b" {
+		t.Fatalf("Unexpected entries %v", seen)
+	}
+	calls := 0
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	setCacheValue(t, c, "B", 100*time.Second, "b")
+	c.Range(func(key, value interface{}, meta EntryInfo) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Fatal("Range continued after fn returned false")
+	}
+}
+
+func TestKeyDistribution(t *testing.T) {
+	c := &Cache{MaxSize: 1000}
+	for i := 0; i < 800; i++ {
+		c.Set(i, i, 100*time.Second)
+	}
+	if skew := c.KeyDistribution(8).Skew(); skew > 1.5 {
+		t.Fatalf("Default hash is skewed: %v", skew)
+	}
+	c.Hash = func(key interface{}) uint64 { return 0 }
+	if skew := c.KeyDistribution(8).Skew(); skew != 8 {
+		t.Fatalf("Hash override was not used: %v", skew)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	c := &Cache{MaxSize: 10, Eviction: NewLRUPolicy()}
+	if _, ok := c.Peek("A"); ok {
+		t.Fatal("Peek found a missing key")
+	}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	setCacheValue(t, c, "B", 100*time.Second, "b")
+	before, _ := c.Info("A")
+	if val, ok := c.Peek("A"); !ok || val != "a" {
+		t.Fatal("Peek did not return the cached value")
+	}
+	if after, _ := c.Info("A"); !after.LastUsed.Equal(before.LastUsed) {
+		t.Fatal("Peek updated the entry's last use")
+	}
+	if victim, _ := c.Eviction.Victim(); victim != "A" {
+		t.Fatal("Peek changed the eviction order")
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	var mutex sync.Mutex
+	live := make(map[interface{}]int)
+	c := &Cache{MaxSize: 10, Refresh: true}
+	c.OnStore = func(key, value interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		live[value]++
+	}
+	c.OnRelease = func(key, value interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		live[value]--
+	}
+	setCacheValue(t, c, "A", 20*time.Millisecond, "a1")
+	time.Sleep(15 * time.Millisecond)
+	setCacheValue(t, c, "A", 20*time.Millisecond, "a2") // Refreshes to a2 in the background
+	time.Sleep(5 * time.Millisecond)
+	c.Set("B", "b1", 100*time.Second)
+	c.Set("B", "b2", 100*time.Second)
+	c.Get("C", 0, getGeneratorStub("c", nil))()
+	mutex.Lock()
+	if live["a1"] != 0 || live["a2"] != 1 || live["b1"] != 0 || live["b2"] != 1 || live["c"] != 0 {
+		t.Fatalf("Unexpected live values %v", live)
+	}
+	mutex.Unlock()
+	noError(t, c.Close(context.Background()))
+	mutex.Lock()
+	defer mutex.Unlock()
+	for val, n := range live {
+		if n != 0 {
+			t.Fatalf("Value %v was not released exactly once", val)
+		}
+	}
+}
+
+func TestTouch(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	if c.Touch("A", time.Second) {
+		t.Fatal("Touch found a missing key")
+	}
+	setCacheValue(t, c, "A", 20*time.Millisecond, "a")
+	time.Sleep(15 * time.Millisecond)
+	if !c.Touch("A", 0) {
+		t.Fatal("Touch did not find the entry")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := c.GetIfPresent("A"); !ok {
+		t.Fatal("Touch did not restart expiry")
+	}
+	c.Touch("A", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.GetIfPresent("A"); ok {
+		t.Fatal("Touch did not replace the TTL")
+	}
+}
+
+func TestMaxConcurrentGenerations(t *testing.T) {
+	c := &Cache{MaxSize: 100, MaxConcurrentGenerations: 2}
+	var mutex sync.Mutex
+	running, peak := 0, 0
+	generate := func(key interface{}) (interface{}, error) {
+		mutex.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return key, nil
+	}
+	var results []func() (interface{}, error)
+	for i := 0; i < 10; i++ {
+		results = append(results, c.Get(i, 100*time.Second, generate))
+	}
+	for i, result := range results {
+		if val, err := result(); err != nil || val != i {
+			t.Fatal("Queued generation returned the wrong value")
+		}
+	}
+	if peak != 2 {
+		t.Fatalf("Expected 2 concurrent generations, saw %d", peak)
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	for _, policy := range []SetPolicy{SetWins, GenerationWins} {
+		store := &mapStore{data: make(map[interface{}]interface{})}
+		c := &Cache{MaxSize: 10, SetPolicy: policy, Store: store}
+		started, release := make(chan struct{}), make(chan struct{})
+		result := c.Get("A", 100*time.Second, func(interface{}) (interface{}, error) {
+			close(started)
+			<-release
+			return "generated", nil
+		})
+		<-started // Past the Store read
+		c.Set("A", "set", 100*time.Second)
+		close(release)
+		if val, err := result(); err != nil || val != "generated" {
+			t.Fatal("Waiter did not receive the generated value")
+		}
+		expected := "set"
+		if policy == GenerationWins {
+			expected = "generated"
+		}
+		if val, _ := c.GetIfPresent("A"); val != expected {
+			t.Fatalf("Policy %d kept %v", policy, val)
+		}
+		if val, _, _ := store.Get("A"); val != expected {
+			t.Fatalf("Policy %d left %v in Store", policy, val)
+		}
+	}
+}
+
+func TestCancelAbandoned(t *testing.T) {
+	c := &Cache{MaxSize: 10, GetTimeout: 5 * time.Millisecond, CancelAbandoned: true}
+	cancelled := make(chan struct{})
+	generate := func(ctx context.Context, key interface{}) (interface{}, error) {
+		select {
+		case <-ctx.Done():
+			close(cancelled)
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return "late", nil
+		}
+	}
+	first := c.GetContext("A", 100*time.Second, generate)
+	second := c.GetContext("A", 100*time.Second, generate)
+	if _, err := first(); err != ErrTimeout {
+		t.Fatal("Get did not time out")
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("Generation was cancelled while a caller was still waiting")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if _, err := second(); err != ErrTimeout {
+		t.Fatal("Get did not time out")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Abandoned generation was not cancelled")
+	}
+	if c.Stats().Cancellations != 1 {
+		t.Fatal("Cancellation was not counted")
+	}
+}
+
+func TestAbandonedNotCached(t *testing.T) {
+	breaker := &CircuitBreaker{Threshold: 1}
+	c := &Cache{MaxSize: 10, GetTimeout: 5 * time.Millisecond, CancelAbandoned: true, ErrorTTL: 100 * time.Second, Breaker: breaker}
+	_, err := c.GetContext("A", 100*time.Second, func(ctx context.Context, key interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})()
+	if err != ErrTimeout {
+		t.Fatal("Get did not time out")
+	}
+	c.inflight.Wait()
+	if breaker.Open("A") {
+		t.Fatal("Cancelled generation counted as a failure")
+	}
+	expectCacheValue(t, c, "A", 100*time.Second, "A", "A", "Cancelled generation was cached")
+}
+
+func TestGetAfterAbandon(t *testing.T) {
+	c := &Cache{MaxSize: 10, GetTimeout: 20 * time.Millisecond, CancelAbandoned: true}
+	cancelled, release := make(chan struct{}), make(chan struct{})
+	_, err := c.GetContext("A", 100*time.Second, func(ctx context.Context, key interface{}) (interface{}, error) {
+		<-ctx.Done()
+		close(cancelled)
+		<-release // Keep the cancelled generation in flight
+		return nil, ctx.Err()
+	})()
+	if err != ErrTimeout {
+		t.Fatal("Get did not time out")
+	}
+	<-cancelled
+	val, err := c.GetWithOptions("A", GetOptions{TTL: 100 * time.Second, Timeout: time.Second}, getGeneratorStub("a", nil))()
+	close(release)
+	if err != nil || val != "a" {
+		t.Fatalf("Get joined the cancelled generation: %v, %v", val, err)
+	}
+	c.inflight.Wait()
+	expectCacheValue(t, c, "A", 100*time.Second, "b", "a", "Fresh value was not cached")
+}
+
+func TestApproxGauges(t *testing.T) {
+	c := &Cache{MaxSize: 10, MaxStorage: 1000, Sizer: func(key, value interface{}) uint64 { return 10 }}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	c.Set("B", "b", 100*time.Second)
+	if c.ApproxSize() != 2 || c.ApproxStorage() != 22 {
+		t.Fatalf("Unexpected gauges %d, %d", c.ApproxSize(), c.ApproxStorage())
+	}
+	c.Delete("A")
+	if c.ApproxSize() != 1 || c.ApproxStorage() != 11 {
+		t.Fatalf("Unexpected gauges %d, %d", c.ApproxSize(), c.ApproxStorage())
+	}
+}
+
+func TestMaxRefreshFailures(t *testing.T) {
+	refreshed := make(chan error, 1)
+	exhausted := make(chan interface{}, 1)
+	c := &Cache{MaxSize: 10, Refresh: true, MaxRefreshFailures: 2}
+	c.OnRefreshEnd = func(key interface{}, err error) {
+		refreshed <- err
+	}
+	c.OnRefreshExhausted = func(key interface{}, err error) {
+		exhausted <- key
+	}
+	c.Set("A", "a", 100*time.Second)
+	for i := 0; i < 2; i++ {
+		backdate(c, "A", 60*time.Second)
+		if val, err := c.Get("A", 100*time.Second, getGeneratorStub(nil, errors.New("Failed")))(); err != nil || val != "a" {
+			t.Fatal("Old value was not served")
+		}
+		<-refreshed
+	}
+	if _, ok := c.GetIfPresent("A"); ok || c.Size() != 0 {
+		t.Fatal("Entry was not evicted after repeated refresh failures")
+	}
+	select {
+	case key := <-exhausted:
+		if key != "A" {
+			t.Fatal("OnRefreshExhausted was called with the wrong key")
+		}
+	default:
+		t.Fatal("OnRefreshExhausted was not called")
+	}
+}
+
+// backdate moves the creation time of the entry under key age into the past.
+func backdate(c *Cache, key interface{}, age time.Duration) {
+	c.lockMap()
+	c.data[key].created = time.Now().Add(-age)
+	c.mutex.Unlock()
+}
+
+func TestRetainedSet(t *testing.T) {
+	c := &Cache{MaxSize: 10, RefreshInterval: time.Hour}
+	defer c.Close(context.Background())
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	c.Set("B", "b", 100*time.Second)
+	if len(c.retained) != 1 || c.retained["A"] == nil {
+		t.Fatalf("Unexpected retained entries %v", c.retained)
+	}
+	c.Delete("A")
+	if len(c.retained) != 0 {
+		t.Fatal("Deleted entry was still retained")
+	}
+}
+
+func TestBackgroundRefresh(t *testing.T) {
+	c := &Cache{MaxSize: 10, RefreshInterval: 5 * time.Millisecond}
+	defer c.Close(context.Background())
+	var mutex sync.Mutex
+	calls := 0
+	generate := func(interface{}) (interface{}, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		return calls, nil
+	}
+	c.Get("A", 40*time.Millisecond, generate)()
+	time.Sleep(100 * time.Millisecond)
+	val, ok := c.GetIfPresent("A")
+	if !ok {
+		t.Fatal("Background refreshed entry expired")
+	}
+	if val.(int) < 3 {
+		t.Fatalf("Entry was refreshed only %d times", val.(int)-1)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	c := &Cache{MaxSize: 10, MaxStorage: 1000, Sizer: func(key, value interface{}) uint64 { return uint64(len(value.([]int))) }}
+	if c.Patch("A", func(interface{}) interface{} { return nil }) {
+		t.Fatal("Patch found a missing key")
+	}
+	c.Set("A", []int{1, 2}, 100*time.Second)
+	ok := c.Patch("A", func(current interface{}) interface{} {
+		return append(current.([]int), 3)
+	})
+	if !ok {
+		t.Fatal("Patch did not find the entry")
+	}
+	if val, _ := c.GetIfPresent("A"); len(val.([]int)) != 3 {
+		t.Fatal("Patch did not replace the value")
+	}
+	if c.Stats().Storage != 3+1 {
+		t.Fatalf("Storage was not updated: %d", c.Stats().Storage)
+	}
+}
+
+func TestPatchFullCache(t *testing.T) {
+	c := &Cache{MaxSize: 2}
+	c.Set("A", "a", 100*time.Second)
+	c.Set("B", "b", 100*time.Second)
+	if !c.Patch("A", func(interface{}) interface{} { return "patched" }) {
+		t.Fatal("Patch did not find the entry")
+	}
+	if c.Size() != 2 {
+		t.Fatalf("Patch on a full cache evicted an entry, %d remain", c.Size())
+	}
+}
+
+func TestPatchPanic(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Patch swallowed the panic")
+			}
+		}()
+		c.Patch("A", func(current interface{}) interface{} { panic("Bad patch") })
+	}()
+	expectCacheValue(t, c, "A", 100*time.Second, "", "a", "Panicking patch changed the value")
+}
+
+func TestTTLJitter(t *testing.T) {
+	c := &Cache{MaxSize: 100, TTLJitter: 0.5}
+	ttls := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		c.Set(i, i, 100*time.Second)
+		meta, _ := c.Info(i)
+		if meta.TTL < 50*time.Second || meta.TTL > 150*time.Second {
+			t.Fatalf("TTL %v is outside the jitter range", meta.TTL)
+		}
+		ttls[meta.TTL] = true
+	}
+	if len(ttls) < 2 {
+		t.Fatal("TTLs were not jittered")
+	}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	first, _ := c.Info("A")
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	if second, _ := c.Info("A"); second.TTL != first.TTL {
+		t.Fatal("Jitter changed between Gets of the same entry")
+	}
+}
+
+func TestOnExpire(t *testing.T) {
+	expired := make(chan interface{}, 10)
+	c := &Cache{MaxSize: 10, PurgeInterval: 5 * time.Millisecond}
+	defer c.Close(context.Background())
+	c.OnExpire = func(key, value interface{}) {
+		expired <- value
+	}
+	setCacheValue(t, c, "A", 10*time.Millisecond, "a")
+	setCacheValue(t, c, "B", 100*time.Second, "b")
+	select {
+	case val := <-expired:
+		if val != "a" {
+			t.Fatalf("Unexpected expired value %v", val)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Expiry was not reported")
+	}
+	select {
+	case val := <-expired:
+		t.Fatalf("Unexpected expired value %v", val)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestRefreshAll(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	for _, key := range []string{"A", "B", "C", "D"} {
+		setCacheValue(t, c, key, 100*time.Second, key)
+	}
+	report := c.RefreshAll(func(key, value interface{}) bool {
+		return key != "D"
+	}, func(key interface{}) (interface{}, error) {
+		switch key {
+		case "A":
+			return "A", nil
+		case "B":
+			return "new", nil
+		}
+		return nil, errors.New("Failed")
+	}, RefreshOptions{Concurrency: 2})
+	if report.Refreshed != 1 || report.Unchanged != 1 || report.Failed != 1 || report.Errors["C"] == nil {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	for key, expected := range map[string]string{"A": "A", "B": "new", "C": "C", "D": "D"} {
+		if val, _ := c.GetIfPresent(key); val != expected {
+			t.Fatalf("Unexpected value %v for %s", val, key)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	permanent := errors.New("Permanent")
+	c := &Cache{MaxSize: 10, Retry: &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Retryable: func(err error) bool {
+		return err != permanent
+	}}}
+	calls := 0
+	val, err := c.Get("A", 100*time.Second, func(interface{}) (interface{}, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("Transient")
+		}
+		return "a", nil
+	})()
+	if err != nil || val != "a" || calls != 3 {
+		t.Fatalf("Generation was not retried (%d calls)", calls)
+	}
+	calls = 0
+	_, err = c.Get("B", 100*time.Second, func(interface{}) (interface{}, error) {
+		calls++
+		return nil, permanent
+	})()
+	if err != permanent || calls != 1 {
+		t.Fatal("Non-retryable error was retried")
+	}
+}
+
+func TestRetryGetMulti(t *testing.T) {
+	c := &Cache{MaxSize: 10, Retry: &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}}
+	calls := 0
+	vals, err := c.GetMulti([]interface{}{"A", "B", "C", "D"}, 100*time.Second, func(keys []interface{}) (map[interface{}]interface{}, error) {
+		calls++
+		if calls < 2 {
+			return nil, errors.New("Transient")
+		}
+		vals := make(map[interface{}]interface{})
+		for _, key := range keys {
+			vals[key] = key
+		}
+		return vals, nil
+	})()
+	if err != nil || len(vals) != 4 || calls != 2 {
+		t.Fatalf("Batch was not retried as a whole (%d calls, %v, %v)", calls, vals, err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	c := &Cache{MaxSize: 10, Breaker: &CircuitBreaker{Threshold: 2, Cooldown: 20 * time.Millisecond}}
+	calls := 0
+	failing := func(interface{}) (interface{}, error) {
+		calls++
+		return nil, errors.New("Down")
+	}
+	for i := 0; i < 2; i++ {
+		c.Get(i, 100*time.Second, failing)()
+	}
+	if !c.Breaker.Open("X") {
+		t.Fatal("Circuit did not open")
+	}
+	if _, err := c.Get("X", 100*time.Second, failing)(); err != ErrCircuitOpen || calls != 2 {
+		t.Fatal("Open circuit called the generator")
+	}
+	time.Sleep(25 * time.Millisecond)
+	expectCacheValue(t, c, "X", 100*time.Second, "x", "x", "Probe was not let through")
+	if c.Breaker.Open("X") {
+		t.Fatal("Successful probe did not close the circuit")
+	}
+}
+
+func TestCircuitBreakerProbePanic(t *testing.T) {
+	c := &Cache{MaxSize: 10, Recover: true, Breaker: &CircuitBreaker{Threshold: 1, Cooldown: time.Millisecond}}
+	c.Get("A", 100*time.Second, getGeneratorStub(nil, errors.New("Down")))()
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.Get("B", 100*time.Second, func(interface{}) (interface{}, error) { panic("Down") })(); err == nil || err == ErrCircuitOpen {
+		t.Fatal("Panicking probe was not let through")
+	}
+	time.Sleep(5 * time.Millisecond)
+	expectCacheValue(t, c, "C", 100*time.Second, "c", "c", "Circuit stayed open after a panicking probe")
+}
+
+func TestMinFreshness(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	setCacheValue(t, c, "A", 100*time.Second, "old")
+	written := time.Now()
+	val, err := c.GetWithOptions("A", GetOptions{TTL: 100 * time.Second, MinFreshness: written.Add(-time.Hour)}, getGeneratorStub("new", nil))()
+	if err != nil || val != "old" {
+		t.Fatal("Fresh enough value was regenerated")
+	}
+	val, err = c.GetWithOptions("A", GetOptions{TTL: 100 * time.Second, MinFreshness: written}, getGeneratorStub("new", nil))()
+	if err != nil || val != "new" {
+		t.Fatal("Value older than MinFreshness was returned")
+	}
+	expectCacheValue(t, c, "A", 100*time.Second, "newer", "new", "Regenerated value was not cached")
+}
+
+func TestInvalidatePrefix(t *testing.T) {
+	store := &mapStore{data: make(map[interface{}]interface{})}
+	c := &Cache{MaxSize: 10, Store: store}
+	for _, key := range []string{"user:1", "user:2", "org:1"} {
+		setCacheValue(t, c, key, 100*time.Second, key)
+	}
+	c.Set(1, 1, 100*time.Second)
+	if n := c.InvalidatePrefix("user:"); n != 2 {
+		t.Fatalf("Expected 2 entries invalidated, got %d", n)
+	}
+	if _, ok := c.GetIfPresent("user:1"); ok {
+		t.Fatal("Matching entry was not invalidated")
+	}
+	if _, ok, _ := store.Get("user:2"); ok {
+		t.Fatal("Invalidation was not passed through to Store")
+	}
+	if c.Size() != 2 {
+		t.Fatal("Unrelated entries were invalidated")
+	}
+	if n := c.InvalidateFunc(func(key interface{}) bool { return key == 1 }); n != 1 || c.Size() != 1 {
+		t.Fatal("Predicate invalidation failed")
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	c.Freeze()
+	written := make(chan struct{})
+	go func() {
+		c.Set("A", "b", 100*time.Second)
+		close(written)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if val, _ := c.GetIfPresent("A"); val != "a" {
+		t.Fatal("Write was not blocked by Freeze")
+	}
+	var buf bytes.Buffer
+	noError(t, c.SaveTo(&buf))
+	c.Thaw()
+	<-written
+	if val, _ := c.GetIfPresent("A"); val != "b" {
+		t.Fatal("Write did not resume after Thaw")
+	}
+}
+
+func TestGetWhileFrozen(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	c.Freeze()
+	defer c.Thaw()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if val, _ := c.Get("A", 100*time.Second, getGeneratorStub("", nil))(); val != "a" {
+			t.Error("Hit was not served while frozen")
+		}
+		if val, _ := c.Get("B", 100*time.Second, getGeneratorStub("b", nil))(); val != "b" {
+			t.Error("Miss was not generated while frozen")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Get blocked while the cache was frozen")
+	}
+}
+
+func TestComposition(t *testing.T) {
+	c := &Cache{MaxSize: 100, MaxStorage: 100000, Sizer: func(key, value interface{}) uint64 {
+		if s, ok := value.(string); ok {
+			return uint64(len(s))
+		}
+		return 100
+	}}
+	for i := 0; i < 10; i++ {
+		c.Set(i, "abc", 100*time.Second)
+	}
+	c.Set("slice", []int{1}, 100*time.Second)
+	usage := c.Composition(1)
+	if len(usage) != 2 {
+		t.Fatalf("Unexpected composition %+v", usage)
+	}
+	if usage[0].Type != "[]int" || usage[0].Storage != 100 || usage[1].Type != "string" || usage[1].Entries != 10 || usage[1].Storage != 30 {
+		t.Fatalf("Unexpected composition %+v", usage)
+	}
+}
+
+func TestKeyCardinality(t *testing.T) {
+	c := &Cache{MaxSize: 10, TrackCardinality: true}
+	for i := 0; i < 20000; i++ {
+		c.Get(i%5000, 0, getGeneratorStub("a", nil))
+	}
+	if n := c.KeyCardinality(); n < 4800 || n > 5200 {
+		t.Fatalf("Estimated %d distinct keys, expected about 5000", n)
+	}
+}
+
+func TestResultStaleness(t *testing.T) {
+	c := &Cache{MaxSize: 1, StaleOnError: 100 * time.Second}
+	c.Purge()
+	var future sync.WaitGroup
+	c.data["test"] = &cacheItem{future: &future, ttl: 10 * time.Second, created: time.Now().Add(-75 * time.Second), val: "A"}
+	r := c.GetResult("test", GetOptions{TTL: 10 * time.Second}, getGeneratorStub(nil, errors.New("Test Error")))()
+	noError(t, r.Err)
+	if r.Value != "A" || r.Staleness < 65*time.Second || r.Staleness > 66*time.Second {
+		t.Fatalf("Unexpected result %+v", r)
+	}
+	r = c.GetResult("fresh", GetOptions{TTL: 10 * time.Second}, getGeneratorStub("B", nil))()
+	if r.Value != "B" || r.Stale() || r.Hit {
+		t.Fatalf("Unexpected result %+v", r)
+	}
+}
+
+func TestOnPanic(t *testing.T) {
+	type report struct {
+		key, recovered interface{}
+		stack          string
+	}
+	reports := make(chan report, 1)
+	c := &Cache{MaxSize: 10, Recover: true, OnPanic: func(key, recovered interface{}, stack []byte) {
+		reports <- report{key, recovered, string(stack)}
+	}}
+	_, err := c.Get("A", 100*time.Second, func(interface{}) (interface{}, error) {
+		panic("boom")
+	})()
+	if err == nil {
+		t.Fatal("Panic was not converted into an error")
+	}
+	r := <-reports
+	if r.key != "A" || r.recovered != "boom" || !strings.Contains(r.stack, "TestOnPanic") {
+		t.Fatalf("Unexpected panic report %+v", r)
+	}
+}
+
+func TestAutoTimeout(t *testing.T) {
+	c := &Cache{MaxSize: 100, AutoTimeout: &TimeoutBounds{Min: 20 * time.Millisecond, Max: time.Second}}
+	for i := 0; i < minLatencySamples; i++ {
+		expectCacheValue(t, c, i, 100*time.Second, "a", "a", "Generator was not called")
+	}
+	if d := c.GenerationLatency("", 0.99); d <= 0 || d > 10*time.Millisecond {
+		t.Fatalf("Unexpected latency %v", d)
+	}
+	if c.SuggestedTimeout("other") != 0 {
+		t.Fatal("Timeout suggested without samples")
+	}
+	_, err := c.Get("slow", 100*time.Second, func(interface{}) (interface{}, error) {
+		time.Sleep(100 * time.Millisecond)
+		return "b", nil
+	})()
+	if err != ErrTimeout {
+		t.Fatalf("Expected the suggested timeout to apply, got %v", err)
+	}
+}
+
+func TestSuggestedTimeoutCached(t *testing.T) {
+	tracker := &latencyTracker{windows: make(map[string]*latencyRing)}
+	for i := 0; i < latencyWindow; i++ {
+		tracker.record("", time.Millisecond)
+	}
+	if d := tracker.suggest(""); d != 1500*time.Microsecond {
+		t.Fatalf("Unexpected suggestion %v", d)
+	}
+	tracker.windows[""].recorded = 0
+	for i := 0; i < latencyWindow/suggestEvery; i++ {
+		if d := tracker.suggest(""); d != 1500*time.Microsecond {
+			t.Fatalf("Suggestion was recomputed after %d samples", i)
+		}
+		tracker.record("", time.Second)
+	}
+	if d := tracker.suggest(""); d != 1500*time.Millisecond {
+		t.Fatalf("Suggestion was not recomputed, got %v", d)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	c := &Cache{MaxSize: 10, Refresh: true}
+	if _, ok := c.Info("A"); ok {
+		t.Fatal("Info found a missing key")
+	}
+	setCacheValue(t, c, "A", 40*time.Millisecond, "a")
+	c.GetIfPresent("A")
+	time.Sleep(25 * time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	val, err := c.Get("A", 40*time.Millisecond, func(interface{}) (interface{}, error) {
+		<-release
+		return "b", nil
+	})()
+	noError(t, err)
+	if val != "a" {
+		t.Fatal("Current value was not served while refreshing")
+	}
+	info, ok := c.Info("A")
+	if !ok || info.Hits != 2 || !info.Refreshing {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+	if info.Remaining <= 0 || info.Remaining > 40*time.Millisecond || info.LastUsed.IsZero() {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	leader := &Cache{MaxSize: 10}
+	follower := &Cache{MaxSize: 10, Replica: true, MaxStaleness: time.Minute}
+	changes := make(chan Change, 10)
+	cancel := leader.Subscribe(func(change Change) { changes <- change })
+	done := make(chan struct{})
+	go func() {
+		follower.Follow(changes)
+		close(done)
+	}()
+	leader.Set("A", "a", 100*time.Second) // Set publishes before returning
+	leader.Set("B", "b", 100*time.Second)
+	leader.Delete("B")
+	cancel()
+	close(changes)
+	<-done
+	expectCacheValue(t, follower, "A", 100*time.Second, "generated", "a", "Replicated value was not served")
+	if _, err := follower.Get("B", 100*time.Second, getGeneratorStub("b", nil))(); err != ErrNotReplicated {
+		t.Fatal("Deletion was not replicated")
+	}
+	follower.Apply(Change{Key: "C", Value: "c", Generated: time.Now().Add(-2 * time.Minute), Expires: time.Now().Add(time.Minute)})
+	if _, err := follower.Get("C", 100*time.Second, getGeneratorStub("c", nil))(); err != ErrNotReplicated {
+		t.Fatal("Value beyond MaxStaleness was served")
+	}
+	if info, _ := follower.Info("A"); info.Remaining > 100*time.Second {
+		t.Fatal("Replicated entry outlives the leader's")
+	}
+}
+
+func TestReplicaOutOfOrder(t *testing.T) {
+	follower := &Cache{MaxSize: 10, Replica: true}
+	now := time.Now()
+	expires := now.Add(time.Minute)
+	follower.Apply(Change{Key: "A", Value: "new", Generated: now, Expires: expires})
+	follower.Apply(Change{Key: "A", Value: "old", Generated: now.Add(-time.Second), Expires: expires})
+	if val, _ := follower.GetIfPresent("A"); val != "new" {
+		t.Fatal("Older value rolled the entry back")
+	}
+	follower.Apply(Change{Key: "A", Generated: now.Add(-time.Second), Deleted: true})
+	if _, ok := follower.GetIfPresent("A"); !ok {
+		t.Fatal("Older deletion removed a newer entry")
+	}
+	follower.Apply(Change{Key: "B", Generated: now, Deleted: true})
+	follower.Apply(Change{Key: "B", Value: "b", Generated: now.Add(-time.Second), Expires: expires})
+	if _, ok := follower.GetIfPresent("B"); ok {
+		t.Fatal("Delayed change brought back a deleted key")
+	}
+	follower.Apply(Change{Key: "B", Value: "b", Generated: now.Add(time.Second), Expires: expires})
+	if _, ok := follower.GetIfPresent("B"); !ok {
+		t.Fatal("Change after the deletion was dropped")
+	}
+}
+
+func TestReplicaGetMulti(t *testing.T) {
+	follower := &Cache{MaxSize: 10, Replica: true}
+	follower.Apply(Change{Key: "A", Value: "a", Generated: time.Now(), Expires: time.Now().Add(time.Minute)})
+	var calls int32
+	vals, err := follower.GetMulti([]interface{}{"A", "B"}, 100*time.Second, func(keys []interface{}) (map[interface{}]interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return map[interface{}]interface{}{"A": "generated", "B": "generated"}, nil
+	})()
+	if err != ErrNotReplicated || len(vals) != 1 || vals["A"] != "a" {
+		t.Fatalf("Unexpected batch result %v, %v", vals, err)
+	}
+	follower.inflight.Wait()
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Fatal("Batch generator was called on a replica")
+	}
+	if _, ok := follower.GetIfPresent("B"); ok {
+		t.Fatal("Replica stored a locally generated value")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var r Registry
+	a, b := &Cache{MaxSize: 10}, &Cache{MaxSize: 10}
+	noError(t, r.Register("a", a))
+	noError(t, r.Register("b", b))
+	if r.Register("a", b) != ErrRegistered {
+		t.Fatal("Duplicate name was registered")
+	}
+	a.Set("A", "a", 10*time.Millisecond)
+	b.Set("B", "b", 100*time.Second)
+	time.Sleep(20 * time.Millisecond)
+	r.PurgeAll()
+	stats := r.Stats()
+	if len(stats) != 2 || stats["a"].Entries != 0 || stats["b"].Entries != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	r.Unregister("a")
+	if names := r.Names(); len(names) != 1 || names[0] != "b" {
+		t.Fatalf("Unexpected names %v", names)
+	}
+}
+
+// expvarRuns makes TestPublishExpvar's name unique to each run, since expvar names can't be unpublished.
+var expvarRuns int32
+
+func TestPublishExpvar(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	name := fmt.Sprint("flowcache_test_", atomic.AddInt32(&expvarRuns, 1))
+	noError(t, c.PublishExpvar(name))
+	if c.PublishExpvar(name) == nil {
+		t.Fatal("Name was published twice")
+	}
+	setCacheValue(t, c, "A", 100*time.Second, "a")
+	if v := expvar.Get(name).String(); !strings.Contains(v, `"misses":1`) || !strings.Contains(v, `"size":1`) {
+		t.Fatalf("Unexpected expvar %s", v)
+	}
+
+	name = fmt.Sprint("flowcache_test_", atomic.AddInt32(&expvarRuns, 1))
+	invalid := func(c *Cache) error {
+		c.RefreshFraction = 2
+		return nil
+	}
+	if _, err := NewCache(WithExpvar(name), invalid); err == nil {
+		t.Fatal("Invalid configuration was not rejected")
+	}
+	_, err := NewCache(WithExpvar(name))
+	noError(t, err)
+	if expvar.Get(name) == nil {
+		t.Fatal("WithExpvar did not publish")
+	}
+}
+
+func TestTransform(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	upper := GetOptions{TTL: 100 * time.Second, Transform: func(v interface{}) (interface{}, error) {
+		return strings.ToUpper(v.(string)), nil
+	}}
+	val, err := c.GetWithOptions("A", upper, getGeneratorStub("a", nil))()
+	noError(t, err)
+	if val != "A" {
+		t.Fatal("Transform was not applied")
+	}
+	expectCacheValue(t, c, "A", 100*time.Second, "b", "a", "Transform modified the cached value")
+	failing := GetOptions{TTL: 100 * time.Second, Transform: func(interface{}) (interface{}, error) {
+		return nil, errors.New("Transform failed")
+	}}
+	if _, err := c.GetWithOptions("A", failing, getGeneratorStub("b", nil))(); err == nil {
+		t.Fatal("Transform error was not returned")
+	}
+	expectCacheValue(t, c, "A", 100*time.Second, "b", "a", "Transform error affected the cached value")
+}
+
+type flakyStore struct {
+	mapStore
+	failures int32
+}
+
+func (s *flakyStore) Set(key, value interface{}, ttl time.Duration) error {
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		return errors.New("Store unavailable")
+	}
+	return s.mapStore.Set(key, value, ttl)
+}
+
+func TestWriteBehind(t *testing.T) {
+	store := &flakyStore{mapStore: mapStore{data: map[interface{}]interface{}{}}, failures: 2}
+	c := &Cache{MaxSize: 10, Store: store, WriteBehind: &WriteBehind{MaxRetries: 3, Backoff: time.Millisecond}}
+	c.Set("A", "a", time.Minute)
+	c.Set("B", "b", time.Minute)
+	c.Delete("B")
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok, _ := store.Get("A"); !ok || val != "a" {
+		t.Error("Queued write was not applied by Close")
+	}
+	if _, ok, _ := store.Get("B"); ok {
+		t.Error("Queued writes were applied out of order")
+	}
+	stats := c.Stats()
+	if stats.StoreWrites != 3 || stats.StoreRetries != 2 || stats.StoreErrors != 2 || stats.StoreDropped != 0 || stats.StoreQueueDepth != 0 {
+		t.Errorf("Unexpected write stats: %+v", stats)
+	}
+
+	store = &flakyStore{mapStore: mapStore{data: map[interface{}]interface{}{}}, failures: 1}
+	c = &Cache{MaxSize: 10, Store: store, WriteBehind: &WriteBehind{}}
+	c.Set("A", "a", time.Minute)
+	c.Close(context.Background())
+	if stats := c.Stats(); stats.StoreDropped != 1 || stats.StoreWrites != 0 {
+		t.Errorf("Failed write without retries was not dropped: %+v", stats)
+	}
+}
+
+func TestKeyStorage(t *testing.T) {
+	c := &Cache{MaxSize: 10, MaxStorage: 100, Sizer: func(key, value interface{}) uint64 {
+		return 1
+	}}
+	long := strings.Repeat("k", 60)
+	setCacheValue(t, c, long, 100*time.Second, "a")
+	if info, _ := c.Info(long); info.KeySize != 60 || c.Stats().Storage != 61 {
+		t.Fatalf("Key was not accounted: %+v", info)
+	}
+	setCacheValue(t, c, strings.Repeat("j", 60), 100*time.Second, "b")
+	setCacheValue(t, c, "c", 100*time.Second, "c")
+	if c.Size() != 2 {
+		t.Fatal("Key storage did not count toward MaxStorage")
+	}
+	expectConsistentCacheSize(t, c)
+}
+
+func TestMaxKeySize(t *testing.T) {
+	c := &Cache{MaxSize: 10, MaxKeySize: 8}
+	long := strings.Repeat("k", 9)
+	expectCacheValue(t, c, long, 100*time.Second, "a", "a", "Oversized key was not generated")
+	expectCacheValue(t, c, long, 100*time.Second, "b", "b", "Oversized key was cached")
+	c.Set(long, "c", 100*time.Second)
+	expectCacheValue(t, c, "short", 100*time.Second, "d", "d", "Short key was not generated")
+	if c.Size() != 1 || c.Stats().OversizedKeys != 3 {
+		t.Fatalf("Oversized keys were not counted: %+v", c.Stats())
+	}
+	vals, err := c.GetMulti([]interface{}{long, "other"}, 100*time.Second, func(keys []interface{}) (map[interface{}]interface{}, error) {
+		return map[interface{}]interface{}{long: "e", "other": "f"}, nil
+	})()
+	if err != nil || vals[long] != "e" || vals["other"] != "f" {
+		t.Fatalf("Unexpected batch result %v, %v", vals, err)
+	}
+	if count := c.Increment(long, 2, 100*time.Second); count != 2 {
+		t.Fatalf("Oversized counter returned %d", count)
+	}
+	c.Append(long, "g", 100*time.Second)
+	if _, ok := c.Peek(long); ok || c.Size() != 2 || c.Stats().OversizedKeys != 6 {
+		t.Fatalf("Oversized keys were cached outside Get and Set: %+v", c.Stats())
+	}
+}
+
+type bus struct {
+	mutex       sync.Mutex
+	subscribers map[*busInvalidator]func(key interface{})
+}
+
+type busInvalidator struct {
+	bus *bus
+}
+
+func (b *busInvalidator) Publish(key interface{}) error {
+	b.bus.mutex.Lock()
+	defer b.bus.mutex.Unlock()
+	for sub, fn := range b.bus.subscribers {
+		if sub != b {
+			fn(key)
+		}
+	}
+	return nil
+}
+
+func (b *busInvalidator) Subscribe(fn func(key interface{})) (func(), error) {
+	b.bus.mutex.Lock()
+	defer b.bus.mutex.Unlock()
+	b.bus.subscribers[b] = fn
+	return func() {
+		b.bus.mutex.Lock()
+		defer b.bus.mutex.Unlock()
+		delete(b.bus.subscribers, b)
+	}, nil
+}
+
+func TestInvalidator(t *testing.T) {
+	shared := &bus{subscribers: map[*busInvalidator]func(key interface{}){}}
+	a := &Cache{MaxSize: 10, Invalidator: &busInvalidator{shared}}
+	b := &Cache{MaxSize: 10, Invalidator: &busInvalidator{shared}}
+	for _, c := range []*Cache{a, b} {
+		if _, err := c.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		setCacheValue(t, c, "A", 100*time.Second, "a")
+		setCacheValue(t, c, "B", 100*time.Second, "b")
+	}
+	a.Delete("A")
+	if _, ok := b.GetIfPresent("A"); ok {
+		t.Fatal("Delete was not propagated")
+	}
+	b.Set("B", "new", 100*time.Second)
+	if _, ok := a.GetIfPresent("B"); ok {
+		t.Fatal("Set did not invalidate other caches")
+	}
+	if _, ok := b.GetIfPresent("B"); !ok {
+		t.Fatal("Cache invalidated its own Set")
+	}
+	b.Close(context.Background())
+	if len(shared.subscribers) != 1 {
+		t.Fatal("Close did not stop listening")
+	}
+}
+
+func TestInvalidatorScope(t *testing.T) {
+	shared := &bus{subscribers: map[*busInvalidator]func(key interface{}){}}
+	east := &Cache{MaxSize: 10, Scope: "east", Invalidator: &busInvalidator{shared}}
+	eastPeer := &Cache{MaxSize: 10, Scope: "east", Invalidator: &busInvalidator{shared}}
+	west := &Cache{MaxSize: 10, Scope: "west", Invalidator: &busInvalidator{shared}}
+	for _, c := range []*Cache{east, eastPeer, west} {
+		if _, err := c.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		setCacheValue(t, c, "A", 100*time.Second, "a")
+	}
+	east.Delete("A")
+	if _, ok := eastPeer.GetIfPresent("A"); ok {
+		t.Fatal("Delete was not propagated within its scope")
+	}
+	if _, ok := west.GetIfPresent("A"); !ok {
+		t.Fatal("Delete was propagated to another scope")
+	}
+}
+
+func TestRefreshAllWritesThrough(t *testing.T) {
+	shared := &bus{subscribers: map[*busInvalidator]func(key interface{}){}}
+	store := &mapStore{data: make(map[interface{}]interface{})}
+	c := &Cache{MaxSize: 10, Store: store, Invalidator: &busInvalidator{shared}}
+	peer := &Cache{MaxSize: 10, Invalidator: &busInvalidator{shared}}
+	if _, err := peer.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("A", "a", 100*time.Second)
+	c.Set("B", "b", 100*time.Second)
+	setCacheValue(t, peer, "A", 100*time.Second, "a")
+	c.mutex.Lock()
+	c.data["B"].refresh = &sync.WaitGroup{} // A refresh is already in flight
+	c.mutex.Unlock()
+	report := c.RefreshAll(nil, func(key interface{}) (interface{}, error) {
+		return "new", nil
+	}, RefreshOptions{})
+	if report.Refreshed != 1 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if val, _, _ := store.Get("A"); val != "new" {
+		t.Fatal("Refreshed value was not written to Store")
+	}
+	if _, ok := peer.GetIfPresent("A"); ok {
+		t.Fatal("Refreshed value was not invalidated in other caches")
+	}
+	if val, _ := c.Peek("B"); val != "b" {
+		t.Fatal("Entry being refreshed was regenerated again")
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	c := &Cache{MaxSize: 10, Codec: JSONCodec{}}
+	c.Set("A", map[string]interface{}{"n": 1.5}, 100*time.Second)
+	var buf bytes.Buffer
+	noError(t, c.SaveTo(&buf))
+	restored := &Cache{MaxSize: 10, Codec: JSONCodec{}}
+	noError(t, restored.LoadFrom(&buf))
+	if val, _ := restored.GetIfPresent("A"); val.(map[string]interface{})["n"] != 1.5 {
+		t.Fatalf("Unexpected restored value %v", val)
+	}
+
+	type point struct{ X, Y int }
+	codec := JSONCodec{New: func() interface{} { return new(point) }}
+	data, err := codec.Encode(point{1, 2})
+	noError(t, err)
+	if val, err := codec.Decode(data); err != nil || val != (point{1, 2}) {
+		t.Fatalf("Value was not decoded into New's type: %#v", val)
+	}
+
+	c = &Cache{MaxSize: 10, Codec: JSONCodec{}, Scope: "s"}
+	c.Set("A", "a", 100*time.Second)
+	buf.Reset()
+	noError(t, c.SaveTo(&buf))
+	restored = &Cache{MaxSize: 10, Codec: JSONCodec{}, Scope: "s"}
+	noError(t, restored.LoadFrom(&buf))
+	if val, _ := restored.GetIfPresent("A"); val != "a" {
+		t.Fatalf("Scoped snapshot was not restored: %v", val)
+	}
+	buf.Reset()
+	noError(t, c.ExportKeys(&buf))
+	warmed := &Cache{MaxSize: 10, Codec: JSONCodec{}, Scope: "s"}
+	noError(t, warmed.WarmFromKeys(context.Background(), &buf, func(key interface{}) (interface{}, error) { return key, nil }, 1))
+	if val, _ := warmed.GetIfPresent("A"); val != "A" {
+		t.Fatalf("Scoped keys were not warmed: %v", val)
+	}
+}
+
+func TestSetEviction(t *testing.T) {
+	c := &Cache{MaxSize: 3}
+	for _, key := range []string{"A", "B", "C"} {
+		c.Set(key, key, 100*time.Second)
+		time.Sleep(time.Millisecond)
+	}
+	c.GetIfPresent("A")
+	c.SetEviction(NewLRUPolicy())
+	c.Set("D", "D", 100*time.Second)
+	if _, ok := c.GetIfPresent("B"); ok || c.Size() != 3 {
+		t.Fatal("New policy did not evict the least recently used entry")
+	}
+	for _, key := range []string{"A", "C", "D"} {
+		if _, ok := c.GetIfPresent(key); !ok {
+			t.Fatalf("Entry %s was lost switching policies", key)
+		}
+	}
+	c.SetEviction(nil)
+	c.Set("E", "E", 100*time.Second)
+	if c.Size() != 3 {
+		t.Fatal("Reverting to sampling broke eviction")
+	}
+
+	c.SetRefresh(true)
+	c.SetExtendOnUse(true)
+	if !c.Refresh || !c.ExtendOnUse {
+		t.Fatal("Settings were not applied")
+	}
+}
+
+func TestRequestCache(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	r := NewRequestCache(c)
+	ctx := WithRequest(context.Background())
+	for i := 0; i < 3; i++ {
+		val, err := r.Get(ctx, "A", 100*time.Second, getGeneratorStub("a", nil))
+		noError(t, err)
+		if val != "a" {
+			t.Fatal("Unexpected value")
+		}
+	}
+	if stats := c.Stats(); stats.Misses != 1 || stats.Hits != 0 {
+		t.Fatalf("Repeated Gets within a request reached the shared cache: %+v", stats)
+	}
+	c.Set("A", "b", 100*time.Second)
+	if val, _ := r.Get(ctx, "A", 100*time.Second, getGeneratorStub("c", nil)); val != "a" {
+		t.Fatal("Request did not keep a consistent value")
+	}
+	if val, _ := r.Get(WithRequest(context.Background()), "A", 100*time.Second, getGeneratorStub("c", nil)); val != "b" {
+		t.Fatal("Memo leaked between requests")
+	}
+	if val, _ := r.Get(context.Background(), "A", 100*time.Second, getGeneratorStub("c", nil)); val != "b" || c.Stats().Hits != 2 {
+		t.Fatal("Get without a memo did not use the shared cache")
+	}
+}
+
+// halfCompressor "compresses" by keeping the first half of each value, doubling it on decompression.
+type halfCompressor struct{}
+
+func (halfCompressor) Compress(src []byte) []byte {
+	return append([]byte(nil), src[:len(src)/2]...)
+}
+
+func (halfCompressor) Decompress(src []byte) ([]byte, error) {
+	return append(append([]byte(nil), src...), src...), nil
+}
+
+func TestCompression(t *testing.T) {
+	var mutex sync.Mutex
+	var stored []interface{}
+	c := &Cache{MaxSize: 10, MaxStorage: 1000, Compression: &Compression{Compressor: halfCompressor{}, Threshold: 4}}
+	c.OnStore = func(key, value interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		stored = append(stored, value)
+	}
+	expectCacheValue(t, c, "A", 100*time.Second, "abab", "abab", "Compressed value was not returned intact")
+	if info, _ := c.Info("A"); info.Size != 2 {
+		t.Fatalf("Value was not accounted compressed: %d", info.Size)
+	}
+	c.Set("B", []byte("cdcd"), 100*time.Second)
+	if val, _ := c.GetIfPresent("B"); string(val.([]byte)) != "cdcd" {
+		t.Fatal("Set value was not decompressed")
+	}
+	c.Set("C", "ef", 100*time.Second)
+	if _, ok := c.data["C"].val.(*compressed); ok {
+		t.Fatal("Value under the threshold was compressed")
+	}
+	c.Patch("A", func(current interface{}) interface{} {
+		return current.(string) + "abab"
+	})
+	if val, _ := c.Peek("A"); val != "abababab" {
+		t.Fatalf("Patch did not see the original value: %v", val)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(stored) != 3 || stored[0] != "abab" {
+		t.Fatalf("Hooks saw compressed values: %v", stored)
+	}
+}
+
+func TestView(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	for i, key := range []string{"A", "B", "C"} {
+		setCacheValue(t, c, key, 100*time.Second, key)
+		for j := 0; j < i; j++ {
+			c.GetIfPresent(key)
+		}
+	}
+	v := c.View()
+	c.Set("A", "changed", 100*time.Second)
+	c.Delete("B")
+	if val, ok := v.Get("A"); !ok || val != "A" {
+		t.Fatal("View observed a later Set")
+	}
+	if _, ok := v.Get("B"); !ok || v.Len() != 3 {
+		t.Fatal("View observed a later Delete")
+	}
+	if top := v.TopKeys(2); len(top) != 2 || top[0] != "C" || top[1] != "B" {
+		t.Fatalf("Unexpected top keys %v", top)
+	}
+	if info, _ := v.Info("C"); info.Hits != 2 {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+	n := 0
+	v.Range(func(key, value interface{}, meta EntryInfo) bool {
+		n++
+		return true
+	})
+	if n != 3 {
+		t.Fatal("Range did not visit every entry")
+	}
+}
+
+func TestMergedOptions(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	release := make(chan struct{})
+	slow := func(interface{}) (interface{}, error) {
+		<-release
+		return "value", nil
+	}
+	short := c.GetResult("A", GetOptions{TTL: time.Minute, Cost: 1}, slow)
+	long := c.GetResult("A", GetOptions{TTL: time.Hour, Cost: 5}, slow)
+	middle := c.GetResult("A", GetOptions{TTL: 10 * time.Minute}, slow)
+	close(release)
+	for _, retrieve := range []func() Result{short, long, middle} {
+		if r := retrieve(); r.Err != nil || r.TTL != time.Hour {
+			t.Fatalf("Merged Get reported TTL %v, error %v", r.TTL, r.Err)
+		}
+	}
+	if info, ok := c.Info("A"); !ok || info.TTL != time.Hour {
+		t.Fatal("Longest TTL of concurrent Gets was not kept")
+	}
+
+	release = make(chan struct{})
+	cached := c.GetResult("B", GetOptions{TTL: time.Hour}, slow)
+	uncached := c.GetResult("B", GetOptions{}, slow)
+	close(release)
+	if r := cached(); r.Err != nil || r.TTL != 0 {
+		t.Fatal("Zero TTL of a joining Get was not merged")
+	}
+	if r := uncached(); r.Err != nil || r.Value != "value" {
+		t.Fatal("Joining Get did not receive the shared value")
+	}
+	if _, ok := c.GetIfPresent("B"); ok {
+		t.Fatal("Value was cached despite a Get asking for no caching")
+	}
+}
+
+func TestMergedCost(t *testing.T) {
+	c := &Cache{MaxSize: 10, MaxStorage: 100000}
+	started, release := make(chan struct{}), make(chan struct{})
+	first := c.GetWithOptions("A", GetOptions{TTL: time.Hour}, func(interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return "value", nil
+	})
+	<-started
+	c.GetWithOptions("A", GetOptions{TTL: time.Hour, Cost: 7}, getGeneratorStub("other", nil))
+	go close(release)
+	for i := 8; i < 1000; i++ { // Keep joining with larger costs while the generation finishes
+		c.GetWithOptions("A", GetOptions{TTL: time.Hour, Cost: uint64(i)}, getGeneratorStub("other", nil))
+	}
+	if val, err := first(); err != nil || val != "value" {
+		t.Fatalf("Unexpected result %v, %v", val, err)
+	}
+	if info, ok := c.Info("A"); !ok || info.Size < 7 {
+		t.Fatalf("Cost of a joining Get was not applied: %+v", info)
+	}
+	expectConsistentCacheSize(t, c)
+}
+
+func TestHedging(t *testing.T) {
+	c := &Cache{MaxSize: 10, HedgeDelay: 10 * time.Millisecond}
+	var calls int32
+	var abandoned int32
+	generate := func(ctx context.Context, key interface{}) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 { // The first call hangs until the hedge answers
+			<-ctx.Done()
+			atomic.AddInt32(&abandoned, 1)
+			return nil, ctx.Err()
+		}
+		return "hedged", nil
+	}
+	start := time.Now()
+	val, err := c.GetContext("A", time.Minute, generate)()
+	if err != nil || val != "hedged" {
+		t.Fatalf("Hedged Get returned %v, %v", val, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("Get waited on the slow call")
+	}
+	if stats := c.Stats(); stats.Hedges != 1 || stats.HedgeWins != 1 {
+		t.Fatal("Hedge was not recorded")
+	}
+	noError(t, c.Close(context.Background()))
+	if atomic.LoadInt32(&abandoned) != 1 {
+		t.Fatal("Losing call was not cancelled")
+	}
+
+	c = &Cache{MaxSize: 10, HedgeDelay: time.Minute}
+	atomic.StoreInt32(&calls, 0)
+	val, err = c.Get("B", time.Minute, func(interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "fast", nil
+	})()
+	noError(t, err)
+	if val != "fast" || atomic.LoadInt32(&calls) != 1 || c.Stats().Hedges != 0 {
+		t.Fatal("Fast generation was hedged")
+	}
+}
+
+func TestMinGenerationTime(t *testing.T) {
+	c := &Cache{MaxSize: 10, MinGenerationTime: 20 * time.Millisecond, NamespaceMinGenerationTime: map[string]time.Duration{"fast": 0}}
+	slow := func(interface{}) (interface{}, error) {
+		time.Sleep(30 * time.Millisecond)
+		return "slow", nil
+	}
+	setCacheValue(t, c, "A", time.Minute, "cheap")
+	if _, ok := c.GetIfPresent("A"); ok {
+		t.Fatal("Cheap value was cached")
+	}
+	val, err := c.Get("B", time.Minute, slow)()
+	noError(t, err)
+	if _, ok := c.GetIfPresent("B"); !ok || val != "slow" {
+		t.Fatal("Expensive value was not cached")
+	}
+	_, err = c.GetWithOptions("C", GetOptions{TTL: time.Minute, Namespace: "fast"}, getGeneratorStub("cheap", nil))()
+	noError(t, err)
+	if _, ok := c.GetIfPresent("C"); !ok {
+		t.Fatal("Namespace threshold was not applied")
+	}
+	if c.Stats().CheapValues != 1 {
+		t.Fatal("Cheap value was not counted")
+	}
+	expectConsistentCacheSize(t, c)
+}
+
+// tokenLimiter is a RateLimiter allowing a fixed number of calls.
+type tokenLimiter struct {
+	tokens int32
+}
+
+func (l *tokenLimiter) Allow() bool {
+	return atomic.AddInt32(&l.tokens, -1) >= 0
+}
+
+func TestRateLimit(t *testing.T) {
+	c := &Cache{MaxSize: 10, StaleOnError: time.Minute, RateLimit: &tokenLimiter{tokens: 2}}
+	setCacheValue(t, c, "A", 20*time.Millisecond, "first")
+	setCacheValue(t, c, "B", time.Minute, "second")
+	if _, err := c.Get("C", time.Minute, getGeneratorStub("third", nil))(); err != ErrThrottled {
+		t.Fatalf("Get past the rate limit returned %v", err)
+	}
+	if c.Stats().Throttled != 1 {
+		t.Fatal("Throttled call was not counted")
+	}
+	time.Sleep(30 * time.Millisecond)
+	expectCacheValue(t, c, "A", 20*time.Millisecond, "refreshed", "first", "Throttled regeneration did not serve the stale value")
+
+	c = &Cache{MaxSize: 10, KeyRateLimit: func(key interface{}) RateLimiter {
+		if key == "limited" {
+			return &tokenLimiter{}
+		}
+		return nil
+	}}
+	setCacheValue(t, c, "free", time.Minute, "value")
+	if _, err := c.Get("limited", time.Minute, getGeneratorStub("value", nil))(); err != ErrThrottled {
+		t.Fatal("Key limiter was not applied")
+	}
+	var requested []interface{}
+	vals, err := c.GetMulti([]interface{}{"limited", "other"}, time.Minute, func(keys []interface{}) (map[interface{}]interface{}, error) {
+		requested = keys
+		return map[interface{}]interface{}{"limited": "value", "other": "value"}, nil
+	})()
+	if err != ErrThrottled || len(vals) != 1 || len(requested) != 1 {
+		t.Fatalf("Key limiter was not applied to the batch (%v requested, %v)", requested, err)
+	}
+}
+
+func TestFollowPeers(t *testing.T) {
+	shared := &bus{subscribers: map[*busInvalidator]func(key interface{}){}}
+	a := &Cache{MaxSize: 10, Announcer: &busInvalidator{shared}}
+	b := &Cache{MaxSize: 10, Announcer: &busInvalidator{shared}, TrackOrigin: true}
+	if _, err := b.FollowPeers(time.Minute, 0, getGeneratorStub("prefetched", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var echoes int32
+	shared.subscribers[a.Announcer.(*busInvalidator)] = func(key interface{}) {
+		atomic.AddInt32(&echoes, 1)
+	}
+	expectCacheValue(t, a, "A", time.Minute, "value", "value", "Get failed")
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := b.GetIfPresent("A"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Announced key was not prefetched")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if info, _ := b.Info("A"); info.Source != SourcePrefetch || b.Stats().Prefetches != 1 {
+		t.Fatal("Prefetched entry was not recorded")
+	}
+	noError(t, b.Close(context.Background()))
+	if atomic.LoadInt32(&echoes) != 0 {
+		t.Fatal("Prefetch was announced back to its peer")
+	}
+}
+
+func TestFollowPeersScope(t *testing.T) {
+	shared := &bus{subscribers: map[*busInvalidator]func(key interface{}){}}
+	east := &Cache{MaxSize: 10, Scope: "east", Announcer: &busInvalidator{shared}}
+	eastPeer := &Cache{MaxSize: 10, Scope: "east", Announcer: &busInvalidator{shared}}
+	west := &Cache{MaxSize: 10, Scope: "west", Announcer: &busInvalidator{shared}}
+	for _, c := range []*Cache{eastPeer, west} {
+		if _, err := c.FollowPeers(time.Minute, 0, getGeneratorStub("prefetched", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectCacheValue(t, east, "A", time.Minute, "value", "value", "Get failed")
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := eastPeer.GetIfPresent("A"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Announced key was not prefetched in its scope")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := west.GetIfPresent("A"); ok || west.Stats().Prefetches != 0 {
+		t.Fatal("Announced key was prefetched under another scope")
+	}
+	noError(t, eastPeer.Close(context.Background()))
+	noError(t, west.Close(context.Background()))
+}
+
+func TestDeterministicEviction(t *testing.T) {
+	for run := 0; run < 5; run++ {
+		c := &Cache{MaxSize: 10, DeterministicEviction: true}
+		for i := 0; i < 20; i++ {
+			setCacheValue(t, c, fmt.Sprintf("%02d", i), time.Minute, "value")
+			if i == 12 {
+				setCacheValue(t, c, "05", time.Minute, "value") // Keep an old key in use
+			}
+		}
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("%02d", i)
+			if _, ok := c.GetIfPresent(key); ok != (i == 5 || i >= 11) {
+				t.Fatalf("Unexpected presence of %s after deterministic eviction", key)
+			}
+		}
+	}
+}
+
+func TestWarm(t *testing.T) {
+	c := &Cache{MaxSize: 100}
+	var running, peak int32
+	generate := func(key interface{}) (interface{}, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if key == 13 {
+			return nil, errors.New("Test Error")
+		}
+		return key, nil
+	}
+	keys := make([]interface{}, 50)
+	for i := range keys {
+		keys[i] = i
+	}
+	err := c.Warm(context.Background(), keys, time.Minute, generate, 4)
+	if errs, ok := err.(WarmErrors); !ok || len(errs) != 1 || errs[13] == nil {
+		t.Fatalf("Unexpected warm errors %v", err)
+	}
+	if atomic.LoadInt32(&peak) > 4 {
+		t.Fatal("Warm exceeded its concurrency")
+	}
+	if c.Size() != 49 {
+		t.Fatal("Warm did not populate the cache")
+	}
+}
+
+func TestLabels(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	_, err := c.GetWithOptions("A", GetOptions{TTL: time.Minute, Labels: map[string]string{"shard": "3"}}, getGeneratorStub("a", nil))()
+	noError(t, err)
+	c.SetWithLabels("B", "b", time.Minute, map[string]string{"schema": "v2"})
+	if info, _ := c.Info("A"); info.Labels["shard"] != "3" {
+		t.Fatal("Get did not attach labels")
+	}
+	if info, _ := c.Info("B"); info.Labels["schema"] != "v2" {
+		t.Fatal("SetWithLabels did not attach labels")
+	}
+	var buf bytes.Buffer
+	noError(t, c.Dump(&buf))
+	if !strings.Contains(buf.String(), "labels=map[schema:v2]") {
+		t.Fatalf("Dump did not include labels:\n%s", buf.String())
+	}
+	c.Set("B", "b", time.Minute)
+	if info, _ := c.Info("B"); info.Labels != nil {
+		t.Fatal("Labels survived replacement by Set")
+	}
+}
+
+func TestExportKeys(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	setCacheValue(t, c, "A", time.Minute, "a")
+	setCacheValue(t, c, "B", time.Hour, "b")
+	setCacheValue(t, c, "A", time.Minute, "a") // A is now the most recently used
+	var buf bytes.Buffer
+	noError(t, c.ExportKeys(&buf))
+
+	restored := &Cache{MaxSize: 10}
+	var m sync.Mutex
+	var order []interface{}
+	err := restored.WarmFromKeys(context.Background(), &buf, func(key interface{}) (interface{}, error) {
+		m.Lock()
+		defer m.Unlock()
+		order = append(order, key)
+		return "regenerated", nil
+	}, 1)
+	noError(t, err)
+	if len(order) != 2 || order[0] != "A" || order[1] != "B" {
+		t.Fatalf("Keys were not warmed most recently used first (%v)", order)
+	}
+	if info, _ := restored.Info("B"); info.TTL != time.Hour {
+		t.Fatal("Exported TTL was not restored")
+	}
+	if val, ok := restored.GetIfPresent("A"); !ok || val != "regenerated" {
+		t.Fatal("Exported key was not regenerated")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var m sync.Mutex
+	source := map[interface{}]interface{}{"A": "a", "B": "b"}
+	diverged := make(chan interface{}, 100)
+	c := &Cache{MaxSize: 10, Audit: &Audit{
+		Interval: time.Millisecond,
+		Generate: func(key interface{}) (interface{}, error) {
+			m.Lock()
+			defer m.Unlock()
+			return source[key], nil
+		},
+		OnDivergence: func(key, cached, fresh interface{}) {
+			diverged <- key
+		},
+	}}
+	defer c.Close(context.Background())
+	setCacheValue(t, c, "A", time.Minute, "a")
+	setCacheValue(t, c, "B", time.Minute, "b")
+	m.Lock()
+	source["A"] = "changed" // An update whose invalidation was missed
+	m.Unlock()
+	select {
+	case key := <-diverged:
+		if key != "A" {
+			t.Fatalf("Audit reported divergence of %v", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Divergence was not detected")
+	}
+	if stats := c.Stats(); stats.Audits == 0 || stats.AuditDivergences == 0 {
+		t.Fatal("Audits were not counted")
+	}
+	if val, _ := c.GetIfPresent("A"); val != "a" {
+		t.Fatal("Audit changed the cached value")
+	}
+}
+
+func TestZeroTTL(t *testing.T) {
+	c := &Cache{MaxSize: 10, TTLJitter: 0.5, StaleOnError: time.Minute}
+	setCacheValue(t, c, "A", NoExpiry, "a")
+	if info, _ := c.Info("A"); info.TTL != NoExpiry || info.Remaining != NoExpiry {
+		t.Fatalf("NoExpiry entry was not kept: %+v", info)
+	}
+	var buf bytes.Buffer
+	noError(t, c.SaveTo(&buf))
+	restored := &Cache{MaxSize: 10}
+	noError(t, restored.LoadFrom(&buf))
+	if info, _ := restored.Info("A"); info.TTL != NoExpiry || info.Remaining != NoExpiry {
+		t.Fatalf("NoExpiry entry was not restored without expiry: %+v", info)
+	}
+	setCacheValue(t, c, "B", 0, "b")
+	if _, ok := c.GetIfPresent("B"); ok {
+		t.Fatal("Zero TTL cached a value by default")
+	}
+
+	c = &Cache{MaxSize: 10, ZeroTTL: ZeroTTLForever}
+	setCacheValue(t, c, "A", 0, "a")
+	c.Set("B", "b", 0)
+	for _, key := range []string{"A", "B"} {
+		if info, ok := c.Info(key); !ok || info.TTL != NoExpiry {
+			t.Fatalf("Zero TTL did not cache %s forever", key)
+		}
+	}
+	expectCacheValue(t, c, "A", 0, "new", "a", "Entry cached forever was regenerated")
+}
+
+func TestSetMaxSize(t *testing.T) {
+	var evicted int32
+	c := &Cache{MaxSize: 10, MaxStorage: 1000, OnEvict: func(key, value interface{}) {
+		atomic.AddInt32(&evicted, 1)
+	}}
+	for i := 0; i < 10; i++ {
+		setCacheValue(t, c, fmt.Sprint(i), time.Minute, "0123456789")
+	}
+	if err := c.SetMaxSize(0); err == nil {
+		t.Fatal("SetMaxSize accepted zero")
+	}
+	noError(t, c.SetMaxSize(6))
+	if c.Size() != 6 || atomic.LoadInt32(&evicted) != 4 {
+		t.Fatal("Shrinking MaxSize did not evict entries at once")
+	}
+	storage := c.Stats().Storage
+	noError(t, c.SetMaxStorage(storage/2))
+	if c.Stats().Storage > storage/2 || c.Size() != 3 {
+		t.Fatal("Shrinking MaxStorage did not evict entries at once")
+	}
+	noError(t, c.SetMaxSize(20))
+	noError(t, c.SetMaxStorage(0))
+	for i := 0; i < 20; i++ {
+		setCacheValue(t, c, fmt.Sprint(i), time.Minute, "0123456789")
+	}
+	if c.Size() != 20 {
+		t.Fatal("Growing the limits did not make room")
+	}
+	expectConsistentCacheSize(t, c)
+	if err := (&Cache{MaxSize: 10}).SetMaxStorage(100); err == nil {
+		t.Fatal("SetMaxStorage accepted a cache that doesn't size values")
+	}
+}
+
+func TestMemoryPressure(t *testing.T) {
+	var heap uint64 = 1000
+	c := &Cache{MaxSize: 100, MaxStorage: 100000, MemoryPressure: &MemoryPressure{
+		HighWater: 10000,
+		Interval:  time.Millisecond,
+		Heap:      func() uint64 { return atomic.LoadUint64(&heap) },
+	}}
+	defer c.Close(context.Background())
+	for i := 0; i < 20; i++ {
+		setCacheValue(t, c, fmt.Sprint(i), time.Minute, strings.Repeat("x", 100))
+	}
+	storage := c.Stats().Storage
+	atomic.StoreUint64(&heap, 10000+storage/2) // The rest of the process grew past the watermark
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Stats().Storage > storage/2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Entries were not shed under memory pressure")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreUint64(&heap, 1000)
+	for stats := c.Stats(); stats.StorageLimit != stats.Storage+9000; stats = c.Stats() { // Room for the heap to reach HighWater
+		if time.Now().After(deadline) {
+			t.Fatal("Storage limit did not recover once pressure eased")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	expectConsistentCacheSize(t, c)
+}
+
+func TestPin(t *testing.T) {
+	for _, policy := range []EvictionPolicy{nil, NewLRUPolicy()} {
+		c := &Cache{MaxSize: 3, Eviction: policy}
+		_, err := c.GetWithOptions("config", GetOptions{TTL: time.Minute, Pinned: true}, getGeneratorStub("c", nil))()
+		noError(t, err)
+		c.Pin("other")
+		setCacheValue(t, c, "other", time.Minute, "o")
+		for i := 0; i < 20; i++ {
+			setCacheValue(t, c, fmt.Sprint(i), time.Minute, "value")
+		}
+		if _, ok := c.GetIfPresent("config"); !ok {
+			t.Fatal("Pinned entry was evicted")
+		}
+		if _, ok := c.GetIfPresent("other"); !ok {
+			t.Fatal("Entry pinned before it was cached was evicted")
+		}
+		if !c.Unpin("other") || c.Pinned("other") {
+			t.Fatal("Unpin did not unpin the key")
+		}
+		setCacheValue(t, c, "A", time.Minute, "a")
+		setCacheValue(t, c, "B", time.Minute, "b")
+		if _, ok := c.GetIfPresent("other"); ok {
+			t.Fatal("Unpinned entry was not evicted")
+		}
+		c.Pin("A")
+		c.Pin("B")
+		setCacheValue(t, c, "C", time.Minute, "c") // Every entry is pinned, so the cache overflows
+		if c.Size() != 4 {
+			t.Fatal("Pinned entries were evicted when nothing else could be")
+		}
+	}
+}
+
+func TestPriority(t *testing.T) {
+	for _, deterministic := range []bool{false, true} {
+		c := &Cache{MaxSize: 10, DeterministicEviction: deterministic}
+		for i := 0; i < 5; i++ {
+			_, err := c.GetWithOptions(fmt.Sprint("invoice", i), GetOptions{TTL: time.Minute, Priority: 1}, getGeneratorStub("invoice", nil))()
+			noError(t, err)
+		}
+		for i := 0; i < 20; i++ {
+			setCacheValue(t, c, fmt.Sprint("thumbnail", i), time.Minute, "thumbnail")
+		}
+		for i := 0; i < 5; i++ {
+			if _, ok := c.GetIfPresent(fmt.Sprint("invoice", i)); !ok {
+				t.Fatal("Higher priority entry was evicted before lower priority ones")
+			}
+		}
+		if !c.SetPriority("invoice0", -1) || c.SetPriority("missing", 1) {
+			t.Fatal("SetPriority did not report whether the key was found")
+		}
+		setCacheValue(t, c, "thumbnail", time.Minute, "thumbnail")
+		if _, ok := c.GetIfPresent("invoice0"); ok {
+			t.Fatal("Lowest priority entry was not evicted first")
+		}
+	}
+}
+
+func TestPinnedPriority(t *testing.T) {
+	for _, deterministic := range []bool{false, true} {
+		c := &Cache{MaxSize: 3, DeterministicEviction: deterministic}
+		_, err := c.GetWithOptions("config", GetOptions{TTL: time.Minute, Pinned: true, Priority: -1}, getGeneratorStub("config", nil))()
+		noError(t, err)
+		for i := 0; i < 50; i++ {
+			setCacheValue(t, c, fmt.Sprint("page", i), time.Minute, "page")
+		}
+		if c.Size() > 3 {
+			t.Fatalf("Pinned lowest priority entry stopped eviction, cache holds %d entries", c.Size())
+		}
+		if _, ok := c.GetIfPresent("config"); !ok {
+			t.Fatal("Pinned entry was evicted")
+		}
+	}
+}
+
+func TestPriorityCounts(t *testing.T) {
+	c := &Cache{MaxSize: 10}
+	release := make(chan struct{})
+	pending := c.GetWithOptions("pending", GetOptions{TTL: time.Minute, Priority: 2}, func(interface{}) (interface{}, error) {
+		<-release
+		return "pending", nil
+	})
+	_, err := c.GetWithOptions("A", GetOptions{TTL: time.Minute, Priority: 1}, getGeneratorStub("a", nil))()
+	noError(t, err)
+	setCacheValue(t, c, "B", time.Minute, "b")
+	c.Pin("B")
+	if len(c.priorities) != 1 || c.priorities[1] != 1 {
+		t.Fatalf("Pending or pinned entries were counted as evictable: %v", c.priorities)
+	}
+	close(release)
+	noError(t, func() error { _, err := pending(); return err }())
+	c.Unpin("B")
+	c.SetPriority("A", 2)
+	c.Delete("pending")
+	if len(c.priorities) != 2 || c.priorities[0] != 1 || c.priorities[2] != 1 {
+		t.Fatalf("Evictable entries were miscounted: %v", c.priorities)
+	}
+}
+
+func TestPurgeQueue(t *testing.T) {
+	c := &Cache{MaxSize: 100, ExtendOnUse: true}
+	for i := 0; i < 10; i++ {
+		setCacheValue(t, c, fmt.Sprint(i), time.Duration(i+1)*40*time.Millisecond, "v")
+	}
+	setCacheValue(t, c, "forever", NoExpiry, "v")
+	if len(c.expiries) != 10 {
+		t.Fatalf("Expected 10 entries queued for expiry, found %d", len(c.expiries))
+	}
+	time.Sleep(60 * time.Millisecond)
+	c.GetIfPresent("1") // Extends the entry's deadline past its queued one
+	c.Touch("9", time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	c.PurgeCount(1)
+	if _, ok := c.Peek("0"); ok {
+		t.Fatal("Soonest expired entry was not purged")
+	}
+	c.Purge()
+	for key, expected := range map[string]bool{"1": true, "2": true, "3": true, "9": false, "forever": true} {
+		if _, ok := c.Peek(key); ok != expected {
+			t.Fatalf("Expected presence of %s to be %v after purging", key, expected)
+		}
+	}
+	if len(c.expiries) != c.Size()-1 {
+		t.Fatalf("Expiry queue holds %d entries for %d expiring entries", len(c.expiries), c.Size()-1)
+	}
+}
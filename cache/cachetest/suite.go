@@ -0,0 +1,201 @@
+/*
+Package cachetest is a conformance suite for the guarantees every flowcache configuration must keep,
+whatever its eviction policy, admission policy, store or other settings:
+
+  - Coalescing: concurrent Gets of a missing key share a single generator call.
+  - Persistence: a Get within the TTL returns the cached value without calling its generator.
+  - Expired refetch: a Get after the TTL has passed calls its generator for a new value.
+  - Refresh promotion: under Refresh, a Get that finds its entry expired while a refresh is in flight
+    waits for that refresh rather than starting another generation.
+  - Error propagation: a generator's error reaches every caller waiting on it.
+  - Capacity: a cache with MaxSize never holds more entries than that.
+  - Veto: an entry vetoed by OnEvictCandidate survives eviction while other entries can be evicted instead.
+
+Alternative policies and stores should pass Run with a factory configuring them:
+
+	func TestConformance(t *testing.T) {
+		cachetest.Run(t, func() *cache.Cache {
+			return &cache.Cache{MaxSize: 100, Eviction: cache.NewLFUPolicy()}
+		})
+	}
+*/
+package cachetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+	"github.com/ericpauley/flowcache/cache/clocktest"
+)
+
+// Factory creates an empty cache for one conformance test. The suite replaces its Clock with a fake one,
+// and sets Refresh for the tests that need it, so MaxSize should allow at least 10 entries and any
+// refresh timing settings should refresh by 90% of the TTL.
+type Factory func() *cache.Cache
+
+// Run runs every conformance test against caches created by newCache.
+func Run(t *testing.T, newCache Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, c *cache.Cache, clock *clocktest.Clock)
+	}{
+		{"Coalescing", testCoalescing},
+		{"Persistence", testPersistence},
+		{"ExpiredRefetch", testExpiredRefetch},
+		{"RefreshPromotion", testRefreshPromotion},
+		{"ErrorPropagation", testErrorPropagation},
+		{"Capacity", testCapacity},
+		{"Veto", testVeto},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			clock := clocktest.New(time.Now())
+			c := newCache()
+			c.Clock = clock
+			test.fn(t, c, clock)
+		})
+	}
+}
+
+const ttl = 10 * time.Second
+
+// generator returns a generator counting its calls, which returns val once release is closed.
+func generator(val interface{}, err error, release <-chan struct{}, calls *int, mutex *sync.Mutex) func(interface{}) (interface{}, error) {
+	return func(interface{}) (interface{}, error) {
+		mutex.Lock()
+		*calls++
+		mutex.Unlock()
+		if release != nil {
+			<-release
+		}
+		return val, err
+	}
+}
+
+func expect(t *testing.T, c *cache.Cache, key interface{}, generate func(interface{}) (interface{}, error), expected interface{}, message string) {
+	t.Helper()
+	val, err := c.Get(key, ttl, generate)()
+	if err != nil {
+		t.Fatalf("%s: %v", message, err)
+	}
+	if val != expected {
+		t.Fatalf("%s (%v != %v)", message, val, expected)
+	}
+}
+
+func testCoalescing(t *testing.T, c *cache.Cache, clock *clocktest.Clock) {
+	var mutex sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	generate := generator("a", nil, release, &calls, &mutex)
+	var retrievals []func() (interface{}, error)
+	for i := 0; i < 10; i++ {
+		retrievals = append(retrievals, c.Get("key", ttl, generate))
+	}
+	close(release)
+	for _, retrieve := range retrievals {
+		if val, err := retrieve(); val != "a" || err != nil {
+			t.Fatalf("Unexpected result %v, %v", val, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("Generator called %d times for concurrent Gets", calls)
+	}
+}
+
+func testPersistence(t *testing.T, c *cache.Cache, clock *clocktest.Clock) {
+	var mutex sync.Mutex
+	calls := 0
+	expect(t, c, "key", generator("a", nil, nil, &calls, &mutex), "a", "Generator was not called")
+	clock.Advance(ttl / 4)
+	expect(t, c, "key", generator("b", nil, nil, &calls, &mutex), "a", "Value was not kept")
+	if calls != 1 {
+		t.Fatalf("Generator called %d times within the TTL", calls)
+	}
+}
+
+func testExpiredRefetch(t *testing.T, c *cache.Cache, clock *clocktest.Clock) {
+	var mutex sync.Mutex
+	calls := 0
+	expect(t, c, "key", generator("a", nil, nil, &calls, &mutex), "a", "Generator was not called")
+	clock.Advance(ttl + time.Second)
+	expect(t, c, "key", generator("b", nil, nil, &calls, &mutex), "b", "Expired value was served")
+}
+
+func testRefreshPromotion(t *testing.T, c *cache.Cache, clock *clocktest.Clock) {
+	c.Refresh = true
+	var mutex sync.Mutex
+	calls := 0
+	expect(t, c, "key", generator("a", nil, nil, &calls, &mutex), "a", "Generator was not called")
+	clock.Advance(ttl * 9 / 10)
+	release := make(chan struct{})
+	expect(t, c, "key", generator("b", nil, release, &calls, &mutex), "a", "Current value was not served while refreshing")
+	clock.Advance(ttl)
+	retrieve := c.Get("key", ttl, generator("c", nil, nil, &calls, &mutex))
+	close(release)
+	if val, err := retrieve(); val != "b" || err != nil {
+		t.Fatalf("Expired Get did not wait for the refresh: %v, %v", val, err)
+	}
+	if calls != 2 {
+		t.Fatalf("Generator called %d times, expected the refresh to be promoted", calls)
+	}
+}
+
+func testErrorPropagation(t *testing.T, c *cache.Cache, clock *clocktest.Clock) {
+	var mutex sync.Mutex
+	calls := 0
+	failure := errors.New("Generator failed")
+	release := make(chan struct{})
+	generate := generator(nil, failure, release, &calls, &mutex)
+	first, second := c.Get("key", ttl, generate), c.Get("key", ttl, generate)
+	close(release)
+	for _, retrieve := range []func() (interface{}, error){first, second} {
+		if _, err := retrieve(); err != failure {
+			t.Fatalf("Expected the generator's error, got %v", err)
+		}
+	}
+}
+
+func testCapacity(t *testing.T, c *cache.Cache, clock *clocktest.Clock) {
+	if c.MaxSize <= 0 {
+		t.Skip("MaxSize is not set")
+	}
+	for i := 0; i < c.MaxSize+10; i++ {
+		val := fmt.Sprint(i)
+		if _, err := c.Get(i, ttl, func(interface{}) (interface{}, error) { return val, nil })(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size := c.Size(); size > c.MaxSize {
+		t.Fatalf("Cache holds %d entries, above MaxSize %d", size, c.MaxSize)
+	}
+}
+
+func testVeto(t *testing.T, c *cache.Cache, clock *clocktest.Clock) {
+	if c.MaxSize <= 0 {
+		t.Skip("MaxSize is not set")
+	}
+	c.OnEvictCandidate = func(key interface{}, meta cache.EntryInfo) cache.EvictDecision {
+		if key == 0 {
+			return cache.EvictVeto
+		}
+		return cache.EvictApprove
+	}
+	for i := 0; i < c.MaxSize+10; i++ {
+		val := fmt.Sprint(i)
+		if _, err := c.Get(i, ttl, func(interface{}) (interface{}, error) { return val, nil })(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if val, ok := c.Peek(0); !ok || val != "0" {
+		t.Fatal("Vetoed entry was evicted")
+	}
+	if size := c.Size(); size > c.MaxSize {
+		t.Fatalf("Cache holds %d entries, above MaxSize %d", size, c.MaxSize)
+	}
+}
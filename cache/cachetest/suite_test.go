@@ -0,0 +1,27 @@
+package cachetest
+
+import (
+	"testing"
+
+	"github.com/ericpauley/flowcache/cache"
+)
+
+func TestDefault(t *testing.T) {
+	Run(t, func() *cache.Cache { return &cache.Cache{MaxSize: 10} })
+}
+
+func TestPolicies(t *testing.T) {
+	policies := map[string]func() cache.EvictionPolicy{
+		"LRU":   cache.NewLRUPolicy,
+		"FIFO":  cache.NewFIFOPolicy,
+		"LFU":   cache.NewLFUPolicy,
+		"SIEVE": cache.NewSIEVEPolicy,
+		"CLOCK": func() cache.EvictionPolicy { return cache.NewClockPolicy(8) },
+	}
+	for name, policy := range policies {
+		policy := policy
+		t.Run(name, func(t *testing.T) {
+			Run(t, func() *cache.Cache { return &cache.Cache{MaxSize: 10, Eviction: policy()} })
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"math"
+	"math/bits"
+)
+
+// cardinalityPrecision sets 2^14 registers, for a standard error of about 0.8% in 16KB.
+const cardinalityPrecision = 14
+
+// hyperLogLog estimates the number of distinct hashes added to it.
+type hyperLogLog struct {
+	registers [1 << cardinalityPrecision]uint8
+}
+
+// add records a hashed key.
+func (h *hyperLogLog) add(hash uint64) {
+	hash = mix(hash)
+	i := hash >> (64 - cardinalityPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<cardinalityPrecision|1<<(cardinalityPrecision-1)) + 1)
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// estimate returns the estimated number of distinct hashes added.
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros)) // Linear counting is more accurate for small counts
+	}
+	return uint64(e + 0.5)
+}
+
+// mix spreads a key hash's entropy across all of its bits, as the register index and rank both need.
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// KeyCardinality estimates how many distinct keys have been requested with Get since the cache was created,
+// including keys that were never stored. It returns zero unless TrackCardinality is set.
+// Comparing it with Size shows how much of the requested key space the cache holds.
+func (c *Cache) KeyCardinality() uint64 {
+	c.mutex.Lock()
+	defer c.unlock()
+	if c.cardinality == nil {
+		return 0
+	}
+	return c.cardinality.estimate()
+}
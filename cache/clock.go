@@ -0,0 +1,52 @@
+package cache
+
+import "time"
+
+// Clock is the cache's source of time. Setting Cache.Clock to a fake, such as the one in the clocktest
+// package, lets expiry, refresh and timeouts be tested without real sleeps.
+type Clock interface {
+	Now() time.Time
+	// NewTimer creates a Timer firing once d has passed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single-shot timer created by a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel the current time is sent on when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, returning false if it already fired or was stopped.
+	Stop() bool
+}
+
+// now returns the current time according to Clock.
+func (c *Cache) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock.Now()
+	}
+	return time.Now()
+}
+
+// since returns the time elapsed since t according to Clock.
+func (c *Cache) since(t time.Time) time.Duration {
+	return c.now().Sub(t)
+}
+
+// after returns a channel that receives once d has passed according to Clock, and a function releasing the timer.
+func (c *Cache) after(d time.Duration) (<-chan time.Time, func()) {
+	if c.Clock != nil {
+		t := c.Clock.NewTimer(d)
+		return t.C(), func() { t.Stop() }
+	}
+	t := acquireTimer(d)
+	return t.C, func() { releaseTimer(t) }
+}
+
+// sleep blocks for d according to Clock.
+func (c *Cache) sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	wait, release := c.after(d)
+	<-wait
+	release()
+}
@@ -0,0 +1,95 @@
+/*
+Package clocktest provides a fake cache.Clock whose time only moves when told to, so that expiry,
+refresh and timeouts can be tested deterministically:
+
+	clock := clocktest.New(time.Now())
+	c := &cache.Cache{MaxSize: 100, Clock: clock}
+	c.Set("key", "value", time.Minute)
+	clock.Advance(2 * time.Minute) // "key" has now expired
+*/
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+)
+
+// Clock is a fake cache.Clock. It is safe for concurrent use.
+type Clock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+// New creates a Clock reading now.
+func New(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// NewTimer creates a timer firing once the clock has been advanced by d.
+func (c *Clock) NewTimer(d time.Duration) cache.Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &timer{clock: c, when: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing any timers that fall due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			t.c <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+// Timers returns the number of timers that have neither fired nor been stopped,
+// letting tests wait until the code under test is blocked on the clock.
+func (c *Clock) Timers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+type timer struct {
+	clock *Clock
+	when  time.Time
+	c     chan time.Time
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *timer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	for i, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package clocktest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+)
+
+func TestExpiry(t *testing.T) {
+	clock := New(time.Now())
+	c := &cache.Cache{MaxSize: 10, Clock: clock}
+	c.Set("A", "a", time.Minute)
+	clock.Advance(59 * time.Second)
+	if _, ok := c.GetIfPresent("A"); !ok {
+		t.Fatal("Entry expired early")
+	}
+	clock.Advance(2 * time.Second)
+	if _, ok := c.GetIfPresent("A"); ok {
+		t.Fatal("Entry did not expire")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	clock := New(time.Now())
+	c := &cache.Cache{MaxSize: 10, Clock: clock}
+	release := make(chan struct{})
+	defer close(release)
+	got := c.GetWithOptions("A", cache.GetOptions{TTL: time.Minute, Timeout: time.Second}, func(key interface{}) (interface{}, error) {
+		<-release
+		return "a", nil
+	})
+	errs := make(chan error)
+	go func() {
+		_, err := got()
+		errs <- err
+	}()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	if err := <-errs; err != cache.ErrTimeout {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+// awaitTimers waits until n timers are pending on clock.
+func awaitTimers(clock *Clock, n int) {
+	for clock.Timers() != n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPurgeInterval(t *testing.T) {
+	clock := New(time.Now())
+	c := &cache.Cache{MaxSize: 10, Clock: clock, PurgeInterval: time.Minute}
+	c.Set("A", "a", 30*time.Second)
+	awaitTimers(clock, 1)
+	clock.Advance(59 * time.Second)
+	if stats := c.Stats(); stats.Expirations != 0 {
+		t.Fatal("Purged before PurgeInterval elapsed")
+	}
+	clock.Advance(time.Second)
+	awaitTimers(clock, 0)
+	awaitTimers(clock, 1) // The next tick is scheduled after the purge
+	if stats := c.Stats(); stats.Expirations != 1 {
+		t.Fatalf("Expected the janitor to purge one entry, got %d", stats.Expirations)
+	}
+}
+
+type failingStore struct {
+	mutex    sync.Mutex
+	failures int
+	data     map[interface{}]interface{}
+}
+
+func (s *failingStore) Get(key interface{}) (interface{}, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	val, ok := s.data[key]
+	return val, ok, nil
+}
+
+func (s *failingStore) Set(key, value interface{}, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("Store unavailable")
+	}
+	s.data[key] = value
+	return nil
+}
+
+func (s *failingStore) Delete(key interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func TestWriteBehindBackoff(t *testing.T) {
+	clock := New(time.Now())
+	store := &failingStore{failures: 1, data: map[interface{}]interface{}{}}
+	c := &cache.Cache{MaxSize: 10, Clock: clock, Store: store, WriteBehind: &cache.WriteBehind{MaxRetries: 1, Backoff: time.Minute}}
+	c.Set("A", "a", time.Hour)
+	awaitTimers(clock, 1)
+	if _, ok, _ := store.Get("A"); ok {
+		t.Fatal("Write retried before the backoff elapsed")
+	}
+	clock.Advance(time.Minute)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok, _ := store.Get("A"); !ok || val != "a" {
+		t.Error("Write was not retried after the backoff")
+	}
+}
+
+// generator returns a generator producing val, or failing with err.
+func generator(val interface{}, err error) func(interface{}) (interface{}, error) {
+	return func(interface{}) (interface{}, error) {
+		return val, err
+	}
+}
+
+// expectValue gets key, generating val if needed, and fails unless expected is returned.
+func expectValue(t *testing.T, c *cache.Cache, key interface{}, ttl time.Duration, val, expected interface{}, msg string) {
+	t.Helper()
+	got, err := c.Get(key, ttl, generator(val, nil))()
+	if err != nil || got != expected {
+		t.Fatalf("%s: got %v, %v", msg, got, err)
+	}
+}
+
+func TestOnHitInspect(t *testing.T) {
+	clock := New(time.Now())
+	c := &cache.Cache{MaxSize: 2, Clock: clock, OnHitInspect: func(key, value interface{}, meta cache.EntryInfo) (time.Duration, bool) {
+		if value == "expired" {
+			return 0, false
+		}
+		return 10 * time.Second, true
+	}}
+	expectValue(t, c, "A", time.Minute, "A", "A", "Entry was not generated")
+	expectValue(t, c, "A", time.Minute, "B", "A", "Inspected value was not returned")
+	clock.Advance(11 * time.Second)
+	expectValue(t, c, "A", time.Minute, "B", "B", "Inspector did not shorten entry TTL")
+	expectValue(t, c, "C", time.Minute, "expired", "expired", "Entry was not generated")
+	expectValue(t, c, "C", time.Minute, "D", "D", "Inspector did not force regeneration")
+}
+
+func TestHooks(t *testing.T) {
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) func(interface{}) {
+		return func(key interface{}) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			events = append(events, event+" "+key.(string))
+		}
+	}
+	clock := New(time.Now())
+	ended := make(chan struct{})
+	c := &cache.Cache{MaxSize: 10, Clock: clock, Refresh: true}
+	c.OnHit = record("hit")
+	c.OnMiss = record("miss")
+	c.OnRefreshStart = record("start")
+	c.OnRefreshEnd = func(key interface{}, err error) {
+		record("end")(key)
+		c.Size() // Hooks run outside the lock
+		close(ended)
+	}
+	expectValue(t, c, "A", 20*time.Second, "a", "a", "Entry was not generated")
+	clock.Advance(15 * time.Second)
+	expectValue(t, c, "A", 20*time.Second, "a", "a", "Entry was not served")
+	<-ended
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []string{"miss A", "hit A", "start A", "end A"}
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected events %v", events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("Unexpected events %v", events)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// JSONCodec is a Codec encoding values as JSON, for snapshots and stores that other languages must read.
+//
+// JSON carries no type information, so without New values decode as the generic types of encoding/json:
+// numbers as float64, objects as map[string]interface{} and arrays as []interface{}. A ScopedKey is
+// encoded as an object tagged "$scopedKey" and decodes as a ScopedKey, its Key decoded as any other value.
+type JSONCodec struct {
+	// New, if set, returns a pointer to a fresh value to decode into, such as func() interface{} { return new(User) }.
+	// Decode then returns the value it points to, of the type that was encoded. Snapshots encode keys with the
+	// same Codec, so a JSONCodec with New can only be used for them if keys and values share a type.
+	New func() interface{}
+}
+
+// jsonScopedKey is the form in which JSONCodec encodes a ScopedKey, distinguishing it from other objects.
+type jsonScopedKey struct {
+	ScopedKey *jsonScope `json:"$scopedKey"`
+}
+
+type jsonScope struct {
+	Scope string
+	Key   json.RawMessage
+}
+
+// jsonScopedPrefix begins every encoded jsonScopedKey.
+var jsonScopedPrefix = []byte(`{"$scopedKey":`)
+
+// Encode encodes v as JSON.
+func (j JSONCodec) Encode(v interface{}) ([]byte, error) {
+	if scopedKey, ok := v.(ScopedKey); ok {
+		key, err := j.Encode(scopedKey.Key)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(jsonScopedKey{&jsonScope{scopedKey.Scope, key}})
+	}
+	return json.Marshal(v)
+}
+
+// Decode decodes a value written by Encode.
+func (j JSONCodec) Decode(data []byte) (interface{}, error) {
+	if bytes.HasPrefix(data, jsonScopedPrefix) {
+		var wrapped jsonScopedKey
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, err
+		}
+		if wrapped.ScopedKey != nil {
+			key, err := j.Decode(wrapped.ScopedKey.Key)
+			if err != nil {
+				return nil, err
+			}
+			return ScopedKey{wrapped.ScopedKey.Scope, key}, nil
+		}
+	}
+	if j.New == nil {
+		var v interface{}
+		err := json.Unmarshal(data, &v)
+		return v, err
+	}
+	ptr := j.New()
+	if err := json.Unmarshal(data, ptr); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(ptr).Elem().Interface(), nil
+}
@@ -0,0 +1,34 @@
+package cache
+
+// minCompactPeak keeps small maps from being rebuilt, where the memory returned isn't worth the copy.
+const minCompactPeak = 64
+
+// Compact rebuilds the cache's internal maps, returning the bucket memory left behind by removed entries to the heap.
+func (c *Cache) Compact() {
+	c.lockMap()
+	defer c.unlock()
+	c.compact()
+}
+
+// maybeCompact rebuilds the internal maps once they have shrunk below CompactRatio of their peak.
+func (c *Cache) maybeCompact() {
+	if c.CompactRatio > 0 && c.peak >= minCompactPeak && float64(len(c.data)) < float64(c.peak)*c.CompactRatio {
+		c.compact()
+	}
+}
+
+func (c *Cache) compact() {
+	data := make(map[interface{}]*cacheItem, len(c.data))
+	for key, item := range c.data {
+		data[key] = item
+	}
+	c.data = data
+	c.peak = len(data)
+	if c.secondary != nil {
+		secondary := make(map[interface{}]interface{}, len(c.secondary))
+		for k, v := range c.secondary {
+			secondary[k] = v
+		}
+		c.secondary = secondary
+	}
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+
+	"github.com/ericpauley/go-utils/memory"
+)
+
+// TypeUsage is the share of a cache's entries and storage held by values of one Go type, as estimated by Composition.
+type TypeUsage struct {
+	Type    string // The value's dynamic type, such as "*main.Report"
+	Samples int    // Entries of this type that were sampled
+	Entries int    // Estimated entries of this type
+	Storage uint64 // Estimated storage used by values of this type
+}
+
+// Composition samples about fraction of the completed, unexpired and successful entries and estimates
+// how many entries and how much storage each value type accounts for, largest storage first.
+// A fraction outside (0, 1] samples every entry.
+//
+// Sizes are those accounted under MaxStorage when it is set, and are otherwise measured with Sizer or
+// memory.Sizeof after the lock is released, so sampling a small fraction keeps the cost down on large caches.
+func (c *Cache) Composition(fraction float64) []TypeUsage {
+	if fraction <= 0 || fraction > 1 {
+		fraction = 1
+	}
+	type sample struct {
+		key, val interface{}
+		size     uint64
+	}
+	var samples []sample
+	c.lockMap()
+	for key, item := range c.data {
+		if c.visible(item) && (fraction == 1 || rand.Float64() < fraction) {
+			samples = append(samples, sample{key, item.val, item.size})
+		}
+	}
+	measured := c.MaxStorage > 0
+	c.unlock()
+	usage := make(map[string]*TypeUsage)
+	for _, s := range samples {
+		name := "<nil>"
+		if v, ok := s.val.(*compressed); ok {
+			name = v.typeName()
+		} else if s.val != nil {
+			name = reflect.TypeOf(s.val).String()
+		}
+		u, ok := usage[name]
+		if !ok {
+			u = &TypeUsage{Type: name}
+			usage[name] = u
+		}
+		if !measured {
+			s.size = c.sampleSize(s.key, s.val)
+		}
+		u.Samples++
+		u.Storage += s.size
+	}
+	result := make([]TypeUsage, 0, len(usage))
+	for _, u := range usage {
+		u.Entries = int(float64(u.Samples)/fraction + 0.5)
+		u.Storage = uint64(float64(u.Storage) / fraction)
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Storage != result[j].Storage {
+			return result[i].Storage > result[j].Storage
+		}
+		return result[i].Type < result[j].Type
+	})
+	return result
+}
+
+// sampleSize measures a value for Composition when MaxStorage isn't accounting sizes.
+func (c *Cache) sampleSize(key, val interface{}) (size uint64) {
+	if val == nil {
+		return 0
+	}
+	if v, ok := val.(*compressed); ok {
+		return uint64(len(v.data))
+	}
+	defer func() {
+		if recover() != nil {
+			size = 0
+		}
+	}()
+	if c.Sizer != nil {
+		return c.Sizer(key, val)
+	}
+	return memory.Sizeof(val)
+}
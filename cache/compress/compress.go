@@ -0,0 +1,57 @@
+/*
+Package compress provides cache.Compressor implementations for cache.Compression:
+
+	c := &cache.Cache{MaxSize: 1000, MaxStorage: 64 << 20,
+		Compression: &cache.Compression{Compressor: compress.Snappy{}, Threshold: 1024}}
+
+Snappy is fast enough to compress every large value with little CPU cost; Zstd compresses markedly better,
+such as for HTML or JSON, at several times the cost.
+*/
+package compress
+
+import (
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Snappy compresses values in the Snappy format.
+type Snappy struct{}
+
+// Compress implements cache.Compressor.
+func (Snappy) Compress(src []byte) []byte {
+	return s2.EncodeSnappy(nil, src)
+}
+
+// Decompress implements cache.Compressor.
+func (Snappy) Decompress(src []byte) ([]byte, error) {
+	return s2.Decode(nil, src)
+}
+
+// Zstd compresses values in the Zstandard format. It is safe for concurrent use.
+type Zstd struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewZstd creates a Zstd compressor at the given level.
+func NewZstd(level zstd.EncoderLevel) (*Zstd, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Zstd{encoder: encoder, decoder: decoder}, nil
+}
+
+// Compress implements cache.Compressor.
+func (z *Zstd) Compress(src []byte) []byte {
+	return z.encoder.EncodeAll(src, nil)
+}
+
+// Decompress implements cache.Compressor.
+func (z *Zstd) Decompress(src []byte) ([]byte, error) {
+	return z.decoder.DecodeAll(src, nil)
+}
@@ -0,0 +1,33 @@
+package compress
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressors(t *testing.T) {
+	z, err := NewZstd(zstd.SpeedDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := strings.Repeat("<div class=\"fragment\">hello</div>", 100)
+	for name, compressor := range map[string]cache.Compressor{"snappy": Snappy{}, "zstd": z} {
+		c := &cache.Cache{MaxSize: 10, MaxStorage: 1 << 20, Compression: &cache.Compression{Compressor: compressor, Threshold: 100}}
+		val, err := c.Get("A", time.Minute, func(interface{}) (interface{}, error) {
+			return html, nil
+		})()
+		if err != nil || val != html {
+			t.Fatalf("%s: generated value was not returned intact", name)
+		}
+		if val, _ := c.GetIfPresent("A"); val != html {
+			t.Fatalf("%s: cached value was not decompressed", name)
+		}
+		if storage := c.Stats().Storage; storage >= uint64(len(html)) {
+			t.Fatalf("%s: value was not stored compressed (%d bytes)", name, storage)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package cache
+
+// Compressor compresses cached values for Compression.
+type Compressor interface {
+	Compress(src []byte) []byte
+	Decompress(src []byte) ([]byte, error)
+}
+
+// Compression keeps large string and []byte values compressed while they are cached, trading the CPU spent
+// decompressing them on every read for fitting more entries under MaxStorage. Values of other types, and values
+// that don't shrink, are cached as they are. The compress subpackage provides zstd and snappy Compressors.
+//
+// Values are compressed as they enter the cache, are accounted under MaxStorage at their compressed size, and are
+// decompressed whenever they leave it, so callers, hooks and Store only ever see the original values.
+type Compression struct {
+	Compressor Compressor
+	Threshold  int // Values shorter than this many bytes are not compressed
+}
+
+// compressed is a value held compressed under Compression.
+type compressed struct {
+	data []byte
+	text bool // The value was a string rather than a []byte
+}
+
+// typeName names the type of the original value, as reflect would.
+func (v *compressed) typeName() string {
+	if v.text {
+		return "string"
+	}
+	return "[]uint8"
+}
+
+// compress returns the form in which val should be cached.
+func (c *Cache) compress(val interface{}) interface{} {
+	if c.Compression == nil {
+		return val
+	}
+	var raw []byte
+	text := false
+	switch v := val.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw, text = []byte(v), true
+	default:
+		return val
+	}
+	if len(raw) < c.Compression.Threshold {
+		return val
+	}
+	data := c.Compression.Compressor.Compress(raw)
+	if len(data) >= len(raw) {
+		return val
+	}
+	return &compressed{data: data, text: text}
+}
+
+// expand returns the original form of a cached value.
+func (c *Cache) expand(val interface{}) (interface{}, error) {
+	v, ok := val.(*compressed)
+	if !ok {
+		return val, nil
+	}
+	raw, err := c.Compression.Compressor.Decompress(v.data)
+	if err != nil {
+		return nil, err
+	}
+	if v.text {
+		return string(raw), nil
+	}
+	return raw, nil
+}
+
+// expanded returns the original form of a cached value, or nil if it can't be decompressed.
+// Entries stored by GetField are returned as a copy of their generated fields.
+func (c *Cache) expanded(val interface{}) interface{} {
+	if set, ok := val.(*fieldSet); ok {
+		return set.resolved()
+	}
+	val, _ = c.expand(val)
+	return val
+}
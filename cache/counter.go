@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Increment atomically adds delta to the int64 counter stored under key and returns the new count.
+//
+// A missing or expired counter starts from zero with the given ttl, so rate counters reset once their window
+// passes; later increments leave its expiry untouched. Any other value stored under key is replaced.
+// The counter may be read with Get, GetIfPresent or Peek, which return an int64. If the key's value is being
+// generated or refreshed, Increment waits for the generation to finish first.
+func (c *Cache) Increment(key interface{}, delta int64, ttl time.Duration) int64 {
+	ttl = c.lifetime(ttl)
+	c.lockMutable()
+	c.awaitIdle(key)
+	defer c.unlock()
+	if item, ok := c.data[key]; ok {
+		if count, isCounter := item.val.(int64); isCounter && item.err == nil && !c.expired(item) {
+			count += delta
+			item.val = count
+			c.touched(key, item)
+			c.published(key, item)
+			return count
+		}
+		c.remove(key)
+	}
+	if ttl == 0 {
+		return delta
+	}
+	if c.oversized(key) {
+		c.stats.OversizedKeys++
+		return delta
+	}
+	item := &cacheItem{val: delta, future: &sync.WaitGroup{}, created: c.now(), size: c.sizeOf(key, delta), origin: c.newOrigin(SourceSet, "", c.callerPC(1))}
+	c.setTTL(item, ttl)
+	c.insert(key, item)
+	return delta
+}
+
+// awaitIdle waits until the entry under key, if any, has no generation or refresh in flight. The cache must be
+// locked by lockMutable; the lock is released while waiting.
+func (c *Cache) awaitIdle(key interface{}) {
+	for {
+		item, ok := c.data[key]
+		if !ok {
+			return
+		}
+		future := item.refresh
+		if item.pending {
+			future = item.future
+		}
+		if future == nil {
+			return
+		}
+		c.unlock()
+		future.Wait()
+		c.lockMutable()
+	}
+}
@@ -0,0 +1,255 @@
+/*
+Package diskstore provides a bounded on-disk cache.ProvenanceStore keeping one file per entry, suited to
+holding the entries a cache evicts when it is given as the cache's Spill, so that they are reloaded from
+local disk rather than regenerated:
+
+	spill, err := diskstore.Open("/var/cache/users", 1<<30)
+	c := &cache.Cache{MaxSize: 1000, MaxStorage: 64 << 20, Spill: spill}
+
+When the files exceed their byte limit the least recently written are removed. Keys and values are encoded
+with Codec, gob by default, so their concrete types must be registered with gob.Register. Expiry follows
+Clock, which should be set to the cache's own Clock when the cache is given one.
+*/
+package diskstore
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+)
+
+// suffix ends the name of every entry file, so that other files in the directory are left alone.
+const suffix = ".entry"
+
+// Store keeps entries in files under a directory, removing the oldest once they exceed MaxBytes.
+// It is safe for concurrent use, but not for sharing a directory between processes.
+type Store struct {
+	Codec cache.Codec // Encodes keys and values; cache.GobCodec if nil
+	Clock cache.Clock // Source of time for expiry; the system clock if nil
+
+	dir      string
+	maxBytes int64
+
+	mutex sync.Mutex
+	files map[string]*list.Element // Entry files by name, as elements of order
+	order *list.List               // Least recently written first; values are *file
+	bytes int64
+}
+
+// file is the bookkeeping for one entry file.
+type file struct {
+	name string
+	size int64
+}
+
+// record is the content of an entry file.
+type record struct {
+	Value      []byte
+	Generated  time.Time
+	Expires    time.Time
+	Provenance []string
+}
+
+// Open opens or creates a store in dir holding at most maxBytes of entry files. Entries left by an earlier
+// Store are kept, and remain readable if they were written with the same Codec.
+func Open(dir string, maxBytes int64) (*Store, error) {
+	if maxBytes <= 0 {
+		return nil, errors.New("Store size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, maxBytes: maxBytes, files: make(map[string]*list.Element), order: list.New()}
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		s.files[info.Name()] = s.order.PushBack(&file{info.Name(), info.Size()})
+		s.bytes += info.Size()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shrink()
+	return s, nil
+}
+
+func (s *Store) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock.Now()
+	}
+	return time.Now()
+}
+
+func (s *Store) codec() cache.Codec {
+	if s.Codec == nil {
+		return cache.GobCodec{}
+	}
+	return s.Codec
+}
+
+// name returns the file name for key.
+func (s *Store) name(key interface{}) (string, error) {
+	data, err := s.codec().Encode(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) + suffix, nil
+}
+
+// Tier implements cache.ProvenanceStore.
+func (s *Store) Tier() string {
+	return "disk"
+}
+
+// Get implements cache.SecondaryStore.
+func (s *Store) Get(key interface{}) (interface{}, bool, error) {
+	entry, ok, err := s.GetEntry(key)
+	return entry.Value, ok, err
+}
+
+// Set implements cache.SecondaryStore.
+func (s *Store) Set(key, value interface{}, ttl time.Duration) error {
+	return s.SetEntry(key, cache.StoredEntry{Value: value, Generated: s.now()}, ttl)
+}
+
+// GetEntry implements cache.ProvenanceStore. Expired entries are removed as they are found.
+func (s *Store) GetEntry(key interface{}) (cache.StoredEntry, bool, error) {
+	name, err := s.name(key)
+	if err != nil {
+		return cache.StoredEntry{}, false, err
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return cache.StoredEntry{}, false, nil
+	} else if err != nil {
+		return cache.StoredEntry{}, false, err
+	}
+	var r record
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&r); err != nil {
+		return cache.StoredEntry{}, false, err
+	}
+	if !s.now().Before(r.Expires) {
+		return cache.StoredEntry{}, false, s.remove(name)
+	}
+	value, err := s.codec().Decode(r.Value)
+	if err != nil {
+		return cache.StoredEntry{}, false, err
+	}
+	return cache.StoredEntry{Value: value, Generated: r.Generated, Provenance: r.Provenance}, true, nil
+}
+
+// SetEntry implements cache.ProvenanceStore, removing the oldest entries if the store grows beyond its limit.
+// The file is written under a temporary name and renamed into place, so readers never see partial entries.
+func (s *Store) SetEntry(key interface{}, entry cache.StoredEntry, ttl time.Duration) error {
+	name, err := s.name(key)
+	if err != nil {
+		return err
+	}
+	value, err := s.codec().Encode(entry.Value)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	r := record{Value: value, Generated: entry.Generated, Expires: s.now().Add(ttl), Provenance: entry.Provenance}
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.forget(name) // A rewritten entry moves to the back of the order
+	s.files[name] = s.order.PushBack(&file{name, int64(buf.Len())})
+	s.bytes += int64(buf.Len())
+	s.shrink()
+	return nil
+}
+
+// Delete implements cache.SecondaryStore.
+func (s *Store) Delete(key interface{}) error {
+	name, err := s.name(key)
+	if err != nil {
+		return err
+	}
+	return s.remove(name)
+}
+
+// remove deletes an entry file.
+func (s *Store) remove(name string) error {
+	err := os.Remove(filepath.Join(s.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.forget(name)
+	return nil
+}
+
+// forget drops the bookkeeping for an entry file. s.mutex must be held.
+func (s *Store) forget(name string) {
+	if e, ok := s.files[name]; ok {
+		s.bytes -= e.Value.(*file).size
+		s.order.Remove(e)
+		delete(s.files, name)
+	}
+}
+
+// Bytes returns the total size of the entry files.
+func (s *Store) Bytes() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.bytes
+}
+
+// shrink removes the least recently written entries until the store is within its limit. s.mutex must be held.
+func (s *Store) shrink() {
+	for e := s.order.Front(); e != nil && s.bytes > s.maxBytes; {
+		f := e.Value.(*file)
+		e = e.Next()
+		if err := os.Remove(filepath.Join(s.dir, f.name)); err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		s.forget(f.name)
+	}
+}
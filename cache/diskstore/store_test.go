@@ -0,0 +1,106 @@
+package diskstore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+	"github.com/ericpauley/flowcache/cache/clocktest"
+)
+
+func TestStore(t *testing.T) {
+	s, err := Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := clocktest.New(time.Now())
+	s.Clock = clock
+	generated := clock.Now().Add(-time.Second).Round(0)
+	if err := s.SetEntry("A", cache.StoredEntry{Value: "a", Generated: generated, Provenance: []string{"memory"}}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok, err := s.GetEntry("A")
+	if err != nil || !ok || entry.Value != "a" || !entry.Generated.Equal(generated) || len(entry.Provenance) != 1 {
+		t.Fatalf("Unexpected entry %+v, %v, %v", entry, ok, err)
+	}
+	if err := s.Set("B", "b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.Get("B"); !ok {
+		t.Fatal("Entry was not returned before it expired")
+	}
+	clock.Advance(time.Minute)
+	if _, ok, _ := s.Get("B"); ok {
+		t.Fatal("Expired entry was returned")
+	}
+	if err := s.Delete("A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.Get("A"); ok || s.Bytes() != 0 {
+		t.Fatal("Deleted entry was returned")
+	}
+}
+
+func TestShrink(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := strings.Repeat("x", 400<<10)
+	for _, key := range []string{"A", "B", "C"} {
+		if err := s.Set(key, big, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, _ := s.Get("A"); ok || s.Bytes() > 1<<20 {
+		t.Fatal("Oldest entry was not removed to respect the limit")
+	}
+	reopened, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, ok, _ := reopened.Get("C"); !ok || val != big || reopened.Bytes() != s.Bytes() {
+		t.Fatal("Entries were not kept across Open")
+	}
+	for _, key := range []string{"B", "D"} { // Rewriting B makes C the oldest
+		if err := s.Set(key, big, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, _ := s.Get("C"); ok {
+		t.Fatal("Least recently written entry was not removed")
+	}
+	if _, ok, _ := s.Get("B"); !ok {
+		t.Fatal("Rewritten entry was removed")
+	}
+}
+
+func TestSpill(t *testing.T) {
+	s, err := Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cache.Cache{MaxSize: 1, Spill: s}
+	generations := 0
+	generate := func(key interface{}) (interface{}, error) {
+		generations++
+		return key.(string) + "!", nil
+	}
+	c.Get("A", time.Minute, generate)()
+	c.Get("B", time.Minute, generate)() // Evicts A to disk
+	if val, err := c.Get("A", time.Minute, generate)(); err != nil || val != "A!" || generations != 2 {
+		t.Fatalf("Spilled entry was not reloaded: %v, %v, %d generations", val, err, generations)
+	}
+	if info, _ := c.Info("A"); len(info.Provenance) != 1 || info.Provenance[0] != "disk" {
+		t.Fatalf("Unexpected provenance %v", info.Provenance)
+	}
+	if stats := c.Stats(); stats.SpillWrites != 2 || stats.SpillHits != 1 {
+		t.Fatalf("Unexpected spill stats %+v", stats)
+	}
+	c.Delete("B")
+	if _, ok, _ := s.Get("B"); ok {
+		t.Fatal("Delete left a spilled copy")
+	}
+}
@@ -0,0 +1,103 @@
+package cache
+
+import "fmt"
+
+// defaultEvictVetoBudget is the number of vetoes honored per prune when EvictVetoBudget is zero.
+const defaultEvictVetoBudget = 8
+
+// EvictDecision is returned by OnEvictCandidate to approve or veto an eviction.
+type EvictDecision int
+
+const (
+	// EvictApprove allows the candidate to be evicted.
+	EvictApprove EvictDecision = iota
+	// EvictVeto keeps the candidate, asking the pruner to choose another.
+	EvictVeto
+)
+
+func (c *Cache) evictVetoBudget() int {
+	if c.EvictVetoBudget > 0 {
+		return c.EvictVetoBudget
+	}
+	return defaultEvictVetoBudget
+}
+
+// vetoEviction reports whether OnEvictCandidate vetoes evicting key, given the vetoes already used. The cache must be locked.
+func (c *Cache) vetoEviction(key interface{}, vetoes int) bool {
+	if c.OnEvictCandidate == nil || vetoes >= c.evictVetoBudget() {
+		return false
+	}
+	return c.OnEvictCandidate(key, c.info(c.data[key])) == EvictVeto
+}
+
+// evicted queues OnEvict and OnRelease for an item leaving the cache. The cache must be locked.
+func (c *Cache) evicted(key interface{}, item *cacheItem) {
+	c.released(key, item)
+	if len(c.subscribers) > 0 {
+		c.publish(Change{Key: key, Generated: c.now(), Deleted: true})
+	}
+	if c.OnEvict == nil || item.created.IsZero() || item.err != nil {
+		return
+	}
+	onEvict, val := c.OnEvict, item.val
+	c.events = append(c.events, func() {
+		onEvict(key, c.expanded(val))
+	})
+}
+
+// stored queues OnStore for the value an item now holds. The cache must be locked.
+func (c *Cache) stored(key interface{}, item *cacheItem) {
+	c.published(key, item)
+	if c.OnStore == nil {
+		return
+	}
+	onStore, val := c.OnStore, item.val
+	c.events = append(c.events, func() {
+		onStore(key, c.expanded(val))
+	})
+}
+
+// released queues OnRelease for the value an item holds, if it holds one. The cache must be locked.
+func (c *Cache) released(key interface{}, item *cacheItem) {
+	if c.OnRelease == nil || item.created.IsZero() || item.err != nil {
+		return
+	}
+	onRelease, val := c.OnRelease, item.val
+	c.events = append(c.events, func() {
+		onRelease(key, c.expanded(val))
+	})
+}
+
+// orderedCandidate is pruneCandidate under DeterministicEviction: it examines every entry not in vetoed and
+// returns the first in eviction order, or nil if there is none. The cache must be locked.
+func (c *Cache) orderedCandidate(vetoed map[interface{}]bool) interface{} {
+	var candidateKey interface{}
+	var candidate *cacheItem
+	for k, v := range c.data {
+		if c.evictable(k, vetoed) && (candidate == nil || c.evictsBefore(k, v, candidateKey, candidate)) {
+			candidateKey, candidate = k, v
+		}
+	}
+	return candidateKey
+}
+
+// evictsBefore orders entries for DeterministicEviction: expired entries first, then by priority, then those never used,
+// then by last use and creation, with ties broken by the keys' printed form.
+func (c *Cache) evictsBefore(key interface{}, item *cacheItem, otherKey interface{}, other *cacheItem) bool {
+	if dead, otherDead := item.ttl == 0 || c.expired(item), other.ttl == 0 || c.expired(other); dead != otherDead {
+		return dead
+	}
+	if item.priority != other.priority {
+		return item.priority < other.priority
+	}
+	if unused, otherUnused := item.lastUsed.IsZero(), other.lastUsed.IsZero(); unused != otherUnused {
+		return unused
+	}
+	if !item.lastUsed.Equal(other.lastUsed) {
+		return item.lastUsed.Before(other.lastUsed)
+	}
+	if !item.created.Equal(other.created) {
+		return item.created.Before(other.created)
+	}
+	return fmt.Sprint(key) < fmt.Sprint(otherKey)
+}
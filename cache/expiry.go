@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryQueue is a min-heap of the entries that can expire, ordered by deadline, letting Purge find expired
+// entries without scanning the cache. A deadline may lag behind its entry's, for example when ExtendOnUse
+// pushes it back, in which case the entry is rescheduled once its old deadline comes up.
+type expiryQueue []*expiryEntry
+
+type expiryEntry struct {
+	key      interface{}
+	item     *cacheItem
+	deadline time.Time
+	index    int
+}
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].deadline.Before(q[j].deadline) }
+func (q expiryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *expiryQueue) Push(x interface{}) {
+	e := x.(*expiryEntry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return e
+}
+
+// schedule queues a cached item for Purge at its current deadline, or dequeues it if it can't expire.
+// It must be called whenever an item's deadline may have moved earlier. The cache must be locked.
+func (c *Cache) schedule(key interface{}, item *cacheItem) {
+	used := c.lastTouched(item)
+	if used.IsZero() || item.ttl == 0 || item.ttl == NoExpiry {
+		c.unschedule(item)
+		return
+	}
+	deadline := used.Add(item.ttl)
+	if e := item.expiry; e != nil {
+		e.deadline = deadline
+		heap.Fix(&c.expiries, e.index)
+		return
+	}
+	item.expiry = &expiryEntry{key: key, item: item, deadline: deadline}
+	heap.Push(&c.expiries, item.expiry)
+}
+
+// unschedule removes an item from the expiry queue. The cache must be locked.
+func (c *Cache) unschedule(item *cacheItem) {
+	if e := item.expiry; e != nil {
+		heap.Remove(&c.expiries, e.index)
+		item.expiry = nil
+	}
+}
+
+// purgeDue removes up to limit expired entries, taking them from the expiry queue in deadline order,
+// or every expired entry if limit is negative. The cache must be locked.
+func (c *Cache) purgeDue(limit int) {
+	now := c.now()
+	var refreshing []*expiryEntry
+	for purged := 0; len(c.expiries) > 0 && purged != limit; {
+		e := c.expiries[0]
+		if !e.deadline.Before(now) {
+			break
+		}
+		switch item := e.item; {
+		case c.purgeable(item):
+			c.expire(e.key, item)
+			purged++
+		case !c.expired(item):
+			c.schedule(e.key, item) // The deadline moved later
+		case c.inStaleGrace(item):
+			e.deadline = c.lastTouched(item).Add(item.ttl + c.StaleOnError)
+			heap.Fix(&c.expiries, 0)
+		default: // A Get is waiting on the refresh; check again on the next purge
+			heap.Pop(&c.expiries)
+			refreshing = append(refreshing, e)
+		}
+	}
+	for _, e := range refreshing {
+		heap.Push(&c.expiries, e)
+	}
+}
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+)
+
+// expvarMutex keeps concurrent PublishExpvar calls from racing to publish the same name, which panics.
+var expvarMutex sync.Mutex
+
+// PublishExpvar publishes the cache's size, storage, hits, misses, evictions and expirations as an expvar
+// under name, so they appear at /debug/vars. Values are read from Stats whenever the variable is read.
+// It fails if name is already published; expvar offers no way to unpublish a name.
+func (c *Cache) PublishExpvar(name string) error {
+	expvarMutex.Lock()
+	defer expvarMutex.Unlock()
+	if expvar.Get(name) != nil {
+		return errors.New("An expvar is already published under this name")
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		stats := c.Stats()
+		return map[string]interface{}{
+			"size":        stats.Entries,
+			"storage":     stats.Storage,
+			"hits":        stats.Hits,
+			"misses":      stats.Misses,
+			"evictions":   stats.Evictions,
+			"expirations": stats.Expirations,
+		}
+	}))
+	return nil
+}
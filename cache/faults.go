@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned by retrieval functions that waited longer than GetTimeout.
+var ErrTimeout = errors.New("Generation timed out")
+
+// FaultInjector perturbs a cache's operation so integrations can be soak tested against its edge cases.
+// Each method is consulted with the key concerned and must be safe for concurrent use.
+type FaultInjector interface {
+	// GenerateDelay returns extra time to wait before invoking the generator.
+	GenerateDelay(key interface{}) time.Duration
+	// FailSizing reports whether sizing the value should fail, leaving it accounted as zero bytes.
+	FailSizing(key interface{}) bool
+	// ForceTimeout reports whether the Get's retrieval function should fail immediately with ErrTimeout.
+	ForceTimeout(key interface{}) bool
+	// ForcePanic reports whether the generator should panic instead of running.
+	ForcePanic(key interface{}) bool
+}
+
+// SeededFaults is a FaultInjector injecting each fault at a fixed rate from a seeded random source,
+// so that a soak test can be replayed deterministically for a given sequence of calls.
+type SeededFaults struct {
+	DelayRate   float64       // Fraction of generations delayed
+	MaxDelay    time.Duration // Upper bound of injected delays
+	SizingRate  float64       // Fraction of sizings failed
+	TimeoutRate float64       // Fraction of Gets timed out
+	PanicRate   float64       // Fraction of generations panicked
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// NewSeededFaults creates a SeededFaults drawing from a random source with the given seed.
+// All rates start at zero.
+func NewSeededFaults(seed int64) *SeededFaults {
+	return &SeededFaults{rand: rand.New(rand.NewSource(seed))}
+}
+
+func (f *SeededFaults) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.rand.Float64() < rate
+}
+
+// GenerateDelay implements FaultInjector.
+func (f *SeededFaults) GenerateDelay(key interface{}) time.Duration {
+	if f.MaxDelay <= 0 || !f.roll(f.DelayRate) {
+		return 0
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return time.Duration(f.rand.Int63n(int64(f.MaxDelay)))
+}
+
+// FailSizing implements FaultInjector.
+func (f *SeededFaults) FailSizing(key interface{}) bool {
+	return f.roll(f.SizingRate)
+}
+
+// ForceTimeout implements FaultInjector.
+func (f *SeededFaults) ForceTimeout(key interface{}) bool {
+	return f.roll(f.TimeoutRate)
+}
+
+// ForcePanic implements FaultInjector.
+func (f *SeededFaults) ForcePanic(key interface{}) bool {
+	return f.roll(f.PanicRate)
+}
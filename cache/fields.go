@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFieldSet is returned by GetField when key holds a value not created by GetField.
+var ErrNotFieldSet = errors.New("Cache entry does not hold fields")
+
+// fieldSet is the value stored under a key by GetField.
+type fieldSet struct {
+	mutex  sync.Mutex
+	fields map[interface{}]*fieldValue
+}
+
+type fieldValue struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// resolved copies the fields generated so far, the form in which the entry is shown outside GetField.
+func (s *fieldSet) resolved() map[interface{}]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fields := make(map[interface{}]interface{}, len(s.fields))
+	for field, f := range s.fields {
+		select {
+		case <-f.done:
+			fields[field] = f.val
+		default: // Still being generated
+		}
+	}
+	return fields
+}
+
+// isFieldSet reports whether val was stored by GetField. Snapshots and spills leave such entries out,
+// as they could only be restored as plain maps, and refreshes skip them, as they would drop their fields.
+func isFieldSet(val interface{}) bool {
+	_, ok := val.(*fieldSet)
+	return ok
+}
+
+// GetField fetches a single field of a multi-valued entry, in the manner of a Redis hash.
+//
+// All fields stored under key share one cache entry, and so one TTL, eviction decision and storage account.
+// A missing field is generated at most once at a time; failed field generations are not cached.
+// The TTL is only used when the entry itself is created. The entry is kept regardless of MinGenerationTime
+// and is never refreshed, so its fields last until it expires or is removed. Other readers, such as Peek, Range
+// and the hooks, see the entry as a map of the fields generated so far, and snapshots and Spill leave it out.
+func (c *Cache) GetField(key, field interface{}, ttl time.Duration, generate func(key, field interface{}) (interface{}, error)) func() (interface{}, error) {
+	entry := c.get(key, GetOptions{TTL: ttl, caller: c.callerPC(1)}, func(context.Context, interface{}) (interface{}, error) {
+		return &fieldSet{fields: make(map[interface{}]*fieldValue)}, nil
+	})
+	var result interface{}
+	var resErr error
+	resultWait := make(chan struct{})
+	go func() {
+		defer close(resultWait)
+		val, err := entry()
+		if err != nil {
+			resErr = err
+			return
+		}
+		set, ok := val.(*fieldSet)
+		if !ok {
+			resErr = ErrNotFieldSet
+			return
+		}
+		result, resErr = c.resolveField(key, field, set, generate)
+	}()
+	return c.retrieval(context.Background(), key, 0, resultWait, func() (interface{}, error) {
+		return result, resErr
+	})
+}
+
+func (c *Cache) resolveField(key, field interface{}, set *fieldSet, generate func(key, field interface{}) (interface{}, error)) (interface{}, error) {
+	set.mutex.Lock()
+	f, ok := set.fields[field]
+	if !ok {
+		f = &fieldValue{done: make(chan struct{})}
+		set.fields[field] = f
+	}
+	set.mutex.Unlock()
+	if ok {
+		<-f.done
+		return f.val, f.err
+	}
+	f.val, f.err = c.guard(key, func() (interface{}, error) {
+		return generate(key, field)
+	})
+	if f.err != nil {
+		set.mutex.Lock()
+		delete(set.fields, field)
+		set.mutex.Unlock()
+	} else {
+		c.grow(key, set, c.sizeOf(key, f.val))
+	}
+	close(f.done)
+	return f.val, f.err
+}
+
+// grow accounts additional storage to the entry under key if it still holds val.
+func (c *Cache) grow(key, val interface{}, size uint64) {
+	c.lockMap()
+	defer c.unlock()
+	item, ok := c.data[key]
+	if !ok || item.val != val {
+		return
+	}
+	item.size += size
+	c.storage += size
+	c.pruneWhile(c.overLimit)
+}
@@ -0,0 +1,44 @@
+package cache
+
+import "sync"
+
+// Freeze blocks writes to the cache until Thaw is called, so a consistent view can be taken, for
+// example with SaveTo, without holding the lock for the whole of it. Reads continue: GetIfPresent, Peek,
+// Range and cache hits are served as usual.
+//
+// Set, Delete, Clear, Touch, Patch, Append, Increment, the Invalidate methods, LoadFrom and purges,
+// including the janitor's, wait for Thaw; Gets skip their incremental purge instead. Get still generates
+// missing or expired keys, so entries may be added, refreshed or evicted by Gets while frozen. A goroutine
+// holding the cache frozen must not itself call a blocked method. Only one Freeze may be held at a time;
+// others wait.
+func (c *Cache) Freeze() {
+	c.lockMutable()
+	c.frozen = true
+	c.unlock()
+}
+
+// Thaw releases a Freeze, resuming blocked writes.
+func (c *Cache) Thaw() {
+	c.mutex.Lock()
+	c.frozen = false
+	if c.thawed != nil {
+		c.thawed.Broadcast()
+	}
+	c.unlock()
+}
+
+// lockMutable locks the cache like lockMap, first waiting out any Freeze.
+func (c *Cache) lockMutable() {
+	c.lockMap()
+	c.awaitThaw()
+}
+
+// awaitThaw waits until the cache is not frozen. The cache must be locked; the lock is released while waiting.
+func (c *Cache) awaitThaw() {
+	for c.frozen {
+		if c.thawed == nil {
+			c.thawed = sync.NewCond(&c.mutex)
+		}
+		c.thawed.Wait()
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Package grpcserver serves a flowcache over gRPC, as defined in cache.proto, so that services written in any
+language can share one coalescing cache, for instance one running as a sidecar:
+
+	srv := grpc.NewServer()
+	grpcserver.RegisterCacheServer(srv, grpcserver.New(c, time.Minute, generate))
+	srv.Serve(listener)
+
+Keys are strings and values are cached as []byte, so Go code sharing the cache directly should store []byte
+values under string keys as well. Clients in other languages are generated from cache.proto.
+*/
+package grpcserver
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative cache.proto
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements CacheServer on top of a cache.
+type Server struct {
+	UnimplementedCacheServer
+
+	cache    *cache.Cache
+	ttl      time.Duration
+	generate func(key string) ([]byte, error)
+}
+
+// New creates a Server for c. Requests that give no TTL cache values for ttl. On a miss Get calls generate,
+// once for all clients requesting the key at the same time; if generate is nil a miss fails with NotFound.
+func New(c *cache.Cache, ttl time.Duration, generate func(key string) ([]byte, error)) *Server {
+	return &Server{cache: c, ttl: ttl, generate: generate}
+}
+
+// ttlFor returns the TTL given in a request, or the server's default.
+func (s *Server) ttlFor(ms int64) time.Duration {
+	if ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return s.ttl
+}
+
+// Get implements CacheServer. The request's deadline, if any, bounds how long it waits for a generation,
+// but the generation carries on for other clients if the request gives up.
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	if s.generate == nil {
+		val, ok := s.cache.GetIfPresent(req.Key)
+		if !ok {
+			return nil, status.Error(codes.NotFound, "Key not cached")
+		}
+		return response(val, true)
+	}
+	opts := cache.GetOptions{TTL: s.ttlFor(req.TtlMs)}
+	if deadline, ok := ctx.Deadline(); ok {
+		if opts.Timeout = time.Until(deadline); opts.Timeout <= 0 {
+			return nil, status.Error(codes.DeadlineExceeded, cache.ErrTimeout.Error())
+		}
+	}
+	result := s.cache.GetResult(req.Key, opts, func(key interface{}) (interface{}, error) {
+		return s.generate(key.(string))
+	})()
+	if result.Err != nil {
+		return nil, statusOf(result.Err)
+	}
+	return response(result.Value, result.Hit)
+}
+
+// response wraps a cached value, which must be a []byte.
+func response(val interface{}, hit bool) (*GetResponse, error) {
+	b, ok := val.([]byte)
+	if !ok && val != nil {
+		return nil, status.Error(codes.Internal, "Cached value is not a byte slice")
+	}
+	return &GetResponse{Value: b, Hit: hit}, nil
+}
+
+// statusOf converts a Get error to a gRPC status. Generator errors that already carry a status keep it.
+func statusOf(err error) error {
+	switch {
+	case errors.Is(err, cache.ErrTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, cache.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return err // Reported as Unknown by gRPC unless it carries a status
+	}
+}
+
+// Set implements CacheServer.
+func (s *Server) Set(ctx context.Context, req *SetRequest) (*SetResponse, error) {
+	s.cache.Set(req.Key, req.Value, s.ttlFor(req.TtlMs))
+	return &SetResponse{}, nil
+}
+
+// Delete implements CacheServer.
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	return &DeleteResponse{Deleted: s.cache.Delete(req.Key)}, nil
+}
+
+// Stats implements CacheServer.
+func (s *Server) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	stats := s.cache.Stats()
+	return &StatsResponse{
+		Entries:          int64(stats.Entries),
+		Storage:          stats.Storage,
+		Hits:             stats.Hits,
+		Misses:           stats.Misses,
+		Evictions:        stats.Evictions,
+		Expirations:      stats.Expirations,
+		Generations:      stats.Generations,
+		GenerationErrors: stats.GenerationErrors,
+		GenerationTimeMs: stats.GenerationTime.Milliseconds(),
+	}, nil
+}
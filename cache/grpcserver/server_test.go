@@ -0,0 +1,107 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func serve(t *testing.T, s *Server) CacheClient {
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	RegisterCacheServer(srv, s)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewCacheClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	c := &cache.Cache{MaxSize: 10}
+	var calls int32
+	client := serve(t, New(c, time.Minute, func(key string) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		if key == "bad" {
+			return nil, status.Error(codes.PermissionDenied, "Denied")
+		}
+		return []byte("generated " + key), nil
+	}))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(ctx, &GetRequest{Key: "A"})
+			if err != nil || string(resp.Value) != "generated A" {
+				t.Errorf("Unexpected Get result %v, %v", resp, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("Concurrent Gets were not coalesced: %d generations", calls)
+	}
+
+	if _, err := client.Set(ctx, &SetRequest{Key: "B", Value: []byte("set")}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get(ctx, &GetRequest{Key: "B"}); err != nil || string(resp.Value) != "set" || !resp.Hit {
+		t.Errorf("Set value was not served: %v, %v", resp, err)
+	}
+	if resp, err := client.Delete(ctx, &DeleteRequest{Key: "B"}); err != nil || !resp.Deleted {
+		t.Errorf("Delete failed: %v, %v", resp, err)
+	}
+	if _, err := client.Get(ctx, &GetRequest{Key: "bad"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Generator status was not passed on: %v", err)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.Get(timeout, &GetRequest{Key: "C"}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Get did not respect the request deadline: %v", err)
+	}
+
+	stats, err := client.Stats(ctx, &StatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Hits != 5 || stats.Misses != 3 {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+}
+
+func TestServerWithoutGenerator(t *testing.T) {
+	c := &cache.Cache{MaxSize: 10}
+	client := serve(t, New(c, time.Minute, nil))
+	ctx := context.Background()
+	if _, err := client.Get(ctx, &GetRequest{Key: "A"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Miss without a generator was not NotFound: %v", err)
+	}
+	c.Set("A", []byte("a"), time.Minute)
+	if resp, err := client.Get(ctx, &GetRequest{Key: "A"}); err != nil || string(resp.Value) != "a" {
+		t.Errorf("Unexpected Get result %v, %v", resp, err)
+	}
+	c.Set("B", 1, time.Minute)
+	if _, err := client.Get(ctx, &GetRequest{Key: "B"}); status.Code(err) != codes.Internal {
+		t.Errorf("Non-byte value was served: %v", err)
+	}
+}
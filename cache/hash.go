@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// hashKey hashes an arbitrary comparable key. Strings and integers are hashed directly;
+// other keys are hashed through their printed form. It is the default for every Hash field.
+func hashKey(key interface{}) uint64 {
+	h := fnv.New64a()
+	switch k := key.(type) {
+	case string:
+		h.Write([]byte(k))
+	case int:
+		h.Write(strconv.AppendInt([]byte("i"), int64(k), 10))
+	case int64:
+		h.Write(strconv.AppendInt([]byte("i64"), k, 10))
+	case uint64:
+		h.Write(strconv.AppendUint([]byte("u64"), k, 10))
+	default:
+		fmt.Fprintf(h, "%T:%#v", key, key)
+	}
+	return h.Sum64()
+}
+
+// hashWith hashes key with hash, or hashKey if hash is nil.
+func hashWith(hash func(key interface{}) uint64, key interface{}) uint64 {
+	if hash != nil {
+		return hash(key)
+	}
+	return hashKey(key)
+}
+
+// Distribution counts keys by hash bucket, as returned by KeyDistribution.
+type Distribution struct {
+	Buckets []int
+}
+
+// Skew returns the ratio of the fullest bucket to the mean, 1 for a perfectly even distribution
+// and zero if there are no keys. Values far above 1 suggest a hash that clusters the cache's keys.
+func (d Distribution) Skew() float64 {
+	total, fullest := 0, 0
+	for _, n := range d.Buckets {
+		total += n
+		if n > fullest {
+			fullest = n
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(fullest) * float64(len(d.Buckets)) / float64(total)
+}
+
+// KeyDistribution hashes every key in the cache with Hash into the given number of buckets,
+// exposing how evenly the hash spreads the cache's keys.
+func (c *Cache) KeyDistribution(buckets int) Distribution {
+	if buckets < 1 {
+		buckets = 1
+	}
+	d := Distribution{Buckets: make([]int, buckets)}
+	c.lockMap()
+	defer c.unlock()
+	for key := range c.data {
+		d.Buckets[hashWith(c.Hash, key)%uint64(buckets)]++
+	}
+	return d
+}
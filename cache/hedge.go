@@ -0,0 +1,58 @@
+package cache
+
+import "context"
+
+// throughHedge wraps generate to start a second, hedging call if the first hasn't returned within HedgeDelay.
+// The first call to succeed answers the generation and the other's context is cancelled; an error is returned
+// only once every call started has failed. The hedge is skipped if allow, when set, refuses it.
+// The cache must be locked.
+func (c *Cache) throughHedge(generate generator, allow func(key interface{}) bool) generator {
+	delay := c.HedgeDelay
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		type outcome struct {
+			val   interface{}
+			err   error
+			hedge bool
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		results := make(chan outcome, 2) // Buffered so the losing call can finish after we return
+		call := func(hedge bool) {
+			c.inflight.Add(1)
+			go func() {
+				defer c.inflight.Done()
+				val, err := c.guard(key, func() (interface{}, error) { return generate(ctx, key) })
+				results <- outcome{val, err, hedge}
+			}()
+		}
+		call(false)
+		wait, release := c.after(delay)
+		defer release()
+		var r outcome
+		select {
+		case r = <-results:
+			return r.val, r.err
+		case <-wait:
+		}
+		if ctx.Err() != nil || (allow != nil && !allow(key)) { // Abandoned or throttled
+			r = <-results
+			return r.val, r.err
+		}
+		call(true)
+		c.mutex.Lock()
+		c.stats.Hedges++
+		c.unlock()
+		for pending := 2; pending > 0; pending-- {
+			r = <-results
+			if r.err == nil {
+				break
+			}
+		}
+		if r.err == nil && r.hedge {
+			c.mutex.Lock()
+			c.stats.HedgeWins++
+			c.unlock()
+		}
+		return r.val, r.err
+	}
+}
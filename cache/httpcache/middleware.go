@@ -0,0 +1,152 @@
+/*
+Package httpcache caches HTTP responses in a flowcache, so that concurrent requests for an expensive
+endpoint share a single call to the handler:
+
+	cached := httpcache.Middleware(c, func(r *http.Request) string { return r.URL.String() }, time.Minute)
+	http.Handle("/report", cached(reportHandler))
+
+Only GET requests are cached, and only responses with a status of 200, 203 or 204 are kept.
+Other responses are passed to the callers that were waiting on them and then discarded, even
+under the cache's ErrorTTL. Responses setting a cookie or marked Cache-Control: private or
+no-store are only served to the request that produced them; the other waiting callers call the
+handler themselves. A panicking handler is answered with 502 Bad Gateway.
+*/
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+)
+
+// errUncacheable marks responses that are returned to their waiting callers but not cached.
+var errUncacheable = errors.New("Response is not cacheable")
+
+// errNotShared marks responses that may only be served to the request that produced them.
+var errNotShared = errors.New("Response is not shareable")
+
+// errPanicked marks handlers that panicked while generating a response.
+var errPanicked = errors.New("Handler panicked")
+
+// cacheable holds the successful statuses that RFC 9110 lets caches store without explicit freshness,
+// leaving out 206 Partial Content, which depends on the request's Range.
+var cacheable = map[int]bool{http.StatusOK: true, http.StatusNonAuthoritativeInfo: true, http.StatusNoContent: true}
+
+// shareable reports whether a response with header may be kept by a shared cache, serving it to other clients.
+func shareable(header http.Header) bool {
+	if len(header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name := strings.TrimSpace(strings.SplitN(directive, "=", 2)[0])
+			if strings.EqualFold(name, "private") || strings.EqualFold(name, "no-store") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Response is a recorded HTTP response, as stored in the cache.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// WriteTo replays the response to w.
+func (r *Response) WriteTo(w http.ResponseWriter) {
+	header := w.Header()
+	for name, values := range r.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	w.WriteHeader(r.Status)
+	w.Write(r.Body)
+}
+
+// recorder captures a handler's response.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
+
+// Middleware returns middleware caching responses in c under keyFn(r) for ttl.
+// Requests for which keyFn returns an empty string bypass the cache.
+//
+// The handler runs once per key at a time, for whichever request first missed the cache,
+// so keyFn must capture everything the response depends on.
+func Middleware(c *cache.Cache, keyFn func(*http.Request) string, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet { // HEAD responses lack the body a GET needs
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := keyFn(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var own *Response
+			val, err := c.Get(key, ttl, func(interface{}) (val interface{}, err error) {
+				defer func() {
+					if recover() != nil {
+						val, err = nil, cache.DoNotCache(errPanicked)
+					}
+				}()
+				// The generation may outlive r's ServeHTTP under GetTimeout, so run the handler on a detached copy
+				req := r.Clone(context.WithoutCancel(r.Context()))
+				req.Body = http.NoBody
+				rec := &recorder{header: make(http.Header)}
+				next.ServeHTTP(rec, req)
+				if rec.status == 0 {
+					rec.status = http.StatusOK
+				}
+				resp := &Response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+				if !shareable(resp.Header) {
+					own = resp
+					return nil, cache.DoNotCache(errNotShared)
+				}
+				if !cacheable[resp.Status] {
+					return resp, cache.DoNotCache(errUncacheable)
+				}
+				return resp, nil
+			})()
+			if errors.Is(err, errNotShared) {
+				if own == nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+				val = own
+			}
+			resp, ok := val.(*Response)
+			if !ok || (err != nil && !errors.Is(err, errUncacheable) && !errors.Is(err, errNotShared)) {
+				http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+				return
+			}
+			resp.WriteTo(w)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+)
+
+func TestMiddleware(t *testing.T) {
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Test", "yes")
+		w.Write([]byte("hello"))
+	})
+	c := &cache.Cache{MaxSize: 10}
+	server := Middleware(c, func(r *http.Request) string { return r.URL.Path }, time.Minute)(handler)
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", "/hello", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "hello" || rec.Header().Get("X-Test") != "yes" {
+			t.Fatalf("Unexpected response %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatal("Cached response was not reused")
+	}
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("Unexpected status %d", rec.Code)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Fatal("Error response was cached")
+	}
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/hello", nil))
+	if atomic.LoadInt32(&calls) != 4 {
+		t.Fatal("POST was served from the cache")
+	}
+}
+
+func TestMiddlewareUncacheable(t *testing.T) {
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/partial":
+			w.WriteHeader(http.StatusPartialContent)
+		case "/cookie":
+			w.Header().Set("Set-Cookie", "session=1")
+		case "/private":
+			w.Header().Set("Cache-Control", "max-age=60, Private")
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		default:
+			w.Write([]byte("hello"))
+		}
+	})
+	c := &cache.Cache{MaxSize: 10, ErrorTTL: time.Minute}
+	server := Middleware(c, func(r *http.Request) string { return r.URL.Path }, time.Minute)(handler)
+	for _, path := range []string{"/missing", "/partial", "/cookie", "/private", "/nostore"} {
+		for i := 0; i < 2; i++ {
+			server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}
+	}
+	if atomic.LoadInt32(&calls) != 10 {
+		t.Fatal("Uncacheable response was cached under ErrorTTL")
+	}
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/hello", nil))
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("GET", "/hello", nil))
+	if rec.Body.String() != "hello" {
+		t.Fatalf("GET was served the body of a HEAD request: %q", rec.Body.String())
+	}
+}
+
+func TestMiddlewareNotShared(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("X-User")
+		if user == "a" {
+			close(started)
+			<-release
+		}
+		w.Header().Set("Set-Cookie", "session="+user)
+		w.Write([]byte(user))
+	})
+	hits := make(chan interface{}, 1)
+	c := &cache.Cache{MaxSize: 10, OnHit: func(key interface{}) { hits <- key }}
+	server := Middleware(c, func(r *http.Request) string { return r.URL.Path }, time.Minute)(handler)
+	serve := func(user string) <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			req := httptest.NewRequest("GET", "/account", nil)
+			req.Header.Set("X-User", user)
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			done <- rec
+		}()
+		return done
+	}
+	first := serve("a")
+	<-started
+	second := serve("b")
+	<-hits // The second request is waiting on the first one's generation
+	close(release)
+	for user, done := range map[string]<-chan *httptest.ResponseRecorder{"a": first, "b": second} {
+		rec := <-done
+		if rec.Body.String() != user || rec.Header().Get("Set-Cookie") != "session="+user {
+			t.Fatalf("Request for %s was served %q with cookie %q", user, rec.Body.String(), rec.Header().Get("Set-Cookie"))
+		}
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	})
+	c := &cache.Cache{MaxSize: 10, ErrorTTL: time.Minute}
+	server := Middleware(c, func(r *http.Request) string { return r.URL.Path }, time.Minute)(handler)
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+		if rec.Code != http.StatusBadGateway {
+			t.Fatalf("Unexpected status %d", rec.Code)
+		}
+	}
+	if c.Size() != 0 {
+		t.Fatal("Panic was cached")
+	}
+}
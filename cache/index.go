@@ -0,0 +1,60 @@
+package cache
+
+// index records item's secondary key, replacing any previous one. The cache must be locked.
+func (c *Cache) index(key interface{}, item *cacheItem) {
+	if c.SecondaryKey == nil {
+		return
+	}
+	c.unindex(key, item)
+	if item.err != nil {
+		return
+	}
+	secondary := c.SecondaryKey(c.expanded(item.val))
+	if secondary == nil {
+		return
+	}
+	if c.secondary == nil {
+		c.secondary = make(map[interface{}]interface{})
+	}
+	c.secondary[secondary] = key
+	item.indexed = secondary
+}
+
+// unindex drops item's secondary key if it still refers to key. The cache must be locked.
+func (c *Cache) unindex(key interface{}, item *cacheItem) {
+	if item.indexed == nil {
+		return
+	}
+	if c.secondary[item.indexed] == key {
+		delete(c.secondary, item.indexed)
+	}
+	item.indexed = nil
+}
+
+// LookupSecondary finds a cached entry by the secondary key extracted with SecondaryKey.
+// Only completed, unexpired entries are returned and the generator is never invoked.
+func (c *Cache) LookupSecondary(secondary interface{}) (key, value interface{}, ok bool) {
+	c.lockMap()
+	defer c.unlock()
+	key, ok = c.secondary[secondary]
+	if !ok {
+		return nil, nil, false
+	}
+	item := c.data[key]
+	if item.created.IsZero() || item.err != nil || c.expired(item) {
+		return nil, nil, false
+	}
+	return key, c.expanded(item.val), true
+}
+
+// InvalidateSecondary removes the entry indexed under the given secondary key, returning whether one was found.
+func (c *Cache) InvalidateSecondary(secondary interface{}) bool {
+	c.lockMutable()
+	defer c.unlock()
+	key, ok := c.secondary[secondary]
+	if !ok {
+		return false
+	}
+	c.remove(key)
+	return true
+}
@@ -0,0 +1,72 @@
+package cache
+
+import "time"
+
+// EntryInfo describes the bookkeeping the cache holds for a single entry.
+type EntryInfo struct {
+	Created  time.Time     // When the current value was generated
+	LastUsed time.Time     // When the entry was last returned by Get, zero if never
+	TTL      time.Duration // The entry's time to live
+	Size     uint64        // The estimated storage used by the value, zero unless MaxStorage is set
+	KeySize  uint64        // The estimated storage used by the key, zero unless MaxStorage is set
+
+	Age       time.Duration // Time since Created, as of when the info was taken
+	Remaining time.Duration // Time until the entry expires, as of when the info was taken; zero if expired or uncached, NoExpiry if it never expires
+
+	Refreshing bool   // A regeneration of the current value is in flight
+	Hits       uint64 // Gets and GetIfPresent calls answered by the entry
+
+	// Origin, recorded only when TrackOrigin is set
+	Source    Source // How the current value was produced
+	Namespace string // The GetOptions.Namespace of the call that created the entry
+	Caller    string // The function and line that created the entry
+
+	// Provenance lists the tiers the value was read through, oldest first, when it came from a
+	// ProvenanceStore; Created is then when the value was originally generated.
+	Provenance []string
+
+	// Labels are those attached by GetOptions.Labels or SetWithLabels when the entry was created.
+	// They must not be modified.
+	Labels map[string]string
+}
+
+// Info returns the bookkeeping held for key, including its origin if TrackOrigin is set, and whether the key is cached.
+// Taking it does not count as a use of the entry.
+func (c *Cache) Info(key interface{}) (EntryInfo, bool) {
+	c.lockMap()
+	defer c.unlock()
+	item, ok := c.data[key]
+	if !ok {
+		return EntryInfo{}, false
+	}
+	return c.info(item), true
+}
+
+func (c *Cache) info(item *cacheItem) EntryInfo {
+	info := EntryInfo{
+		Created:  item.created,
+		LastUsed: item.lastUsed,
+		TTL:      item.ttl,
+		Size:     item.size,
+		KeySize:  item.keySize,
+
+		Refreshing: !item.created.IsZero() && (item.refresh != nil || item.pending),
+		Hits:       item.hits,
+		Provenance: item.provenance,
+		Labels:     item.labels,
+	}
+	if !item.created.IsZero() {
+		info.Age = c.now().Sub(item.created)
+		if item.ttl != 0 {
+			if remaining := c.remaining(item); remaining > 0 {
+				info.Remaining = remaining
+			}
+		}
+	}
+	if item.origin != nil {
+		info.Source = item.origin.source
+		info.Namespace = item.origin.namespace
+		info.Caller = describeCaller(item.origin.caller)
+	}
+	return info
+}
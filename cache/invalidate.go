@@ -0,0 +1,36 @@
+package cache
+
+import "strings"
+
+// InvalidateFunc removes every entry whose key matches fn from the cache and Store, returning how many
+// entries were removed from the cache. Like Delete, goroutines already waiting on an entry still receive its value.
+//
+// fn is called with the cache locked and must not call back into the cache.
+func (c *Cache) InvalidateFunc(fn func(key interface{}) bool) int {
+	c.lockMutable()
+	var keys []interface{}
+	for key := range c.data {
+		if fn(key) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		c.remove(key)
+	}
+	c.maybeCompact()
+	c.unlock()
+	for _, key := range keys {
+		c.storeWrite(key, nil, 0)
+		c.replaced(key)
+	}
+	return len(keys)
+}
+
+// InvalidatePrefix removes every entry whose key is a string starting with prefix, as InvalidateFunc.
+// Keys of other types are left alone.
+func (c *Cache) InvalidatePrefix(prefix string) int {
+	return c.InvalidateFunc(func(key interface{}) bool {
+		s, ok := key.(string)
+		return ok && strings.HasPrefix(s, prefix)
+	})
+}
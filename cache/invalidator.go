@@ -0,0 +1,76 @@
+package cache
+
+import "errors"
+
+// Invalidator carries invalidations between caches in separate processes, such as the replicas of a service,
+// so that a key deleted or replaced on one cache is removed from all of them rather than served stale until it expires.
+type Invalidator interface {
+	// Publish announces that key was invalidated.
+	Publish(key interface{}) error
+	// Subscribe calls fn with each key published by other caches until cancel is called.
+	// A cache's own publications need not be delivered back to it.
+	Subscribe(fn func(key interface{})) (cancel func(), err error)
+}
+
+// broadcast publishes an invalidation of key through Invalidator, if set.
+func (c *Cache) broadcast(key interface{}) {
+	if c.Invalidator == nil {
+		return
+	}
+	if err := c.Invalidator.Publish(c.scoped(key)); err != nil {
+		c.mutex.Lock()
+		c.stats.InvalidationErrors++
+		c.unlock()
+	}
+}
+
+// replaced drops the copies of key held outside this cache after it was deleted or given a new value:
+// the one spilled to Spill, and through Invalidator, those of other caches.
+func (c *Cache) replaced(key interface{}) {
+	if c.Spill != nil {
+		c.Spill.Delete(c.scoped(key))
+	}
+	c.broadcast(key)
+}
+
+// Listen removes the keys other caches publish through Invalidator from this cache until the returned function is
+// called or the cache is closed. Removals are local: they are neither written to Store nor published again.
+// Keys published by caches in another Scope are ignored.
+func (c *Cache) Listen() (cancel func(), err error) {
+	if c.Invalidator == nil {
+		return nil, errors.New("No Invalidator is set")
+	}
+	cancel, err = c.Invalidator.Subscribe(c.invalidated)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.listening = append(c.listening, cancel)
+	c.unlock()
+	return cancel, nil
+}
+
+// invalidated removes a key published by another cache.
+func (c *Cache) invalidated(key interface{}) {
+	key, ok := c.unscoped(key)
+	if !ok {
+		return
+	}
+	c.lockMutable()
+	defer c.unlock()
+	if _, ok := c.data[key]; ok {
+		c.remove(key)
+		c.maybeCompact()
+	}
+}
+
+// stopListening cancels the subscriptions made by Listen and FollowPeers.
+func (c *Cache) stopListening() {
+	c.mutex.Lock()
+	listening := c.listening
+	c.listening = nil
+	c.unlock()
+	for _, cancel := range listening {
+		cancel()
+	}
+}
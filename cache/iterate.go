@@ -0,0 +1,44 @@
+package cache
+
+// visible reports whether an entry holds a value that GetIfPresent would return. The cache must be locked.
+func (c *Cache) visible(item *cacheItem) bool {
+	return !item.pending && !item.created.IsZero() && item.err == nil && (!c.expired(item) || item.stale)
+}
+
+// Keys returns the keys of every completed, unexpired and successful entry, in no particular order.
+func (c *Cache) Keys() []interface{} {
+	c.lockMap()
+	defer c.unlock()
+	keys := make([]interface{}, 0, len(c.data))
+	for key, item := range c.data {
+		if c.visible(item) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Range calls fn for every completed, unexpired and successful entry until fn returns false.
+//
+// The entries are captured under the lock in one consistent snapshot, and fn is called after the lock is
+// released, so it may call back into the cache. Changes made after Range begins are not reflected.
+// Range does not count as a use of the entries.
+func (c *Cache) Range(fn func(key, value interface{}, meta EntryInfo) bool) {
+	type entry struct {
+		key, val interface{}
+		info     EntryInfo
+	}
+	c.lockMap()
+	entries := make([]entry, 0, len(c.data))
+	for key, item := range c.data {
+		if c.visible(item) {
+			entries = append(entries, entry{key, item.val, c.info(item)})
+		}
+	}
+	c.unlock()
+	for _, e := range entries {
+		if !fn(e.key, c.expanded(e.val), e.info) {
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrClosed is returned by Get once the cache has been closed.
+var ErrClosed = errors.New("Cache closed")
+
+// startJanitor launches the background goroutine purging expired entries every PurgeInterval, refreshing
+// retained entries every RefreshInterval, auditing an entry every Audit.Interval and checking MemoryPressure,
+// if any is set. The cache must be locked.
+func (c *Cache) startJanitor() {
+	var auditInterval, pressureInterval time.Duration
+	if c.Audit != nil {
+		auditInterval = c.Audit.Interval
+	}
+	if c.MemoryPressure != nil {
+		pressureInterval = c.MemoryPressure.interval()
+	}
+	if (c.PurgeInterval <= 0 && c.RefreshInterval <= 0 && auditInterval <= 0 && pressureInterval <= 0) || c.janitor != nil || c.closed {
+		return
+	}
+	stop := make(chan struct{})
+	c.janitor = stop
+	purge, refresh, audit := c.newTicker(c.PurgeInterval), c.newTicker(c.RefreshInterval), c.newTicker(auditInterval)
+	pressure := c.newTicker(pressureInterval)
+	go func() {
+		defer purge.stop()
+		defer refresh.stop()
+		defer audit.stop()
+		defer pressure.stop()
+		for {
+			select {
+			case <-purge.c:
+				c.Purge()
+				purge.rearm()
+			case <-refresh.c:
+				c.refreshRetained()
+				refresh.rearm()
+			case <-audit.c:
+				c.audit()
+				audit.rearm()
+			case <-pressure.c:
+				c.relieve()
+				pressure.rearm()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// optionalTicker ticks every interval on the cache's Clock, and never if its interval isn't positive.
+// Clock offers only timers, so each tick must be followed by rearm.
+type optionalTicker struct {
+	cache    *Cache
+	interval time.Duration
+	c        <-chan time.Time
+	release  func()
+}
+
+func (c *Cache) newTicker(interval time.Duration) *optionalTicker {
+	t := &optionalTicker{cache: c, interval: interval}
+	t.rearm()
+	return t
+}
+
+// rearm schedules the next tick an interval from now, once the work for the last is done.
+func (t *optionalTicker) rearm() {
+	if t.interval <= 0 {
+		return
+	}
+	if t.release != nil {
+		t.release()
+	}
+	t.c, t.release = t.cache.after(t.interval)
+}
+
+func (t *optionalTicker) stop() {
+	if t.release != nil {
+		t.release()
+	}
+}
+
+// Close shuts the cache down. New Gets fail with ErrClosed, background goroutines are stopped,
+// and Close waits for in-flight generations and refreshes to finish before removing every entry,
+// firing OnEvict for each.
+//
+// If ctx is done before the generators finish, its error is returned and the entries are left in place.
+func (c *Cache) Close(ctx context.Context) error {
+	c.lockMap()
+	c.closed = true
+	if c.janitor != nil {
+		close(c.janitor)
+		c.janitor = nil
+	}
+	c.unlock()
+	c.stopListening()
+	drained := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		c.drainWrites()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	c.lockMap()
+	defer c.unlock()
+	for key := range c.data {
+		c.remove(key)
+	}
+	c.storage = 0
+	return nil
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	latencyWindow     = 1024 // Recent generations kept per namespace
+	minLatencySamples = 20   // Generations needed before a timeout is suggested
+	suggestedQuantile = 0.99
+	suggestedFactor   = 1.5
+	suggestEvery      = 32 // Fraction of the window recorded between recomputing the suggested timeout
+)
+
+// TimeoutBounds limits the timeout applied under AutoTimeout. A zero bound is not enforced.
+type TimeoutBounds struct {
+	Min, Max time.Duration
+}
+
+// latencyTracker keeps a window of recent generation latencies per namespace.
+type latencyTracker struct {
+	mutex   sync.Mutex
+	windows map[string]*latencyRing
+}
+
+type latencyRing struct {
+	samples   []time.Duration
+	next      int
+	recorded  int           // Samples recorded since suggested was computed
+	suggested time.Duration // The timeout suggested by the samples as of the last computation
+}
+
+func (t *latencyTracker) record(namespace string, d time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	w, ok := t.windows[namespace]
+	if !ok {
+		w = &latencyRing{}
+		t.windows[namespace] = w
+	}
+	if len(w.samples) < latencyWindow {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % latencyWindow
+	}
+	// Recomputed every so often rather than on each Get, which reads the suggestion with the cache locked
+	if w.recorded++; w.recorded*suggestEvery >= len(w.samples) {
+		w.recorded = 0
+		w.suggested = 0
+		if len(w.samples) >= minLatencySamples {
+			w.suggested = time.Duration(float64(sortedQuantile(w.samples, suggestedQuantile)) * suggestedFactor)
+		}
+	}
+}
+
+// sortedQuantile returns the q quantile of samples, which it leaves unchanged.
+func sortedQuantile(samples []time.Duration, q float64) time.Duration {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(q*float64(len(sorted)-1)+0.5)]
+}
+
+// quantile returns the q quantile of the namespace's recent latencies and how many were recorded.
+func (t *latencyTracker) quantile(namespace string, q float64) (time.Duration, int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	w, ok := t.windows[namespace]
+	if !ok {
+		return 0, 0
+	}
+	return sortedQuantile(w.samples, q), len(w.samples)
+}
+
+// latency returns the tracker if generation latency is tracked, creating it if needed. The cache must be locked.
+func (c *Cache) latency() *latencyTracker {
+	if !c.TrackLatency && c.AutoTimeout == nil {
+		return nil
+	}
+	if c.latencies == nil {
+		c.latencies = &latencyTracker{windows: make(map[string]*latencyRing)}
+	}
+	return c.latencies
+}
+
+// timed wraps generate to record its latency under namespace.
+func (c *Cache) timed(tracker *latencyTracker, namespace string, generate generator) generator {
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		start := c.now()
+		val, err := generate(ctx, key)
+		tracker.record(namespace, c.since(start))
+		return val, err
+	}
+}
+
+// GenerationLatency returns the q quantile, between 0 and 1, of the latency of recent generations started by
+// Gets in namespace, the GetOptions.Namespace (empty for Get). It returns zero unless TrackLatency or AutoTimeout is set.
+func (c *Cache) GenerationLatency(namespace string, q float64) time.Duration {
+	c.mutex.Lock()
+	tracker := c.latency()
+	c.unlock()
+	if tracker == nil {
+		return 0
+	}
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+	d, _ := tracker.quantile(namespace, q)
+	return d
+}
+
+// SuggestedTimeout returns a Get timeout for namespace that recent generations fit within: their 99th percentile
+// latency with 50% headroom. It returns zero until enough generations have been seen to judge.
+func (c *Cache) SuggestedTimeout(namespace string) time.Duration {
+	c.mutex.Lock()
+	tracker := c.latency()
+	c.unlock()
+	if tracker == nil {
+		return 0
+	}
+	return tracker.suggest(namespace)
+}
+
+// suggest returns the timeout last suggested for namespace when a generation was recorded.
+func (t *latencyTracker) suggest(namespace string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if w, ok := t.windows[namespace]; ok {
+		return w.suggested
+	}
+	return 0
+}
+
+// autoTimeout returns the timeout AutoTimeout applies to a Get in namespace, or zero to fall back to GetTimeout.
+// The cache must be locked.
+func (c *Cache) autoTimeout(namespace string) time.Duration {
+	if c.AutoTimeout == nil {
+		return 0
+	}
+	d := c.latency().suggest(namespace)
+	if d == 0 {
+		return 0
+	}
+	if c.AutoTimeout.Min > 0 && d < c.AutoTimeout.Min {
+		d = c.AutoTimeout.Min
+	}
+	if c.AutoTimeout.Max > 0 && d > c.AutoTimeout.Max {
+		d = c.AutoTimeout.Max
+	}
+	return d
+}
@@ -0,0 +1,21 @@
+package cache
+
+// generationSlots returns the semaphore bounding concurrent generations, or nil if they are unbounded. The cache must be locked.
+func (c *Cache) generationSlots() chan struct{} {
+	if c.MaxConcurrentGenerations <= 0 {
+		return nil
+	}
+	if c.slots == nil {
+		c.slots = make(chan struct{}, c.MaxConcurrentGenerations)
+	}
+	return c.slots
+}
+
+// acquireSlot waits for room in slots, returning a function releasing it. A nil semaphore is unbounded.
+func acquireSlot(slots chan struct{}) func() {
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() { <-slots }
+}
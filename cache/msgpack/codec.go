@@ -0,0 +1,80 @@
+/*
+Package msgpack provides a cache.Codec encoding values as MessagePack, which is more compact and faster to
+decode than gob or JSON and readable from most languages:
+
+	c := &cache.Cache{MaxSize: 1000, Codec: msgpack.Codec{}}
+*/
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/ericpauley/flowcache/cache"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec is a cache.Codec encoding values as MessagePack.
+//
+// MessagePack records little type information, so without New values decode as generic types:
+// integers as int64 or uint64, floats as float64, maps as map[string]interface{} and arrays as []interface{}.
+// A cache.ScopedKey is encoded as a map tagged "$scopedKey" and decodes as a cache.ScopedKey, its Key
+// decoded as any other value.
+type Codec struct {
+	// New, if set, returns a pointer to a fresh value to decode into, such as func() interface{} { return new(User) }.
+	// Decode then returns the value it points to, of the type that was encoded. Snapshots encode keys with the
+	// same Codec, so a Codec with New can only be used for them if keys and values share a type.
+	New func() interface{}
+}
+
+// scopedKey is the form in which Codec encodes a cache.ScopedKey, distinguishing it from other maps.
+type scopedKey struct {
+	ScopedKey *scope `msgpack:"$scopedKey"`
+}
+
+type scope struct {
+	Scope string
+	Key   msgpack.RawMessage
+}
+
+// scopedPrefix begins every encoded scopedKey: a map of one entry, keyed by the 10-byte string "$scopedKey".
+var scopedPrefix = append([]byte{0x81, 0xaa}, "$scopedKey"...)
+
+// Encode encodes v as MessagePack.
+func (m Codec) Encode(v interface{}) ([]byte, error) {
+	if key, ok := v.(cache.ScopedKey); ok {
+		data, err := m.Encode(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		return msgpack.Marshal(scopedKey{&scope{key.Scope, data}})
+	}
+	return msgpack.Marshal(v)
+}
+
+// Decode decodes a value written by Encode.
+func (m Codec) Decode(data []byte) (interface{}, error) {
+	if bytes.HasPrefix(data, scopedPrefix) {
+		var wrapped scopedKey
+		if err := msgpack.Unmarshal(data, &wrapped); err != nil {
+			return nil, err
+		}
+		if wrapped.ScopedKey != nil {
+			key, err := m.Decode(wrapped.ScopedKey.Key)
+			if err != nil {
+				return nil, err
+			}
+			return cache.ScopedKey{Scope: wrapped.ScopedKey.Scope, Key: key}, nil
+		}
+	}
+	if m.New == nil {
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		dec.UseLooseInterfaceDecoding(true)
+		return dec.DecodeInterface()
+	}
+	ptr := m.New()
+	if err := msgpack.Unmarshal(data, ptr); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(ptr).Elem().Interface(), nil
+}
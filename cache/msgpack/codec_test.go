@@ -0,0 +1,67 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestCodec(t *testing.T) {
+	data, err := Codec{}.Encode(map[string]interface{}{"n": 1, "s": []interface{}{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := Codec{}.Decode(data)
+	if err != nil || !reflect.DeepEqual(val, map[string]interface{}{"n": int64(1), "s": []interface{}{"a"}}) {
+		t.Fatalf("Unexpected generic decoding %#v, %v", val, err)
+	}
+
+	typed := Codec{New: func() interface{} { return new(user) }}
+	data, err = typed.Encode(user{"ann", 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, err := typed.Decode(data); err != nil || val != (user{"ann", 30}) {
+		t.Fatalf("Unexpected typed decoding %#v, %v", val, err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c := &cache.Cache{MaxSize: 10, Codec: Codec{}}
+	c.Set("A", "a", time.Minute)
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := &cache.Cache{MaxSize: 10, Codec: Codec{}}
+	if err := restored.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := restored.GetIfPresent("A"); val != "a" {
+		t.Fatal("Snapshot was not restored")
+	}
+}
+
+func TestScopedSnapshot(t *testing.T) {
+	c := &cache.Cache{MaxSize: 10, Codec: Codec{}, Scope: "s"}
+	c.Set("A", "a", time.Minute)
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := &cache.Cache{MaxSize: 10, Codec: Codec{}, Scope: "s"}
+	if err := restored.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := restored.GetIfPresent("A"); val != "a" {
+		t.Fatal("Scoped snapshot was not restored")
+	}
+}
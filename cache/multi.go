@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotGenerated is returned by GetMulti for keys that were absent from the batch generator's result.
+var ErrNotGenerated = errors.New("Key not returned by batch generator")
+
+// GetMulti fetches several keys at once, coalescing all cache misses into a single call to generate.
+//
+// Keys already cached or being generated by another goroutine are shared as with Get. Each missing key is looked
+// up in Spill and Store and checked against RateLimit and Breaker as with Get, and only the keys left over are
+// passed to generate; their values are written through to Store.
+// The retrieval function returns the values for every key that was fetched successfully,
+// along with the first error encountered, if any. A Replica answers from replicated entries alone, as with Get.
+func (c *Cache) GetMulti(keys []interface{}, ttl time.Duration, generate func([]interface{}) (map[interface{}]interface{}, error)) func() (map[interface{}]interface{}, error) {
+	var missing []interface{}
+	var items []*cacheItem
+	var futures []*sync.WaitGroup
+	var generates []generator
+	batch := &multiBatch{done: make(chan struct{})}
+	batched := make(map[interface{}]*cacheItem)
+	caller := c.callerPC(1)
+	ttl = c.lifetime(ttl)
+	// Keys that expire or are evicted before they are read fall back to single-key batches
+	single := withoutContext(func(key interface{}) (interface{}, error) {
+		vals, err := c.callBatch([]interface{}{key}, generate) // Retried by the Get like any generator
+		if err != nil {
+			return nil, err
+		}
+		val, ok := vals[key]
+		if !ok {
+			return nil, ErrNotGenerated
+		}
+		return val, nil
+	})
+	c.lockMap()
+	if c.Replica && !c.closed {
+		results := make([]func() (interface{}, error), len(keys))
+		for i, key := range keys {
+			results[i] = c.replicaGet(key) // Followers never generate locally, even in batches
+		}
+		c.unlock()
+		return collect(keys, results)
+	}
+	allow := c.rateLimited()
+	for _, key := range keys {
+		if c.closed {
+			break
+		}
+		if _, ok := c.data[key]; ok {
+			continue
+		}
+		if _, ok := c.rejected[key]; ok {
+			continue
+		}
+		if _, ok := batched[key]; ok {
+			continue
+		}
+		if c.Absent != nil && c.Absent.MightContain(key) {
+			continue // Get answers ErrAbsent
+		}
+		if c.Admission != nil {
+			c.Admission.Record(key)
+		}
+		item, future := c.miss(key, ttl, GetOptions{caller: caller}, single)
+		batched[key] = item
+		missing = append(missing, key)
+		items = append(items, item)
+		futures = append(futures, future)
+		generates = append(generates, batch.generator(func(share generator) generator {
+			return c.throughTiers(share, item, allow)
+		}))
+	}
+	batch.decided.Add(len(missing))
+	if len(missing) > 0 {
+		c.inflight.Add(1)
+	}
+	slots := c.generationSlots()
+	c.unlock()
+	if len(missing) > 0 {
+		go func() {
+			defer c.inflight.Done()
+			var settled sync.WaitGroup
+			for i, key := range missing {
+				settled.Add(1)
+				go func(i int, key interface{}) {
+					defer settled.Done()
+					c.settleItem(context.Background(), key, items[i], generates[i], futures[i])
+				}(i, key)
+			}
+			batch.decided.Wait()
+			if len(batch.keys) > 0 {
+				release := acquireSlot(slots)
+				batch.vals, batch.err = c.generateBatch(batch.keys, generate)
+				release()
+			}
+			close(batch.done)
+			settled.Wait()
+		}()
+	}
+	results := make([]func() (interface{}, error), len(keys))
+	for i, key := range keys {
+		results[i] = c.get(key, GetOptions{TTL: ttl, caller: caller, batch: batched[key]}, single)
+	}
+	return collect(keys, results)
+}
+
+// multiBatch gathers the keys of a GetMulti left to generate once Store, Spill, RateLimit and Breaker have
+// had their say, and shares the batch generator's result with them.
+type multiBatch struct {
+	mutex   sync.Mutex
+	keys    []interface{}  // Keys that reached the batch generator
+	decided sync.WaitGroup // Done once for each key, as it reaches the generator or is answered without it
+	done    chan struct{}  // Closed once vals and err are set
+	vals    map[interface{}]interface{}
+	err     error
+}
+
+// generator returns the generator of one key in the batch: tiers wraps the call that joins the batch, and a key
+// answered before reaching it is counted as decided all the same.
+func (b *multiBatch) generator(tiers func(share generator) generator) generator {
+	reached := false
+	generate := tiers(func(ctx context.Context, key interface{}) (interface{}, error) {
+		reached = true
+		b.mutex.Lock()
+		b.keys = append(b.keys, key)
+		b.mutex.Unlock()
+		b.decided.Done()
+		<-b.done
+		if b.err != nil {
+			return nil, b.err
+		}
+		val, ok := b.vals[key]
+		if !ok {
+			return nil, ErrNotGenerated
+		}
+		return val, nil
+	})
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		defer func() {
+			if !reached {
+				b.decided.Done()
+			}
+		}()
+		return generate(ctx, key)
+	}
+}
+
+// collect combines the retrieval functions of keys into the retrieval function returned by GetMulti.
+func collect(keys []interface{}, results []func() (interface{}, error)) func() (map[interface{}]interface{}, error) {
+	return func() (map[interface{}]interface{}, error) {
+		vals := make(map[interface{}]interface{}, len(keys))
+		var firstErr error
+		for i, key := range keys {
+			val, err := results[i]()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			vals[key] = val
+		}
+		return vals, firstErr
+	}
+}
+
+// generateBatch calls generate for keys, retrying the whole batch according to Retry.
+func (c *Cache) generateBatch(keys []interface{}, generate func([]interface{}) (map[interface{}]interface{}, error)) (map[interface{}]interface{}, error) {
+	return c.callBatch(keys, func(keys []interface{}) (map[interface{}]interface{}, error) {
+		val, err := c.retry(context.Background(), keys, func(context.Context, interface{}) (interface{}, error) {
+			return generate(keys)
+		})
+		vals, _ := val.(map[interface{}]interface{})
+		return vals, err
+	})
+}
+
+// callBatch calls generate once for keys, converting panics into errors if Recover is set.
+func (c *Cache) callBatch(keys []interface{}, generate func([]interface{}) (map[interface{}]interface{}, error)) (vals map[interface{}]interface{}, err error) {
+	if c.Recover {
+		defer func() {
+			if r := recover(); r != nil {
+				vals = nil
+				err = c.recovered(keys, r)
+			}
+		}()
+	}
+	return generate(keys)
+}
@@ -0,0 +1,615 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultMaxSize is the entry limit used by NewCache unless WithMaxSize is given.
+const DefaultMaxSize = 1024
+
+// Option configures a Cache created by NewCache.
+type Option func(*Cache) error
+
+// NewCache creates a cache configured by opts, returning an error if any option or the resulting configuration is invalid.
+//
+// A zero Cache remains usable directly; NewCache additionally validates configuration and applies defaults.
+func NewCache(opts ...Option) (*Cache, error) {
+	c := &Cache{MaxSize: DefaultMaxSize}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c.expvarName != "" {
+		if err := c.PublishExpvar(c.expvarName); err != nil {
+			return nil, err
+		}
+	}
+	c.lockMap()
+	c.unlock()
+	return c, nil
+}
+
+// WithMaxSize limits the number of entries held by the cache.
+func WithMaxSize(n int) Option {
+	return func(c *Cache) error {
+		if n < 1 {
+			return errors.New("MaxSize must be positive")
+		}
+		c.MaxSize = n
+		return nil
+	}
+}
+
+// WithMaxStorage limits the estimated storage used by cached keys and values, in bytes.
+func WithMaxStorage(bytes uint64) Option {
+	return func(c *Cache) error {
+		c.MaxStorage = bytes
+		return nil
+	}
+}
+
+// WithRefresh regenerates frequently used entries in the background once they are half way through their TTL.
+func WithRefresh() Option {
+	return func(c *Cache) error {
+		c.Refresh = true
+		return nil
+	}
+}
+
+// WithExtendOnUse measures expiry from an entry's last use rather than its creation.
+func WithExtendOnUse() Option {
+	return func(c *Cache) error {
+		c.ExtendOnUse = true
+		return nil
+	}
+}
+
+// WithGetTimeout bounds how long retrieval functions wait for a value.
+func WithGetTimeout(d time.Duration) Option {
+	return func(c *Cache) error {
+		if d < 0 {
+			return errors.New("GetTimeout must not be negative")
+		}
+		c.GetTimeout = d
+		return nil
+	}
+}
+
+// WithRecover converts generator panics into errors.
+func WithRecover() Option {
+	return func(c *Cache) error {
+		c.Recover = true
+		return nil
+	}
+}
+
+// WithAcceptRefresh sets the hook deciding whether a refreshed value may replace the current one.
+func WithAcceptRefresh(accept func(old, new interface{}) bool) Option {
+	return func(c *Cache) error {
+		c.AcceptRefresh = accept
+		return nil
+	}
+}
+
+// WithStaleOnError serves the last good value for up to d past expiry when regeneration fails.
+func WithStaleOnError(d time.Duration) Option {
+	return func(c *Cache) error {
+		if d < 0 {
+			return errors.New("StaleOnError must not be negative")
+		}
+		c.StaleOnError = d
+		return nil
+	}
+}
+
+// WithErrorTTL caches generation errors for d.
+func WithErrorTTL(d time.Duration) Option {
+	return func(c *Cache) error {
+		if d < 0 {
+			return errors.New("ErrorTTL must not be negative")
+		}
+		c.ErrorTTL = d
+		return nil
+	}
+}
+
+// WithOnHitInspect sets the hook inspecting values returned from the cache.
+func WithOnHitInspect(inspect func(key, value interface{}, meta EntryInfo) (time.Duration, bool)) Option {
+	return func(c *Cache) error {
+		c.OnHitInspect = inspect
+		return nil
+	}
+}
+
+// WithSecondaryKey indexes entries by a key extracted from their values.
+func WithSecondaryKey(extract func(value interface{}) interface{}) Option {
+	return func(c *Cache) error {
+		c.SecondaryKey = extract
+		return nil
+	}
+}
+
+// WithMaxWaiters caps the Gets waiting on one in-flight generation, answering the excess according to overflow.
+func WithMaxWaiters(n int, overflow OverflowPolicy) Option {
+	return func(c *Cache) error {
+		if n < 0 {
+			return errors.New("MaxWaiters must not be negative")
+		}
+		c.MaxWaiters = n
+		c.WaiterOverflow = overflow
+		return nil
+	}
+}
+
+// WithOverflowValue sets the value returned to callers turned away under OverflowDefault.
+func WithOverflowValue(value interface{}) Option {
+	return func(c *Cache) error {
+		c.OverflowValue = value
+		return nil
+	}
+}
+
+// WithCompactRatio rebuilds the internal map once it shrinks below ratio of its peak size.
+func WithCompactRatio(ratio float64) Option {
+	return func(c *Cache) error {
+		if ratio < 0 || ratio >= 1 {
+			return errors.New("CompactRatio must be in [0, 1)")
+		}
+		c.CompactRatio = ratio
+		return nil
+	}
+}
+
+// WithSizer replaces the reflection-based estimate of each value's storage cost.
+func WithSizer(sizer func(key, value interface{}) uint64) Option {
+	return func(c *Cache) error {
+		c.Sizer = sizer
+		return nil
+	}
+}
+
+// WithPurgeInterval purges expired entries in the background every interval until Close is called.
+func WithPurgeInterval(interval time.Duration) Option {
+	return func(c *Cache) error {
+		if interval < 0 {
+			return errors.New("PurgeInterval must not be negative")
+		}
+		c.PurgeInterval = interval
+		return nil
+	}
+}
+
+// WithFingerprint lets refreshes that produce an unchanged value skip re-estimating its size.
+func WithFingerprint(fingerprint func(value interface{}) uint64) Option {
+	return func(c *Cache) error {
+		c.Fingerprint = fingerprint
+		return nil
+	}
+}
+
+// WithOnEvict sets the callback invoked with each value leaving the cache.
+func WithOnEvict(onEvict func(key, value interface{})) Option {
+	return func(c *Cache) error {
+		c.OnEvict = onEvict
+		return nil
+	}
+}
+
+// WithOnEvictCandidate lets the application veto the eviction of specific entries, honoring at most budget vetoes per prune.
+func WithOnEvictCandidate(decide func(key interface{}, meta EntryInfo) EvictDecision, budget int) Option {
+	return func(c *Cache) error {
+		if budget < 0 {
+			return errors.New("EvictVetoBudget must not be negative")
+		}
+		c.OnEvictCandidate = decide
+		c.EvictVetoBudget = budget
+		return nil
+	}
+}
+
+// WithAppendLimit bounds the number of values retained under a key by Append.
+func WithAppendLimit(n int) Option {
+	return func(c *Cache) error {
+		if n < 0 {
+			return errors.New("AppendLimit must not be negative")
+		}
+		c.AppendLimit = n
+		return nil
+	}
+}
+
+// WithXFetch enables Refresh using probabilistic early expiration with the given beta instead of the half-TTL trigger.
+func WithXFetch(beta float64) Option {
+	return func(c *Cache) error {
+		if beta <= 0 {
+			return errors.New("RefreshBeta must be positive")
+		}
+		c.Refresh = true
+		c.RefreshBeta = beta
+		return nil
+	}
+}
+
+// WithRefreshFraction enables Refresh once entries are the given fraction of the way through their TTL.
+func WithRefreshFraction(fraction float64) Option {
+	return func(c *Cache) error {
+		if fraction <= 0 || fraction >= 1 {
+			return errors.New("RefreshFraction must be in (0, 1)")
+		}
+		c.Refresh = true
+		c.RefreshFraction = fraction
+		return nil
+	}
+}
+
+// WithRefreshAfter enables Refresh once entries are older than d, regardless of their TTL.
+func WithRefreshAfter(d time.Duration) Option {
+	return func(c *Cache) error {
+		if d <= 0 {
+			return errors.New("RefreshAfter must be positive")
+		}
+		c.Refresh = true
+		c.RefreshAfter = d
+		return nil
+	}
+}
+
+// WithAdmission sets the policy consulted before new keys displace existing entries, such as NewTinyLFU or NewWindowTinyLFU.
+func WithAdmission(policy AdmissionPolicy) Option {
+	return func(c *Cache) error {
+		c.Admission = policy
+		return nil
+	}
+}
+
+// WithCodec sets the Codec used to encode snapshots.
+func WithCodec(codec Codec) Option {
+	return func(c *Cache) error {
+		c.Codec = codec
+		return nil
+	}
+}
+
+// WithTrackOrigin records the origin of each entry for Info and Dump.
+func WithTrackOrigin() Option {
+	return func(c *Cache) error {
+		c.TrackOrigin = true
+		return nil
+	}
+}
+
+// WithStore places store behind the cache as a second tier.
+func WithStore(store SecondaryStore) Option {
+	return func(c *Cache) error {
+		c.Store = store
+		return nil
+	}
+}
+
+// WithEviction sets the policy choosing which entries are evicted, such as NewLRUPolicy, NewSIEVEPolicy or NewClockPolicy.
+func WithEviction(policy EvictionPolicy) Option {
+	return func(c *Cache) error {
+		c.Eviction = policy
+		return nil
+	}
+}
+
+// WithSchemaVersion tags snapshots with version, so that LoadFrom skips snapshots written under other versions.
+func WithSchemaVersion(version string) Option {
+	return func(c *Cache) error {
+		c.SchemaVersion = version
+		return nil
+	}
+}
+
+// WithScope qualifies keys shared through Store and snapshots with scope.
+func WithScope(scope string) Option {
+	return func(c *Cache) error {
+		c.Scope = scope
+		return nil
+	}
+}
+
+// WithOnHit calls fn for each Get answered by an existing entry.
+func WithOnHit(fn func(key interface{})) Option {
+	return func(c *Cache) error {
+		c.OnHit = fn
+		return nil
+	}
+}
+
+// WithOnMiss calls fn for each Get that starts a generation.
+func WithOnMiss(fn func(key interface{})) Option {
+	return func(c *Cache) error {
+		c.OnMiss = fn
+		return nil
+	}
+}
+
+// WithOnRefresh calls start and end as existing entries begin and finish regenerating. Either may be nil.
+func WithOnRefresh(start func(key interface{}), end func(key interface{}, err error)) Option {
+	return func(c *Cache) error {
+		c.OnRefreshStart = start
+		c.OnRefreshEnd = end
+		return nil
+	}
+}
+
+// WithHash replaces the default key hash used by KeyDistribution.
+func WithHash(hash func(key interface{}) uint64) Option {
+	return func(c *Cache) error {
+		c.Hash = hash
+		return nil
+	}
+}
+
+// WithLifecycle calls store as each value is stored and release once it is no longer held. Either may be nil.
+func WithLifecycle(store, release func(key, value interface{})) Option {
+	return func(c *Cache) error {
+		c.OnStore = store
+		c.OnRelease = release
+		return nil
+	}
+}
+
+// WithMaxConcurrentGenerations bounds the number of generators running at once.
+func WithMaxConcurrentGenerations(n int) Option {
+	return func(c *Cache) error {
+		if n < 1 {
+			return errors.New("MaxConcurrentGenerations must be positive")
+		}
+		c.MaxConcurrentGenerations = n
+		return nil
+	}
+}
+
+// WithSetPolicy decides between Set and an in-flight generation of the same key.
+func WithSetPolicy(policy SetPolicy) Option {
+	return func(c *Cache) error {
+		c.SetPolicy = policy
+		return nil
+	}
+}
+
+// WithCancelAbandoned cancels GetContext generations once every waiting caller has timed out.
+func WithCancelAbandoned() Option {
+	return func(c *Cache) error {
+		c.CancelAbandoned = true
+		return nil
+	}
+}
+
+// WithMaxRefreshFailures evicts entries after n consecutive failed refreshes, calling onExhausted (which may be nil).
+func WithMaxRefreshFailures(n int, onExhausted func(key interface{}, err error)) Option {
+	return func(c *Cache) error {
+		if n < 1 {
+			return errors.New("MaxRefreshFailures must be positive")
+		}
+		c.MaxRefreshFailures = n
+		c.OnRefreshExhausted = onExhausted
+		return nil
+	}
+}
+
+// WithBackgroundRefresh refreshes entries created by Get from a background goroutine, checking every interval,
+// independently of whether they are used.
+func WithBackgroundRefresh(interval time.Duration) Option {
+	return func(c *Cache) error {
+		if interval <= 0 {
+			return errors.New("RefreshInterval must be positive")
+		}
+		c.RefreshInterval = interval
+		return nil
+	}
+}
+
+// WithTTLJitter scales each entry's TTL by a random factor within ±jitter.
+func WithTTLJitter(jitter float64) Option {
+	return func(c *Cache) error {
+		if jitter < 0 || jitter >= 1 {
+			return errors.New("TTLJitter must be in [0, 1)")
+		}
+		c.TTLJitter = jitter
+		return nil
+	}
+}
+
+// WithOnExpire calls fn with each value purged because it expired.
+func WithOnExpire(fn func(key, value interface{})) Option {
+	return func(c *Cache) error {
+		c.OnExpire = fn
+		return nil
+	}
+}
+
+// WithRetry retries failed generations according to policy.
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Cache) error {
+		if policy.Backoff < 0 || policy.MaxBackoff < 0 {
+			return errors.New("Retry backoff must not be negative")
+		}
+		c.Retry = &policy
+		return nil
+	}
+}
+
+// WithHedging starts a second generator call for a key whose first call takes longer than delay. See HedgeDelay.
+func WithHedging(delay time.Duration) Option {
+	return func(c *Cache) error {
+		if delay <= 0 {
+			return errors.New("Hedge delay must be positive")
+		}
+		c.HedgeDelay = delay
+		return nil
+	}
+}
+
+// WithRateLimit throttles generator calls with limit, failing refused generations with ErrThrottled. See RateLimit.
+func WithRateLimit(limit RateLimiter) Option {
+	return func(c *Cache) error {
+		if limit == nil {
+			return errors.New("Rate limiter must not be nil")
+		}
+		c.RateLimit = limit
+		return nil
+	}
+}
+
+// WithDeterministicEviction makes the choice of entries to evict reproducible, for tests. See DeterministicEviction.
+func WithDeterministicEviction() Option {
+	return func(c *Cache) error {
+		c.DeterministicEviction = true
+		return nil
+	}
+}
+
+// WithAudit verifies one cached entry against generate every interval in the background. See Audit.
+func WithAudit(interval time.Duration, generate func(key interface{}) (interface{}, error)) Option {
+	return func(c *Cache) error {
+		if interval <= 0 {
+			return errors.New("Audit interval must be positive")
+		}
+		if generate == nil {
+			return errors.New("Audit generator must not be nil")
+		}
+		c.Audit = &Audit{Interval: interval, Generate: generate}
+		return nil
+	}
+}
+
+// WithZeroTTL sets what a TTL of zero means. See ZeroTTL.
+func WithZeroTTL(mode ZeroTTLMode) Option {
+	return func(c *Cache) error {
+		if mode != ZeroTTLNoCache && mode != ZeroTTLForever {
+			return errors.New("Unknown ZeroTTLMode")
+		}
+		c.ZeroTTL = mode
+		return nil
+	}
+}
+
+// WithMemoryPressure sheds entries while the heap exceeds highWater, checking it every second. See MemoryPressure.
+func WithMemoryPressure(highWater uint64) Option {
+	return func(c *Cache) error {
+		if highWater == 0 {
+			return errors.New("Memory high-water mark must be positive")
+		}
+		c.MemoryPressure = &MemoryPressure{HighWater: highWater}
+		return nil
+	}
+}
+
+// WithCircuitBreaker stops calling generators after threshold consecutive failures, probing again after cooldown.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Cache) error {
+		if threshold < 1 || cooldown <= 0 {
+			return errors.New("Circuit breaker threshold and cooldown must be positive")
+		}
+		c.Breaker = &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+		return nil
+	}
+}
+
+// WithClock reads time from clock instead of the system clock.
+func WithClock(clock Clock) Option {
+	return func(c *Cache) error {
+		if clock == nil {
+			return errors.New("Clock must not be nil")
+		}
+		c.Clock = clock
+		return nil
+	}
+}
+
+// WithCardinality tracks the number of distinct keys requested, reported by KeyCardinality.
+func WithCardinality() Option {
+	return func(c *Cache) error {
+		c.TrackCardinality = true
+		return nil
+	}
+}
+
+// WithOnPanic recovers generator panics as with Recover, calling fn with each panic's value and stack trace.
+func WithOnPanic(fn func(key interface{}, recovered interface{}, stack []byte)) Option {
+	return func(c *Cache) error {
+		c.Recover = true
+		c.OnPanic = fn
+		return nil
+	}
+}
+
+// WithAutoTimeout times Gets out after the SuggestedTimeout for their namespace, kept within min and max.
+func WithAutoTimeout(min, max time.Duration) Option {
+	return func(c *Cache) error {
+		if min < 0 || max < 0 || (max > 0 && min > max) {
+			return errors.New("Timeout bounds must not be negative and min must not exceed max")
+		}
+		c.AutoTimeout = &TimeoutBounds{Min: min, Max: max}
+		return nil
+	}
+}
+
+// WithMinGenerationTime caches only values that took at least d to generate, or perNamespace[ns] for Gets in
+// namespace ns. See MinGenerationTime.
+func WithMinGenerationTime(d time.Duration, perNamespace map[string]time.Duration) Option {
+	return func(c *Cache) error {
+		if d < 0 {
+			return errors.New("Minimum generation time must not be negative")
+		}
+		for _, nd := range perNamespace {
+			if nd < 0 {
+				return errors.New("Minimum generation time must not be negative")
+			}
+		}
+		c.MinGenerationTime = d
+		c.NamespaceMinGenerationTime = perNamespace
+		return nil
+	}
+}
+
+// WithExpvar publishes the cache's metrics under name with PublishExpvar, once the rest of the
+// configuration is known to be valid, so that a failing NewCache doesn't claim the name.
+func WithExpvar(name string) Option {
+	return func(c *Cache) error {
+		c.expvarName = name
+		return nil
+	}
+}
+
+// WithWriteBehind queues Store writes in the background, retrying each failed write up to retries times.
+func WithWriteBehind(queueSize, retries int, backoff time.Duration) Option {
+	return func(c *Cache) error {
+		if queueSize < 0 || retries < 0 || backoff < 0 {
+			return errors.New("Write-behind settings must not be negative")
+		}
+		c.WriteBehind = &WriteBehind{QueueSize: queueSize, MaxRetries: retries, Backoff: backoff}
+		return nil
+	}
+}
+
+// WithMaxKeySize stops keys larger than bytes from being cached.
+func WithMaxKeySize(bytes int) Option {
+	return func(c *Cache) error {
+		if bytes < 0 {
+			return errors.New("Max key size must not be negative")
+		}
+		c.MaxKeySize = bytes
+		return nil
+	}
+}
+
+// WithCompression keeps string and []byte values of at least threshold bytes compressed with compressor while cached.
+func WithCompression(compressor Compressor, threshold int) Option {
+	return func(c *Cache) error {
+		if compressor == nil || threshold < 0 {
+			return errors.New("Compression needs a Compressor and a threshold that is not negative")
+		}
+		c.Compression = &Compression{Compressor: compressor, Threshold: threshold}
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// Source identifies how an entry's current value was produced.
+type Source int
+
+const (
+	// SourceMiss values were generated by a Get that found no entry.
+	SourceMiss Source = iota
+	// SourceRefresh values were regenerated in place of an earlier value.
+	SourceRefresh
+	// SourceWarm values were generated by WarmGraph.
+	SourceWarm
+	// SourceImport values were loaded by LoadFrom.
+	SourceImport
+	// SourceSet values were stored directly with Set, Append or Increment.
+	SourceSet
+	// SourcePrefetch values were generated by FollowPeers after another cache announced a miss.
+	SourcePrefetch
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceMiss:
+		return "miss"
+	case SourceRefresh:
+		return "refresh"
+	case SourceWarm:
+		return "warm"
+	case SourceImport:
+		return "import"
+	case SourceSet:
+		return "set"
+	case SourcePrefetch:
+		return "prefetch"
+	}
+	return fmt.Sprintf("Source(%d)", int(s))
+}
+
+// origin records where an entry came from when TrackOrigin is set.
+type origin struct {
+	source    Source
+	namespace string
+	caller    uintptr // Program counter of the call that created the entry
+}
+
+// callerPC returns the program counter skip frames above the function calling callerPC, or zero if TrackOrigin is unset.
+func (c *Cache) callerPC(skip int) uintptr {
+	if !c.TrackOrigin {
+		return 0
+	}
+	var pc [1]uintptr
+	runtime.Callers(skip+2, pc[:]) // Skip runtime.Callers and callerPC
+	return pc[0]
+}
+
+// newOrigin returns the origin for a new entry, or nil if TrackOrigin is unset.
+func (c *Cache) newOrigin(source Source, namespace string, caller uintptr) *origin {
+	if !c.TrackOrigin {
+		return nil
+	}
+	return &origin{source: source, namespace: namespace, caller: caller}
+}
+
+// describeCaller formats a program counter as "function (file:line)".
+func describeCaller(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line)
+}
+
+// Meta returns the bookkeeping held for key.
+//
+// Deprecated: Use Info.
+func (c *Cache) Meta(key interface{}) (EntryInfo, bool) {
+	return c.Info(key)
+}
+
+// Dump writes a line describing each entry to w, for debugging.
+func (c *Cache) Dump(w io.Writer) error {
+	type dumped struct {
+		key  interface{}
+		info EntryInfo
+	}
+	c.lockMap()
+	entries := make([]dumped, 0, len(c.data))
+	for key, item := range c.data {
+		entries = append(entries, dumped{key, c.info(item)})
+	}
+	c.unlock()
+	for _, e := range entries {
+		_, err := fmt.Fprintf(w, "%v\tage=%v ttl=%v size=%d source=%v namespace=%q caller=%q",
+			e.key, e.info.Age.Round(time.Millisecond), e.info.TTL, e.info.Size, e.info.Source, e.info.Namespace, e.info.Caller)
+		if err == nil && len(e.info.Labels) > 0 {
+			_, err = fmt.Fprintf(w, " labels=%v", e.info.Labels) // fmt prints maps in key order
+		}
+		if err == nil {
+			_, err = io.WriteString(w, "\n")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
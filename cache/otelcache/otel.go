@@ -0,0 +1,65 @@
+/*
+Package otelcache records flowcache lookups in OpenTelemetry traces. Each lookup gets a span stamped with
+whether it hit the cache and whether the value was served stale and by how much, and each generator call
+it causes gets a child span, so traces show which requests were slowed by a cold cache:
+
+	result := otelcache.Get(ctx, c, key, cache.GetOptions{TTL: time.Minute}, generate)
+
+Callers with a span of their own can annotate it with Annotate instead, and wrap generators passed to
+other Get methods with Generator.
+*/
+package otelcache
+
+import (
+	"context"
+
+	"github.com/ericpauley/flowcache/cache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/ericpauley/flowcache/cache/otelcache"
+
+// Span attribute keys set by Annotate.
+const (
+	HitKey         = attribute.Key("cache.hit")          // Whether the lookup was answered without starting a generation
+	StaleKey       = attribute.Key("cache.stale")        // Whether the value was served past its TTL
+	StalenessMsKey = attribute.Key("cache.staleness_ms") // How far past its TTL, in milliseconds
+)
+
+// Get looks up key with c.GetResult and waits for the result inside a span started from ctx and annotated with Annotate.
+func Get(ctx context.Context, c *cache.Cache, key interface{}, opts cache.GetOptions, generate func(interface{}) (interface{}, error)) cache.Result {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "flowcache.Get")
+	defer span.End()
+	result := c.GetResult(key, opts, Generator(ctx, generate))()
+	Annotate(span, result)
+	return result
+}
+
+// Generator wraps generate so that each call runs in a "flowcache.generate" span started from ctx,
+// which should be the context of the caller whose Get the generator is passed to.
+func Generator(ctx context.Context, generate func(interface{}) (interface{}, error)) func(interface{}) (interface{}, error) {
+	return func(key interface{}) (interface{}, error) {
+		_, span := otel.Tracer(tracerName).Start(ctx, "flowcache.generate")
+		defer span.End()
+		val, err := generate(key)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return val, err
+	}
+}
+
+// Annotate records how result was served on span, marking the span failed if result holds an error.
+func Annotate(span trace.Span, result cache.Result) {
+	span.SetAttributes(HitKey.Bool(result.Hit))
+	if result.Err != nil {
+		span.RecordError(result.Err)
+		span.SetStatus(codes.Error, result.Err.Error())
+		return
+	}
+	span.SetAttributes(StaleKey.Bool(result.Stale()), StalenessMsKey.Int64(result.Staleness.Milliseconds()))
+}
@@ -0,0 +1,47 @@
+package otelcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestGet(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	c := &cache.Cache{MaxSize: 10}
+	result := Get(context.Background(), c, "A", cache.GetOptions{TTL: time.Minute}, func(key interface{}) (interface{}, error) {
+		return "a", nil
+	})
+	if result.Value != "a" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "flowcache.generate" || spans[0].Parent().SpanID() != spans[1].SpanContext().SpanID() {
+		t.Fatalf("Expected a generate span within the Get span, got %v", spans)
+	}
+	if attrs := attributes(spans[1]); attrs[HitKey].AsBool() || attrs[StaleKey].AsBool() || attrs[StaleKey].Type() == attribute.INVALID {
+		t.Fatalf("Unexpected attributes %v", attrs)
+	}
+	Get(context.Background(), c, "A", cache.GetOptions{TTL: time.Minute}, func(key interface{}) (interface{}, error) {
+		return "b", nil
+	})
+	spans = recorder.Ended()[2:]
+	if len(spans) != 1 || !attributes(spans[0])[HitKey].AsBool() {
+		t.Fatalf("Expected a single hit span, got %v", spans)
+	}
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
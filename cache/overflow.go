@@ -0,0 +1,35 @@
+package cache
+
+import "errors"
+
+// ErrOverloaded is returned by Get when too many callers are already waiting on a generation.
+var ErrOverloaded = errors.New("Too many waiters for cache key")
+
+// OverflowPolicy determines how Get answers callers turned away by MaxWaiters.
+type OverflowPolicy int
+
+const (
+	// OverflowError fails the Get with ErrOverloaded.
+	OverflowError OverflowPolicy = iota
+	// OverflowStale returns the entry's previous value if it has one, and ErrOverloaded otherwise.
+	OverflowStale
+	// OverflowDefault returns the cache's OverflowValue.
+	OverflowDefault
+)
+
+// overflow answers a Get that exceeded MaxWaiters. The cache must be locked.
+func (c *Cache) overflow(item *cacheItem) func() (interface{}, error) {
+	var val interface{}
+	err := ErrOverloaded
+	switch c.WaiterOverflow {
+	case OverflowStale:
+		if !item.created.IsZero() && item.err == nil {
+			val, err = c.expanded(item.val), nil
+		}
+	case OverflowDefault:
+		val, err = c.OverflowValue, nil
+	}
+	return func() (interface{}, error) {
+		return val, err
+	}
+}
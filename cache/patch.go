@@ -0,0 +1,48 @@
+package cache
+
+import "time"
+
+// Patch replaces the value cached under key with fn's result, without invoking a generator, and reports
+// whether a completed, unexpired and successful entry was found. The entry's expiry is left untouched.
+//
+// fn is called with the cache locked, so no other operation observes the entry between the read and the write;
+// it must not call back into the cache. fn may modify and return the current value rather than copying it,
+// but values already returned by Get share that memory. The new value is sized under the lock and written
+// through to Store, if set.
+func (c *Cache) Patch(key interface{}, fn func(current interface{}) interface{}) bool {
+	val, ttl, ok := c.patch(key, fn)
+	if !ok {
+		return false
+	}
+	if ttl > 0 {
+		c.storeWrite(key, val, ttl)
+	}
+	c.replaced(key)
+	return true
+}
+
+// patch applies fn to the entry under key for Patch, returning the new value and the entry's remaining TTL.
+// The lock is released even if fn panics.
+func (c *Cache) patch(key interface{}, fn func(current interface{}) interface{}) (interface{}, time.Duration, bool) {
+	c.lockMutable()
+	defer c.unlock()
+	item, ok := c.data[key]
+	if !ok || !c.visible(item) {
+		return nil, 0, false
+	}
+	item.val = c.compress(fn(c.expanded(item.val)))
+	size := item.cost
+	if size == 0 {
+		size = c.sizeOf(key, item.val)
+	}
+	c.storage -= item.size
+	c.storage += size
+	item.size = size
+	item.fingerprint = 0
+	c.index(key, item)
+	c.touched(key, item)
+	c.published(key, item)
+	val, ttl := c.expanded(item.val), c.remaining(item)
+	c.pruneWhile(c.overLimit)
+	return val, ttl, true
+}
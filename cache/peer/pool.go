@@ -0,0 +1,159 @@
+/*
+Package peer spreads a flowcache across a cluster in the manner of groupcache: a consistent-hash Ring
+assigns each key to one owning process, which is the only one to call the generator for it. Other
+processes fetch the value from the owner over HTTP and cache it locally, so a fleet of replicas makes
+one origin call per key rather than one per replica.
+
+	pool := peer.NewPool("http://10.0.0.1:8080", c, time.Minute, generate)
+	pool.SetPeers("http://10.0.0.1:8080", "http://10.0.0.2:8080")
+	http.Handle(peer.DefaultBasePath, pool)
+	val, err := pool.Get("key")
+
+Every process must use the same generator, TTL and peer list. Values travel between peers encoded with
+Codec, gob by default, so their concrete types must be registered with gob.Register.
+*/
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+)
+
+// DefaultBasePath is the path under which a Pool serves peer requests unless BasePath is set.
+const DefaultBasePath = "/_flowcache/"
+
+// Pool fetches keys through the peer that owns them, and answers other peers' requests for keys it owns.
+// It is an http.Handler to be served under BasePath.
+type Pool struct {
+	Self     string       // This process's base URL, as it appears in the peer list
+	BasePath string       // Path peer requests are served under; DefaultBasePath if empty
+	Codec    cache.Codec  // Encodes values sent between peers; cache.GobCodec if nil
+	Client   *http.Client // Used to fetch from peers; http.DefaultClient if nil
+	Replicas int          // Points per peer on the ring; see NewRing
+
+	cache    *cache.Cache
+	ttl      time.Duration
+	generate func(key string) (interface{}, error)
+
+	mutex sync.RWMutex
+	ring  *Ring
+}
+
+// NewPool creates a pool caching values from generate in c for ttl. It owns every key until SetPeers is called.
+func NewPool(self string, c *cache.Cache, ttl time.Duration, generate func(key string) (interface{}, error)) *Pool {
+	return &Pool{Self: self, cache: c, ttl: ttl, generate: generate, ring: NewRing(0)}
+}
+
+// SetPeers replaces the peer list, which should include Self.
+func (p *Pool) SetPeers(peers ...string) {
+	ring := NewRing(p.Replicas, peers...)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.ring = ring
+}
+
+// Owner returns the peer owning key.
+func (p *Pool) Owner(key string) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.ring.Owner(key)
+}
+
+// Get returns the value for key from the local cache, fetching a missing key from its owner or, if this
+// process owns it, calling the generator. If the owner can't be reached the generator is called locally.
+func (p *Pool) Get(key string) (interface{}, error) {
+	owner := p.Owner(key)
+	if owner == "" || owner == p.Self {
+		return p.local(key)
+	}
+	return p.cache.Get(key, p.ttl, func(interface{}) (interface{}, error) {
+		val, err := p.fetch(owner, key)
+		if errors.Is(err, errUnreachable) {
+			return p.generate(key)
+		}
+		return val, err
+	})()
+}
+
+// local gets key from the local cache, generating it here if missing.
+func (p *Pool) local(key string) (interface{}, error) {
+	return p.cache.Get(key, p.ttl, func(interface{}) (interface{}, error) {
+		return p.generate(key)
+	})()
+}
+
+var errUnreachable = errors.New("Peer unreachable")
+
+// peerError is an error returned by a peer's generator, passed on as the owner reported it.
+type peerError string
+
+func (e peerError) Error() string { return string(e) }
+
+func (p *Pool) basePath() string {
+	if p.BasePath != "" {
+		return p.BasePath
+	}
+	return DefaultBasePath
+}
+
+func (p *Pool) codec() cache.Codec {
+	if p.Codec != nil {
+		return p.Codec
+	}
+	return cache.GobCodec{}
+}
+
+// fetch requests key from owner.
+func (p *Pool) fetch(owner, key string) (interface{}, error) {
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(strings.TrimSuffix(owner, "/") + p.basePath() + url.PathEscape(key))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return p.codec().Decode(body)
+	case http.StatusBadGateway:
+		return nil, peerError(strings.TrimSpace(string(body)))
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnreachable, resp.Status)
+	}
+}
+
+// ServeHTTP answers a peer's request for a key, getting it from the local cache and generating it here if
+// missing, whichever peer the local ring says owns it, so that peers with differing lists can't loop.
+func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, p.basePath()) {
+		http.NotFound(w, r)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, p.basePath())
+	val, err := p.local(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	data, err := p.codec().Encode(val)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
@@ -0,0 +1,70 @@
+package peer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+)
+
+func TestRing(t *testing.T) {
+	ring := NewRing(0, "a", "b", "c")
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[ring.Owner(fmt.Sprint(i))]++
+	}
+	for _, peer := range []string{"a", "b", "c"} {
+		if counts[peer] < 500 {
+			t.Fatalf("Keys unevenly spread: %v", counts)
+		}
+	}
+	if NewRing(0).Owner("key") != "" {
+		t.Fatal("Empty ring returned an owner")
+	}
+}
+
+func TestPool(t *testing.T) {
+	var calls int32
+	generate := func(key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		if key == "bad" {
+			return nil, fmt.Errorf("No value for %s", key)
+		}
+		return "value of " + key, nil
+	}
+	pools := make([]*Pool, 2)
+	var urls []string
+	for i := range pools {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pools[i].ServeHTTP(w, r)
+		}))
+		defer server.Close()
+		pools[i] = NewPool(server.URL, &cache.Cache{MaxSize: 100}, time.Minute, generate)
+		urls = append(urls, server.URL)
+	}
+	for _, pool := range pools {
+		pool.SetPeers(urls...)
+	}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprint("key", i)
+		for _, pool := range pools {
+			val, err := pool.Get(key)
+			if err != nil || val != "value of "+key {
+				t.Fatalf("Unexpected result %v, %v", val, err)
+			}
+		}
+	}
+	if calls != 20 {
+		t.Fatalf("Generator called %d times for 20 keys", calls)
+	}
+	for _, pool := range pools {
+		if _, err := pool.Get("bad"); err == nil || err.Error() != "No value for bad" {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package peer
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// defaultReplicas is the number of points each peer gets on the ring, evening out how keys are spread.
+const defaultReplicas = 50
+
+// Ring assigns keys to peers by consistent hashing, so that adding or removing a peer moves only
+// the keys it gains or loses. A Ring is immutable once built.
+type Ring struct {
+	hashes []uint32
+	peers  map[uint32]string
+}
+
+// NewRing builds a ring of peers, giving each the given number of points, or 50 if replicas is not positive.
+func NewRing(replicas int, peers ...string) *Ring {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	r := &Ring{peers: make(map[uint32]string)}
+	for _, peer := range peers {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			if _, taken := r.peers[h]; taken {
+				continue
+			}
+			r.hashes = append(r.hashes, h)
+			r.peers[h] = peer
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Owner returns the peer owning key, or "" if the ring is empty.
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.peers[r.hashes[i]]
+}
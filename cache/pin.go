@@ -0,0 +1,41 @@
+package cache
+
+// Pin exempts key from eviction: neither MaxSize nor storage limits will prune it, though it still expires,
+// refreshes and can be deleted as usual. The pin belongs to the key rather than its current entry, so it also
+// covers values generated for the key later, until Unpin is called. A cache whose entries are all pinned may
+// exceed its limits.
+func (c *Cache) Pin(key interface{}) {
+	c.lockMap()
+	defer c.unlock()
+	c.pin(key)
+}
+
+// pin records a pin on key. The cache must be locked.
+func (c *Cache) pin(key interface{}) {
+	if c.pinned == nil {
+		c.pinned = make(map[interface{}]bool)
+	}
+	c.recount(key, c.data[key], func() { c.pinned[key] = true })
+}
+
+// Unpin lets key be evicted again, reporting whether it was pinned. Limits exceeded while it was pinned
+// are enforced from the next insert.
+func (c *Cache) Unpin(key interface{}) bool {
+	c.lockMap()
+	defer c.unlock()
+	ok := c.pinned[key]
+	c.recount(key, c.data[key], func() { delete(c.pinned, key) })
+	return ok
+}
+
+// Pinned reports whether key is pinned.
+func (c *Cache) Pinned(key interface{}) bool {
+	c.lockMap()
+	defer c.unlock()
+	return c.pinned[key]
+}
+
+// evictable reports whether the pruner may choose key, which is neither pinned nor in vetoed. The cache must be locked.
+func (c *Cache) evictable(key interface{}, vetoed map[interface{}]bool) bool {
+	return !vetoed[key] && !c.pinned[key]
+}
@@ -0,0 +1,293 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// EvictionPolicy chooses which entry to evict when the cache is full, replacing the default sampled LRU.
+//
+// The cache calls it with its lock held, so implementations need no locking of their own,
+// must not call back into the cache, and must not be shared between caches.
+type EvictionPolicy interface {
+	OnAdd(key interface{})    // key was inserted
+	OnAccess(key interface{}) // key was read or updated
+	OnRemove(key interface{}) // key left the cache
+	// Victim returns the key that should be evicted next, and false if the policy tracks no keys.
+	// It must not forget the key; the cache calls OnRemove if it is evicted.
+	Victim() (interface{}, bool)
+}
+
+// touched records a use of an entry, informing Eviction if the entry is still cached. The cache must be locked.
+func (c *Cache) touched(key interface{}, item *cacheItem) {
+	item.lastUsed = c.now()
+	if c.Eviction != nil && c.data[key] == item {
+		c.Eviction.OnAccess(key)
+	}
+}
+
+// listPolicy implements LRU and FIFO ordering with a list kept newest first.
+type listPolicy struct {
+	order       *list.List
+	elements    map[interface{}]*list.Element
+	moveOnTouch bool
+}
+
+// NewLRUPolicy returns a policy evicting the least recently used entry.
+func NewLRUPolicy() EvictionPolicy {
+	return &listPolicy{order: list.New(), elements: make(map[interface{}]*list.Element), moveOnTouch: true}
+}
+
+// NewFIFOPolicy returns a policy evicting the oldest entry, regardless of use.
+func NewFIFOPolicy() EvictionPolicy {
+	return &listPolicy{order: list.New(), elements: make(map[interface{}]*list.Element)}
+}
+
+func (p *listPolicy) OnAdd(key interface{}) {
+	if e, ok := p.elements[key]; ok {
+		p.order.MoveToFront(e)
+		return
+	}
+	p.elements[key] = p.order.PushFront(key)
+}
+
+func (p *listPolicy) OnAccess(key interface{}) {
+	if e, ok := p.elements[key]; ok && p.moveOnTouch {
+		p.order.MoveToFront(e)
+	}
+}
+
+func (p *listPolicy) OnRemove(key interface{}) {
+	if e, ok := p.elements[key]; ok {
+		p.order.Remove(e)
+		delete(p.elements, key)
+	}
+}
+
+func (p *listPolicy) Victim() (interface{}, bool) {
+	if e := p.order.Back(); e != nil {
+		return e.Value, true
+	}
+	return nil, false
+}
+
+// lfuEntry is a key's position in the LFU heap.
+type lfuEntry struct {
+	key   interface{}
+	count uint64
+	seq   uint64 // Breaks ties in favor of evicting the least recently used
+	index int
+}
+
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].seq < h[j].seq
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// lfuPolicy evicts the least frequently used entry.
+type lfuPolicy struct {
+	heap    lfuHeap
+	entries map[interface{}]*lfuEntry
+	seq     uint64
+}
+
+// NewLFUPolicy returns a policy evicting the least frequently used entry, the least recently used among equals.
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{entries: make(map[interface{}]*lfuEntry)}
+}
+
+func (p *lfuPolicy) OnAdd(key interface{}) {
+	if _, ok := p.entries[key]; ok {
+		p.OnAccess(key)
+		return
+	}
+	p.seq++
+	e := &lfuEntry{key: key, count: 1, seq: p.seq}
+	p.entries[key] = e
+	heap.Push(&p.heap, e)
+}
+
+func (p *lfuPolicy) OnAccess(key interface{}) {
+	if e, ok := p.entries[key]; ok {
+		p.seq++
+		e.count++
+		e.seq = p.seq
+		heap.Fix(&p.heap, e.index)
+	}
+}
+
+func (p *lfuPolicy) OnRemove(key interface{}) {
+	if e, ok := p.entries[key]; ok {
+		heap.Remove(&p.heap, e.index)
+		delete(p.entries, key)
+	}
+}
+
+func (p *lfuPolicy) Victim() (interface{}, bool) {
+	if len(p.heap) == 0 {
+		return nil, false
+	}
+	return p.heap[0].key, true
+}
+
+// sievePolicy implements SIEVE (Zhang et al., NSDI 2024): a FIFO queue where a hand sweeping from oldest
+// to newest spares entries used since it last passed them.
+type sievePolicy struct {
+	order    *list.List // Newest first; values are *sieveEntry
+	elements map[interface{}]*list.Element
+	hand     *list.Element
+}
+
+type sieveEntry struct {
+	key     interface{}
+	visited bool
+}
+
+// NewSIEVEPolicy returns a policy implementing the SIEVE algorithm, which approaches LRU hit ratios
+// while only marking entries on access.
+func NewSIEVEPolicy() EvictionPolicy {
+	return &sievePolicy{order: list.New(), elements: make(map[interface{}]*list.Element)}
+}
+
+func (p *sievePolicy) OnAdd(key interface{}) {
+	if _, ok := p.elements[key]; ok {
+		p.OnAccess(key)
+		return
+	}
+	p.elements[key] = p.order.PushFront(&sieveEntry{key: key})
+}
+
+func (p *sievePolicy) OnAccess(key interface{}) {
+	if e, ok := p.elements[key]; ok {
+		e.Value.(*sieveEntry).visited = true
+	}
+}
+
+func (p *sievePolicy) OnRemove(key interface{}) {
+	e, ok := p.elements[key]
+	if !ok {
+		return
+	}
+	if p.hand == e {
+		p.hand = e.Prev()
+	}
+	p.order.Remove(e)
+	delete(p.elements, key)
+}
+
+func (p *sievePolicy) Victim() (interface{}, bool) {
+	if p.order.Len() == 0 {
+		return nil, false
+	}
+	e := p.hand
+	if e == nil {
+		e = p.order.Back()
+	}
+	for e.Value.(*sieveEntry).visited {
+		e.Value.(*sieveEntry).visited = false
+		if e = e.Prev(); e == nil {
+			e = p.order.Back()
+		}
+	}
+	p.hand = e
+	return e.Value.(*sieveEntry).key, true
+}
+
+// clockPolicy implements CLOCK, or second chance: entries sit on a ring swept by a hand that spares, once,
+// entries referenced since it last passed them. Unlike SIEVE, new entries join just behind the hand.
+type clockPolicy struct {
+	ring     *list.List // In hand order, wrapping from back to front; values are *clockEntry
+	elements map[interface{}]*list.Element
+	hand     *list.Element
+	sweep    int
+}
+
+type clockEntry struct {
+	key        interface{}
+	referenced bool
+}
+
+// NewClockPolicy returns a policy implementing CLOCK, which approximates LRU while only marking entries on
+// access. Each eviction advances the hand past at most sweep referenced entries, evicting the entry it stops
+// at even if referenced, bounding the work done under the cache's lock; sweep <= 0 allows a full revolution.
+func NewClockPolicy(sweep int) EvictionPolicy {
+	return &clockPolicy{ring: list.New(), elements: make(map[interface{}]*list.Element), sweep: sweep}
+}
+
+func (p *clockPolicy) OnAdd(key interface{}) {
+	if _, ok := p.elements[key]; ok {
+		p.OnAccess(key)
+		return
+	}
+	e := &clockEntry{key: key}
+	if p.hand == nil {
+		p.elements[key] = p.ring.PushBack(e)
+	} else {
+		p.elements[key] = p.ring.InsertBefore(e, p.hand)
+	}
+}
+
+func (p *clockPolicy) OnAccess(key interface{}) {
+	if e, ok := p.elements[key]; ok {
+		e.Value.(*clockEntry).referenced = true
+	}
+}
+
+func (p *clockPolicy) OnRemove(key interface{}) {
+	e, ok := p.elements[key]
+	if !ok {
+		return
+	}
+	if p.hand == e {
+		if p.hand = p.next(e); p.hand == e {
+			p.hand = nil
+		}
+	}
+	p.ring.Remove(e)
+	delete(p.elements, key)
+}
+
+func (p *clockPolicy) Victim() (interface{}, bool) {
+	if p.ring.Len() == 0 {
+		return nil, false
+	}
+	e := p.hand
+	if e == nil {
+		e = p.ring.Front()
+	}
+	// A full revolution clears every bit, so the unbounded sweep ends there at the latest
+	for i := 0; (p.sweep <= 0 || i < p.sweep) && e.Value.(*clockEntry).referenced; i++ {
+		e.Value.(*clockEntry).referenced = false
+		e = p.next(e)
+	}
+	p.hand = e
+	return e.Value.(*clockEntry).key, true
+}
+
+// next returns the element after e on the ring.
+func (p *clockPolicy) next(e *list.Element) *list.Element {
+	if n := e.Next(); n != nil {
+		return n
+	}
+	return p.ring.Front()
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultPrefetchQueue bounds the announced keys waiting to be prefetched when FollowPeers is given no queue size.
+const defaultPrefetchQueue = 256
+
+// announce publishes key through Announcer, if set, after a Get missed it.
+func (c *Cache) announce(key interface{}) {
+	if err := c.Announcer.Publish(c.scoped(key)); err != nil {
+		c.mutex.Lock()
+		c.stats.AnnounceErrors++
+		c.unlock()
+	}
+}
+
+// FollowPeers prefetches the keys other caches announce through Announcer, generating each with generate and ttl
+// unless it is already cached here. Prefetches run one at a time in the background at low priority: keys that
+// arrive while queueSize keys are already waiting are dropped. Prefetched entries are not announced again.
+// Prefetching continues until the returned function is called or the cache is closed.
+func (c *Cache) FollowPeers(ttl time.Duration, queueSize int, generate func(interface{}) (interface{}, error)) (cancel func(), err error) {
+	if c.Announcer == nil {
+		return nil, errors.New("No Announcer is set")
+	}
+	if queueSize <= 0 {
+		queueSize = defaultPrefetchQueue
+	}
+	queue := make(chan interface{}, queueSize)
+	stop := make(chan struct{})
+	unsubscribe, err := c.Announcer.Subscribe(func(key interface{}) {
+		key, ok := c.unscoped(key)
+		if !ok {
+			return // Announced by a cache under another Scope
+		}
+		select {
+		case queue <- key:
+		default:
+			c.mutex.Lock()
+			c.stats.PrefetchesDropped++
+			c.unlock()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case key := <-queue:
+				c.prefetch(key, ttl, generate)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			unsubscribe()
+			close(stop)
+		})
+	}
+	c.mutex.Lock()
+	c.listening = append(c.listening, cancel)
+	c.unlock()
+	return cancel, nil
+}
+
+// prefetch generates key if it isn't already cached, waiting for the generation to finish.
+func (c *Cache) prefetch(key interface{}, ttl time.Duration, generate func(interface{}) (interface{}, error)) {
+	c.lockMap()
+	_, cached := c.data[key]
+	closed := c.closed
+	if !cached && !closed {
+		c.stats.Prefetches++
+	}
+	c.unlock()
+	if cached || closed {
+		return
+	}
+	c.get(key, GetOptions{TTL: ttl, source: SourcePrefetch}, withoutContext(generate))()
+}
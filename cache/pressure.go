@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"runtime"
+	"time"
+)
+
+// MemoryPressure shrinks the storage limit of a cache as the process heap nears HighWater.
+// The limit stays between MinStorage and the configured storage limit. Once the heap crosses HighWater,
+// entries are evicted at once; the limit recovers as the heap shrinks.
+type MemoryPressure struct {
+	HighWater  uint64        // Heap bytes the process should stay under
+	MinStorage uint64        // Storage the cache keeps regardless of pressure
+	Interval   time.Duration // How often the heap is measured, one second if zero
+
+	// Heap, if set, reports the memory in use in place of runtime.MemStats.HeapAlloc,
+	// for example a container's cgroup usage.
+	Heap func() uint64
+}
+
+func (p *MemoryPressure) interval() time.Duration {
+	if p.Interval > 0 {
+		return p.Interval
+	}
+	return time.Second
+}
+
+func (p *MemoryPressure) heap() uint64 {
+	if p.Heap != nil {
+		return p.Heap()
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// relieve measures the heap and adjusts the storage limit under MemoryPressure.
+func (c *Cache) relieve() {
+	pressure := c.MemoryPressure
+	if pressure == nil || c.MaxStorage == 0 {
+		return
+	}
+	heap := pressure.heap() // Measured outside the lock; ReadMemStats stops the world
+	c.lockMap()
+	defer c.unlock()
+	ceiling := c.configuredStorage()
+	limit := ceiling
+	if heap > pressure.HighWater {
+		over := heap - pressure.HighWater
+		limit = 0
+		if c.storage > over {
+			limit = c.storage - over
+		}
+	} else if room := pressure.HighWater - heap; ceiling == 0 || c.storage+room < ceiling {
+		limit = c.storage + room
+	}
+	if limit < pressure.MinStorage {
+		limit = pressure.MinStorage
+	}
+	if ceiling != 0 && limit > ceiling {
+		limit = ceiling
+	}
+	if limit == 0 {
+		limit = 1 // Zero would lift the limit altogether
+	}
+	c.pressured = limit
+	c.pruneWhile(c.overLimit)
+	c.maybeCompact()
+}
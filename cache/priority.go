@@ -0,0 +1,76 @@
+package cache
+
+// SetPriority changes the eviction priority of the entry under key, reporting whether it was found.
+// See GetOptions.Priority.
+func (c *Cache) SetPriority(key interface{}, priority int) bool {
+	c.lockMap()
+	defer c.unlock()
+	item, ok := c.data[key]
+	if !ok {
+		return false
+	}
+	counted := c.counted(key, item)
+	if counted {
+		c.countPriority(item.priority, -1)
+	}
+	item.priority = priority
+	if counted {
+		c.countPriority(priority, 1)
+	}
+	return true
+}
+
+// counted reports whether item, held under key, is counted in priorities: it is settled and not pinned, so
+// the pruner may evict it. The cache must be locked.
+func (c *Cache) counted(key interface{}, item *cacheItem) bool {
+	return c.data[key] == item && !item.pending && !c.pinned[key]
+}
+
+// recount runs change, which may alter whether the entry under key is counted in priorities, keeping the
+// counts in step. The cache must be locked.
+func (c *Cache) recount(key interface{}, item *cacheItem, change func()) {
+	if item != nil && c.counted(key, item) {
+		c.countPriority(item.priority, -1)
+	}
+	change()
+	if item != nil && c.counted(key, item) {
+		c.countPriority(item.priority, 1)
+	}
+}
+
+// setPending marks item, generated for key, as pending or settled. The cache must be locked.
+func (c *Cache) setPending(key interface{}, item *cacheItem, pending bool) {
+	c.recount(key, item, func() { item.pending = pending })
+}
+
+// countPriority adjusts the number of evictable entries held at priority. The cache must be locked.
+func (c *Cache) countPriority(priority, delta int) {
+	if c.priorities == nil {
+		c.priorities = make(map[int]int)
+	}
+	c.priorities[priority] += delta
+	if c.priorities[priority] == 0 {
+		delete(c.priorities, priority)
+	}
+}
+
+// lowestPriority returns the lowest priority held by an entry that pruneCandidate may evict: one not pinned, in
+// vetoed or pending. It returns false if there is none. The cache must be locked.
+func (c *Cache) lowestPriority(vetoed map[interface{}]bool) (int, bool) {
+	var excluded map[int]int
+	for key := range vetoed {
+		if item := c.data[key]; item != nil && c.counted(key, item) {
+			if excluded == nil {
+				excluded = make(map[int]int)
+			}
+			excluded[item.priority]++
+		}
+	}
+	lowest, found := 0, false
+	for priority, n := range c.priorities {
+		if n > excluded[priority] && (!found || priority < lowest) {
+			lowest, found = priority, true
+		}
+	}
+	return lowest, found
+}
@@ -0,0 +1,113 @@
+/*
+Package prometheus exports flowcache statistics as Prometheus metrics.
+
+A single Collector reports any number of caches, each labelled by the name it was added under:
+
+	collector := prometheus.NewCollector()
+	collector.Add("users", userCache)
+	client.MustRegister(collector)
+*/
+package prometheus
+
+import (
+	"sync"
+
+	"github.com/ericpauley/flowcache/cache"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	hitsDesc        = prom.NewDesc("flowcache_hits_total", "Gets answered by an existing or in-flight entry.", []string{"cache"}, nil)
+	missesDesc      = prom.NewDesc("flowcache_misses_total", "Gets that started a new generation.", []string{"cache"}, nil)
+	hitRatioDesc    = prom.NewDesc("flowcache_hit_ratio", "Fraction of Gets answered without starting a generation.", []string{"cache"}, nil)
+	entriesDesc     = prom.NewDesc("flowcache_entries", "Entries currently held by the cache.", []string{"cache"}, nil)
+	storageDesc     = prom.NewDesc("flowcache_storage_bytes", "Estimated storage used by cached values.", []string{"cache"}, nil)
+	evictionsDesc   = prom.NewDesc("flowcache_evictions_total", "Entries pruned to make room.", []string{"cache"}, nil)
+	expirationsDesc = prom.NewDesc("flowcache_expirations_total", "Expired entries purged.", []string{"cache"}, nil)
+	errorsDesc      = prom.NewDesc("flowcache_generation_errors_total", "Generator calls that returned an error.", []string{"cache"}, nil)
+	latencyDesc     = prom.NewDesc("flowcache_generation_duration_seconds", "Time spent in generator calls.", []string{"cache"}, nil)
+
+	storeWritesDesc    = prom.NewDesc("flowcache_store_writes_total", "Successful writes to the secondary Store.", []string{"cache"}, nil)
+	storeWriteTimeDesc = prom.NewDesc("flowcache_store_write_seconds_total", "Time spent writing to the secondary Store.", []string{"cache"}, nil)
+	storeRetriesDesc   = prom.NewDesc("flowcache_store_retries_total", "Failed Store writes retried in the background.", []string{"cache"}, nil)
+	storeDroppedDesc   = prom.NewDesc("flowcache_store_dropped_total", "Store writes abandoned in the background.", []string{"cache"}, nil)
+	storeQueueDesc     = prom.NewDesc("flowcache_store_queue_depth", "Store writes waiting to be applied in the background.", []string{"cache"}, nil)
+
+	auditsDesc      = prom.NewDesc("flowcache_audits_total", "Entries verified against their source.", []string{"cache"}, nil)
+	divergencesDesc = prom.NewDesc("flowcache_audit_divergences_total", "Audited entries whose cached value differed from their source.", []string{"cache"}, nil)
+)
+
+// Collector is a prometheus.Collector reporting the statistics of named caches.
+type Collector struct {
+	mutex  sync.Mutex
+	caches map[string]*cache.Cache
+}
+
+// NewCollector creates a Collector with no caches.
+func NewCollector() *Collector {
+	return &Collector{caches: make(map[string]*cache.Cache)}
+}
+
+// Add reports c under the given name, replacing any cache already added with that name.
+func (c *Collector) Add(name string, ch *cache.Cache) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.caches[name] = ch
+}
+
+// Remove stops reporting the named cache.
+func (c *Collector) Remove(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.caches, name)
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	for _, desc := range []*prom.Desc{hitsDesc, missesDesc, hitRatioDesc, entriesDesc, storageDesc, evictionsDesc, expirationsDesc, errorsDesc, latencyDesc,
+		storeWritesDesc, storeWriteTimeDesc, storeRetriesDesc, storeDroppedDesc, storeQueueDesc, auditsDesc, divergencesDesc} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.mutex.Lock()
+	caches := make(map[string]*cache.Cache, len(c.caches))
+	for name, cache := range c.caches {
+		caches[name] = cache
+	}
+	c.mutex.Unlock()
+	for name, cache := range caches {
+		stats := cache.Stats()
+		ch <- prom.MustNewConstMetric(hitsDesc, prom.CounterValue, float64(stats.Hits), name)
+		ch <- prom.MustNewConstMetric(missesDesc, prom.CounterValue, float64(stats.Misses), name)
+		ch <- prom.MustNewConstMetric(hitRatioDesc, prom.GaugeValue, stats.HitRatio(), name)
+		ch <- prom.MustNewConstMetric(entriesDesc, prom.GaugeValue, float64(stats.Entries), name)
+		ch <- prom.MustNewConstMetric(storageDesc, prom.GaugeValue, float64(stats.Storage), name)
+		ch <- prom.MustNewConstMetric(evictionsDesc, prom.CounterValue, float64(stats.Evictions), name)
+		ch <- prom.MustNewConstMetric(expirationsDesc, prom.CounterValue, float64(stats.Expirations), name)
+		ch <- prom.MustNewConstMetric(errorsDesc, prom.CounterValue, float64(stats.GenerationErrors), name)
+		ch <- prom.MustNewConstHistogram(latencyDesc, stats.Generations, stats.GenerationTime.Seconds(), latencyBuckets(stats), name)
+		ch <- prom.MustNewConstMetric(storeWritesDesc, prom.CounterValue, float64(stats.StoreWrites), name)
+		ch <- prom.MustNewConstMetric(storeWriteTimeDesc, prom.CounterValue, stats.StoreWriteTime.Seconds(), name)
+		ch <- prom.MustNewConstMetric(storeRetriesDesc, prom.CounterValue, float64(stats.StoreRetries), name)
+		ch <- prom.MustNewConstMetric(storeDroppedDesc, prom.CounterValue, float64(stats.StoreDropped), name)
+		ch <- prom.MustNewConstMetric(storeQueueDesc, prom.GaugeValue, float64(stats.StoreQueueDepth), name)
+		ch <- prom.MustNewConstMetric(auditsDesc, prom.CounterValue, float64(stats.Audits), name)
+		ch <- prom.MustNewConstMetric(divergencesDesc, prom.CounterValue, float64(stats.AuditDivergences), name)
+	}
+}
+
+// latencyBuckets converts the cache's per-bucket counts into Prometheus' cumulative form.
+func latencyBuckets(stats cache.Stats) map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(cache.LatencyBuckets))
+	var cumulative uint64
+	for i, bound := range cache.LatencyBuckets {
+		if i < len(stats.GenerationBuckets) {
+			cumulative += stats.GenerationBuckets[i]
+		}
+		buckets[bound.Seconds()] = cumulative
+	}
+	return buckets
+}
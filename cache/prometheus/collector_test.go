@@ -0,0 +1,45 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ericpauley/flowcache/cache"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	c := &cache.Cache{MaxSize: 10}
+	c.Get("A", 100*time.Second, func(interface{}) (interface{}, error) {
+		return "A", nil
+	})()
+	c.Get("A", 100*time.Second, func(interface{}) (interface{}, error) {
+		return "B", nil
+	})()
+	collector := NewCollector()
+	collector.Add("test", c)
+	registry := prom.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+		switch {
+		case metric.GetCounter() != nil:
+			values[family.GetName()] = metric.GetCounter().GetValue()
+		case metric.GetGauge() != nil:
+			values[family.GetName()] = metric.GetGauge().GetValue()
+		case metric.GetHistogram() != nil:
+			values[family.GetName()] = float64(metric.GetHistogram().GetSampleCount())
+		}
+	}
+	if values["flowcache_hits_total"] != 1 || values["flowcache_misses_total"] != 1 || values["flowcache_entries"] != 1 {
+		t.Fatalf("Unexpected metrics %v", values)
+	}
+	if values["flowcache_generation_duration_seconds"] != 1 {
+		t.Fatal("Generation latency was not reported")
+	}
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrThrottled is returned in place of calling a generator when RateLimit or KeyRateLimit doesn't allow the call.
+var ErrThrottled = errors.New("Generator call throttled")
+
+// RateLimiter throttles generator calls. *rate.Limiter from golang.org/x/time/rate satisfies it.
+type RateLimiter interface {
+	// Allow reports whether a call may happen now, consuming a token if so.
+	Allow() bool
+}
+
+// rateLimited returns a function reporting whether a generator call for key is allowed now, or nil if calls
+// aren't limited. The cache must be locked.
+func (c *Cache) rateLimited() func(key interface{}) bool {
+	limit, keyLimit := c.RateLimit, c.KeyRateLimit
+	if limit == nil && keyLimit == nil {
+		return nil
+	}
+	return func(key interface{}) bool {
+		if keyLimit != nil { // Checked first so a call the key's limiter refuses doesn't spend a global token
+			if l := keyLimit(key); l != nil && !l.Allow() {
+				return false
+			}
+		}
+		return limit == nil || limit.Allow()
+	}
+}
+
+// throughRateLimit wraps generate so that it fails with ErrThrottled when allow refuses the call.
+func (c *Cache) throughRateLimit(generate generator, allow func(key interface{}) bool) generator {
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		if !allow(key) {
+			c.mutex.Lock()
+			c.stats.Throttled++
+			c.unlock()
+			return nil, ErrThrottled
+		}
+		return generate(ctx, key)
+	}
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// SetEviction replaces the eviction policy of a cache in use without dropping its entries. The new policy is
+// told of every entry, least recently used first, so policies ordering by recency start from the cache's
+// current order; frequency-based policies start with every entry counted once. A nil policy reverts to sampling.
+//
+// The cache does not tell the old policy which keys it forgets, so it must not be reused.
+func (c *Cache) SetEviction(policy EvictionPolicy) {
+	c.mutex.Lock()
+	defer c.unlock()
+	c.Eviction = policy
+	if policy == nil {
+		return
+	}
+	keys := make([]interface{}, 0, len(c.data))
+	for key := range c.data {
+		keys = append(keys, key)
+	}
+	now := c.now()
+	used := func(key interface{}) time.Time {
+		item := c.data[key]
+		switch {
+		case !item.lastUsed.IsZero():
+			return item.lastUsed
+		case !item.created.IsZero():
+			return item.created
+		}
+		return now // Still being generated
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return used(keys[i]).Before(used(keys[j]))
+	})
+	for _, key := range keys {
+		policy.OnAdd(key)
+	}
+}
+
+// SetRefresh enables or disables Refresh on a cache in use. Refreshes already in flight complete either way.
+func (c *Cache) SetRefresh(refresh bool) {
+	c.mutex.Lock()
+	defer c.unlock()
+	c.Refresh = refresh
+}
+
+// SetExtendOnUse enables or disables ExtendOnUse on a cache in use. The change applies to existing entries
+// at once: enabling it extends entries used since they were generated, and disabling it lets them expire
+// their TTL after generation, which may already have passed.
+func (c *Cache) SetExtendOnUse(extend bool) {
+	c.mutex.Lock()
+	defer c.unlock()
+	c.ExtendOnUse = extend
+}
+
+// SetMaxSize changes MaxSize on a cache in use, evicting entries at once until no more than n remain.
+// Evictions are reported as usual, vetoes included.
+func (c *Cache) SetMaxSize(n int) error {
+	if n <= 0 {
+		return errors.New("MaxSize must be positive")
+	}
+	c.lockMap()
+	defer c.unlock()
+	c.MaxSize = n
+	c.pruneWhile(c.overLimit)
+	c.maybeCompact()
+	return nil
+}
+
+// SetMaxStorage changes the storage limit of a cache in use, evicting entries at once until the estimated
+// storage fits; zero removes the limit. Values are only sized if the cache was created with a MaxStorage,
+// which must therefore be set; it keeps deciding whether values are sized, while n replaces it as the limit.
+func (c *Cache) SetMaxStorage(n uint64) error {
+	c.lockMap()
+	defer c.unlock()
+	if c.MaxStorage == 0 {
+		return errors.New("SetMaxStorage requires MaxStorage to be set")
+	}
+	c.storageCap = &n
+	c.pruneWhile(c.overLimit)
+	c.maybeCompact()
+	return nil
+}
+
+// storageLimit returns the storage the cache may use, or zero if it is unlimited. The cache must be locked.
+func (c *Cache) storageLimit() uint64 {
+	limit := c.configuredStorage()
+	if c.pressured != 0 && (limit == 0 || c.pressured < limit) {
+		return c.pressured
+	}
+	return limit
+}
+
+// configuredStorage returns the storage limit set by MaxStorage or SetMaxStorage, ignoring MemoryPressure.
+// The cache must be locked.
+func (c *Cache) configuredStorage() uint64 {
+	if c.storageCap != nil {
+		return *c.storageCap
+	}
+	return c.MaxStorage
+}
+
+// overLimit reports whether the cache holds more than its limits allow. Unlike full, it leaves no room
+// for another entry. The cache must be locked.
+func (c *Cache) overLimit() bool {
+	limit := c.storageLimit()
+	return len(c.data) > c.MaxSize || (limit != 0 && c.storage > limit)
+}
@@ -0,0 +1,93 @@
+/*
+Package redisinvalidator implements cache.Invalidator over Redis pub/sub, so that the replicas of a service,
+each holding its own flowcache, drop a key everywhere when any of them deletes or replaces it:
+
+	c := &cache.Cache{MaxSize: 1000, Invalidator: redisinvalidator.New(client, "flowcache:users")}
+	if _, err := c.Listen(); err != nil {
+		...
+	}
+
+Keys are encoded with Codec, gob by default, so concrete key types other than built-in ones must be registered
+with gob.Register. Redis pub/sub delivers at most once and invalidations published while a replica is
+disconnected are lost, so entries should still be given a TTL bounding how long they can be served stale.
+*/
+package redisinvalidator
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"sync"
+
+	"github.com/ericpauley/flowcache/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// Invalidator is a cache.Invalidator publishing invalidations on a Redis channel.
+type Invalidator struct {
+	Codec cache.Codec // Encodes keys; cache.GobCodec if nil
+
+	client  redis.UniversalClient
+	channel string
+	id      [8]byte // Prefixes this Invalidator's messages so that it can skip them
+}
+
+// New creates an Invalidator publishing on channel. Every cache sharing invalidations must use the same channel
+// and Codec, and each cache should have its own Invalidator.
+func New(client redis.UniversalClient, channel string) *Invalidator {
+	inv := &Invalidator{client: client, channel: channel}
+	rand.Read(inv.id[:])
+	return inv
+}
+
+func (inv *Invalidator) codec() cache.Codec {
+	if inv.Codec == nil {
+		return cache.GobCodec{}
+	}
+	return inv.Codec
+}
+
+// Publish implements cache.Invalidator.
+func (inv *Invalidator) Publish(key interface{}) error {
+	data, err := inv.codec().Encode(key)
+	if err != nil {
+		return err
+	}
+	message := make([]byte, 0, len(inv.id)+len(data))
+	message = append(append(message, inv.id[:]...), data...)
+	return inv.client.Publish(context.Background(), inv.channel, message).Err()
+}
+
+// Subscribe implements cache.Invalidator. It returns once Redis has confirmed the subscription.
+// Messages this Invalidator published itself, and keys that can't be decoded, are skipped.
+func (inv *Invalidator) Subscribe(fn func(key interface{})) (cancel func(), err error) {
+	ctx := context.Background()
+	sub := inv.client.Subscribe(ctx, inv.channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	messages := sub.Channel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range messages {
+			data := []byte(msg.Payload)
+			if len(data) < len(inv.id) || bytes.Equal(data[:len(inv.id)], inv.id[:]) {
+				continue
+			}
+			key, err := inv.codec().Decode(data[len(inv.id):])
+			if err != nil {
+				continue // A key this process can't decode can't be in its cache either
+			}
+			fn(key)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			sub.Close()
+			<-done
+		})
+	}, nil
+}
@@ -0,0 +1,52 @@
+package redisinvalidator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ericpauley/flowcache/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+func eventually(t *testing.T, cond func() bool, msg string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInvalidator(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	a := &cache.Cache{MaxSize: 10, Invalidator: New(client, "test")}
+	b := &cache.Cache{MaxSize: 10, Invalidator: New(client, "test")}
+	for _, c := range []*cache.Cache{a, b} {
+		cancel, err := c.Listen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cancel()
+		c.Set("A", "a", time.Minute)
+		c.Set(1, "one", time.Minute)
+	}
+	a.Delete("A")
+	eventually(t, func() bool {
+		_, ok := b.GetIfPresent("A")
+		return !ok
+	}, "Delete was not propagated")
+	b.Set(1, "uno", time.Minute)
+	eventually(t, func() bool {
+		_, ok := a.GetIfPresent(1)
+		return !ok
+	}, "Set was not propagated")
+	time.Sleep(20 * time.Millisecond)
+	if val, ok := b.GetIfPresent(1); !ok || val != "uno" {
+		t.Fatal("Invalidator delivered a cache's own publication back to it")
+	}
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// RefreshOptions configures RefreshAll.
+type RefreshOptions struct {
+	Concurrency int                             // Generators run at once, 1 if zero
+	Equal       func(old, new interface{}) bool // Reports regenerated values as unchanged, reflect.DeepEqual if nil
+}
+
+// RefreshReport summarizes a RefreshAll.
+type RefreshReport struct {
+	Refreshed int                   // Entries whose value changed
+	Unchanged int                   // Entries regenerated to an equal value
+	Failed    int                   // Entries whose generator failed, which keep their current value
+	Errors    map[interface{}]error // The error for each failed key
+}
+
+// RefreshAll regenerates every completed, unexpired and successful entry for which filter returns true
+// (every such entry if filter is nil), running up to opts.Concurrency generators at once, and reports the outcome.
+//
+// Each entry is regenerated as a refresh: the new value replaces the entry's and restarts its TTL, is written
+// through to Store and invalidated in other caches through Invalidator, while failed entries keep their current
+// value. Entries replaced, removed or already being generated when their turn comes are skipped and not reported,
+// as are entries created by GetField.
+// filter is called with the cache locked and must not call back into the cache.
+func (c *Cache) RefreshAll(filter func(key, value interface{}) bool, generate func(key interface{}) (interface{}, error), opts RefreshOptions) RefreshReport {
+	type target struct {
+		key  interface{}
+		item *cacheItem
+		old  interface{}
+	}
+	var targets []target
+	c.lockMap()
+	for key, item := range c.data {
+		if c.visible(item) && !isFieldSet(item.val) && (filter == nil || filter(key, c.expanded(item.val))) {
+			targets = append(targets, target{key, item, c.expanded(item.val)})
+		}
+	}
+	slots := c.generationSlots()
+	c.unlock()
+	equal := opts.Equal
+	if equal == nil {
+		equal = reflect.DeepEqual
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	report := RefreshReport{Errors: make(map[interface{}]error)}
+	var mutex sync.Mutex
+	var workers sync.WaitGroup
+	work := make(chan target)
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for t := range work {
+				future, ok := c.claimRefresh(t.key, t.item)
+				if !ok {
+					continue
+				}
+				var val interface{}
+				err := errors.New("Generator panicked") // Replaced unless it does, under Recover
+				release := acquireSlot(slots)
+				c.generateItem(context.Background(), t.key, t.item, func(ctx context.Context, key interface{}) (interface{}, error) {
+					val, err = generate(key)
+					return val, err
+				}, future)
+				release()
+				unchanged := err == nil && equal(t.old, val)
+				if err == nil && !unchanged {
+					c.writeBack(t.key, t.item, val)
+				}
+				mutex.Lock()
+				switch {
+				case err != nil:
+					report.Failed++
+					report.Errors[t.key] = err
+				case unchanged:
+					report.Unchanged++
+				default:
+					report.Refreshed++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, t := range targets {
+		work <- t
+	}
+	close(work)
+	workers.Wait()
+	return report
+}
+
+// claimRefresh marks item as being refreshed, returning the future that Gets promoting the refresh wait on,
+// unless it was replaced or removed or already has a generation in flight.
+func (c *Cache) claimRefresh(key interface{}, item *cacheItem) (*sync.WaitGroup, bool) {
+	c.lockMap()
+	defer c.unlock()
+	if c.data[key] != item || item.pending || item.refresh != nil || c.closed {
+		return nil, false
+	}
+	var refresh sync.WaitGroup
+	refresh.Add(1)
+	item.refresh = &refresh
+	c.refreshStarted(key)
+	return &refresh, true
+}
+
+// writeBack passes a value regenerated outside of Get through to Store and other caches, if item still holds it.
+func (c *Cache) writeBack(key interface{}, item *cacheItem, val interface{}) {
+	c.lockMap()
+	current := c.data[key] == item && item.err == nil
+	ttl := item.ttl
+	c.unlock()
+	if !current {
+		return
+	}
+	c.storeWrite(key, val, ttl)
+	c.replaced(key)
+}
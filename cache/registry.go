@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrRegistered is returned by Registry.Register for a name already in use.
+var ErrRegistered = errors.New("A cache is already registered under this name")
+
+// Registry holds named caches so that a process's caches can be enumerated, monitored and purged together.
+// The zero value is an empty registry ready to use.
+type Registry struct {
+	mutex  sync.Mutex
+	caches map[string]*Cache
+}
+
+// DefaultRegistry is a process-wide Registry.
+var DefaultRegistry = &Registry{}
+
+// Register adds c under name, failing with ErrRegistered if the name is taken.
+func (r *Registry) Register(name string, c *Cache) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.caches[name]; ok {
+		return ErrRegistered
+	}
+	if r.caches == nil {
+		r.caches = make(map[string]*Cache)
+	}
+	r.caches[name] = c
+	return nil
+}
+
+// Unregister removes the cache registered under name, if any.
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.caches, name)
+}
+
+// Lookup returns the cache registered under name.
+func (r *Registry) Lookup(name string) (*Cache, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, ok := r.caches[name]
+	return c, ok
+}
+
+// Names returns the registered names in sorted order.
+func (r *Registry) Names() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	names := make([]string, 0, len(r.caches))
+	for name := range r.caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// snapshot returns the registered caches, so they can be used without holding the registry's lock.
+func (r *Registry) snapshot() map[string]*Cache {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	caches := make(map[string]*Cache, len(r.caches))
+	for name, c := range r.caches {
+		caches[name] = c
+	}
+	return caches
+}
+
+// Stats returns the Stats of every registered cache by name.
+func (r *Registry) Stats() map[string]Stats {
+	stats := make(map[string]Stats)
+	for name, c := range r.snapshot() {
+		stats[name] = c.Stats()
+	}
+	return stats
+}
+
+// PurgeAll removes expired entries from every registered cache.
+func (r *Registry) PurgeAll() {
+	for _, c := range r.snapshot() {
+		c.Purge()
+	}
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotReplicated is returned by Get on a Replica for keys it holds no fresh replicated entry for.
+var ErrNotReplicated = errors.New("Key is not replicated")
+
+// Change is an update to one entry of a leader cache, delivered to Subscribe callbacks for a follower to Apply.
+// Changes carry no behavior, so they may be sent to followers in other processes over any transport
+// that can encode the keys and values, such as gob.
+type Change struct {
+	Key       interface{}
+	Value     interface{}
+	Generated time.Time // When the value was generated, or for a deletion when it was deleted, on the leader
+	Expires   time.Time // When the entry expires on the leader
+	Deleted   bool      // The entry left the leader; Value is unset
+}
+
+// Subscribe calls fn with each change to the cache's entries: values being stored, replaced or updated in place,
+// and entries leaving the cache. fn is called after the lock is released, and may be called concurrently by
+// unrelated operations. The returned function cancels the subscription.
+func (c *Cache) Subscribe(fn func(Change)) (cancel func()) {
+	c.mutex.Lock()
+	defer c.unlock()
+	if c.subscribers == nil {
+		c.subscribers = make(map[int]func(Change))
+	}
+	c.subscribed++
+	id := c.subscribed
+	c.subscribers[id] = fn
+	return func() {
+		c.mutex.Lock()
+		defer c.unlock()
+		delete(c.subscribers, id)
+	}
+}
+
+// publish queues a change for every subscriber. The cache must be locked.
+func (c *Cache) publish(change Change) {
+	for _, fn := range c.subscribers {
+		fn := fn
+		c.events = append(c.events, func() { fn(change) })
+	}
+}
+
+// published publishes the value an item now holds. The cache must be locked.
+func (c *Cache) published(key interface{}, item *cacheItem) {
+	if len(c.subscribers) == 0 {
+		return
+	}
+	change := Change{Key: key, Value: c.expanded(item.val), Generated: item.created}
+	if item.ttl != 0 {
+		change.Expires = c.lastTouched(item).Add(item.ttl)
+	}
+	c.publish(change)
+}
+
+// Apply applies a change from a leader's Subscribe stream. Entries expire when they expire on the leader,
+// and changes that arrive already expired are dropped.
+//
+// Changes may arrive out of order: one generated before the entry held for its key, or before the key's
+// deletion, is dropped rather than rolling the entry back. Up to MaxSize recent deletions are remembered.
+func (c *Cache) Apply(change Change) {
+	c.lockMutable()
+	defer c.unlock()
+	if c.superseded(change) {
+		return
+	}
+	if _, ok := c.data[change.Key]; ok {
+		c.remove(change.Key)
+	}
+	delete(c.tombstones, change.Key)
+	if change.Deleted {
+		c.tombstone(change)
+		return
+	}
+	created := change.Generated
+	if created.IsZero() {
+		created = c.now()
+	}
+	ttl := change.Expires.Sub(created)
+	if change.Expires.IsZero() || ttl <= 0 || !change.Expires.After(c.now()) {
+		return
+	}
+	if c.oversized(change.Key) {
+		c.stats.OversizedKeys++
+		return
+	}
+	val := c.compress(change.Value)
+	item := &cacheItem{val: val, future: &sync.WaitGroup{}, ttl: ttl, created: created, size: c.sizeOf(change.Key, val)}
+	c.insert(change.Key, item)
+	c.index(change.Key, item)
+}
+
+// superseded reports whether the entry held for change's key, or its deletion, is newer than change.
+// Changes without a generation time are never superseded. The cache must be locked.
+func (c *Cache) superseded(change Change) bool {
+	if change.Generated.IsZero() {
+		return false
+	}
+	if item, ok := c.data[change.Key]; ok {
+		return change.Generated.Before(item.created)
+	}
+	deleted, ok := c.tombstones[change.Key]
+	return ok && change.Generated.Before(deleted)
+}
+
+// tombstone remembers a deletion applied by Apply, forgetting an arbitrary one beyond MaxSize. The cache must be locked.
+func (c *Cache) tombstone(change Change) {
+	if change.Generated.IsZero() {
+		return
+	}
+	if c.tombstones == nil {
+		c.tombstones = make(map[interface{}]time.Time)
+	}
+	c.tombstones[change.Key] = change.Generated
+	for key := range c.tombstones {
+		if len(c.tombstones) <= c.MaxSize {
+			break
+		}
+		if key != change.Key {
+			delete(c.tombstones, key)
+		}
+	}
+}
+
+// Follow applies every change received from changes until it is closed.
+func (c *Cache) Follow(changes <-chan Change) {
+	for change := range changes {
+		c.Apply(change)
+	}
+}
+
+// replicaGet answers a Get on a Replica from replicated entries alone. The cache must be locked.
+func (c *Cache) replicaGet(key interface{}) func() (interface{}, error) {
+	item, ok := c.data[key]
+	if !ok || !c.visible(item) || (c.MaxStaleness > 0 && c.since(item.created) > c.MaxStaleness) {
+		c.stats.Misses++
+		return func() (interface{}, error) {
+			return nil, ErrNotReplicated
+		}
+	}
+	c.stats.Hits++
+	item.hits++
+	c.touched(key, item)
+	val := item.val
+	return func() (interface{}, error) {
+		return c.expand(val)
+	}
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// requestContextKey is the context key under which WithRequest stores a request's memo.
+type requestContextKey struct{}
+
+// requestKey identifies a memoized value by cache as well as key, so caches can share a request's memo.
+type requestKey struct {
+	cache *RequestCache
+	key   interface{}
+}
+
+// WithRequest returns a context carrying an empty memo for RequestCache, to be created once per request and
+// discarded with it. The memo is not locked, so a request must not use it from several goroutines at once.
+func WithRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, map[requestKey]interface{}{})
+}
+
+// RequestCache layers a per-request memo over a shared Cache. Within a request, a key is looked up in the shared
+// cache once and later Gets for it are answered from the memo, without locking the cache or updating the entry's
+// use, and regardless of its TTL. A request therefore sees one consistent value per key.
+type RequestCache struct {
+	cache *Cache
+}
+
+// NewRequestCache creates a RequestCache over c.
+func NewRequestCache(c *Cache) *RequestCache {
+	return &RequestCache{cache: c}
+}
+
+// Get returns the value memoized for key in ctx's request, falling back to Get on the shared cache and memoizing
+// its value. Errors are not memoized. Without a memo in ctx, as added by WithRequest, it is simply a Get.
+func (r *RequestCache) Get(ctx context.Context, key interface{}, ttl time.Duration, generate func(interface{}) (interface{}, error)) (interface{}, error) {
+	memo, _ := ctx.Value(requestContextKey{}).(map[requestKey]interface{})
+	if val, ok := memo[requestKey{r, key}]; ok {
+		return val, nil
+	}
+	val, err := r.cache.Get(key, ttl, generate)()
+	if err == nil && memo != nil {
+		memo[requestKey{r, key}] = val
+	}
+	return val, err
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Result is the outcome of GetResult: the value or error Get would return, and how the value was served.
+type Result struct {
+	Value interface{}
+	Err   error
+	// Hit reports whether the Get was answered by an existing or in-flight entry rather than starting a generation.
+	Hit bool
+	// Staleness is how far past its TTL the value was when served, as happens while StaleOnError
+	// covers failed regenerations. It is zero for fresh values and errors.
+	Staleness time.Duration
+	// TTL is the time to live the value was cached with, after merging the options of concurrent Gets
+	// as described on GetOptions. It is zero if the value was not cached, and for errors.
+	TTL time.Duration
+}
+
+// Stale reports whether the value was served past its TTL.
+func (r Result) Stale() bool {
+	return r.Staleness > 0
+}
+
+// GetResult behaves like GetWithOptions, returning a Result describing how the value was served.
+func (c *Cache) GetResult(key interface{}, opts GetOptions, generate func(interface{}) (interface{}, error)) func() Result {
+	report := &report{}
+	opts.report = report
+	retrieve := c.get(key, opts, withoutContext(generate))
+	return func() Result {
+		val, err := retrieve()
+		r := Result{Value: val, Err: err, Hit: atomic.LoadInt32(&report.hit) == 1}
+		if err == nil {
+			r.Staleness = time.Duration(atomic.LoadInt64(&report.staleness))
+			r.TTL = time.Duration(atomic.LoadInt64(&report.ttl))
+		}
+		return r
+	}
+}
+
+// report collects how a Get was served for GetResult. It is written by the goroutines serving the Get,
+// which may still be running if the Get times out, so its fields are accessed atomically.
+type report struct {
+	hit       int32
+	staleness int64
+	ttl       int64
+}
+
+func (r *report) served(hit bool) {
+	if hit {
+		atomic.StoreInt32(&r.hit, 1)
+	} else {
+		atomic.StoreInt32(&r.hit, 0)
+	}
+}
+
+// staleness returns how far an item is past its TTL, zero if it hasn't expired. The cache must be locked.
+func (c *Cache) staleness(item *cacheItem) time.Duration {
+	if item.created.IsZero() || item.ttl == 0 {
+		return 0
+	}
+	if over := c.since(c.lastTouched(item).Add(item.ttl)); over > 0 {
+		return over
+	}
+	return 0
+}
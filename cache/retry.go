@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy retries failed generations within the single generator call shared by every waiting caller.
+type RetryPolicy struct {
+	MaxAttempts int                  // Total attempts, including the first; values below 2 disable retries
+	Backoff     time.Duration        // Delay before the first retry, doubling for each retry after
+	MaxBackoff  time.Duration        // Caps the delay between attempts if non-zero
+	Retryable   func(err error) bool // Reports whether an error is worth retrying; every error is if nil
+}
+
+// retry calls generate, retrying failures according to Retry. Backoff is cut short if ctx is cancelled.
+func (c *Cache) retry(ctx context.Context, key interface{}, generate generator) (val interface{}, err error) {
+	policy := c.Retry
+	backoff := time.Duration(0)
+	if policy != nil {
+		backoff = policy.Backoff
+	}
+	for attempt := 1; ; attempt++ {
+		val, err = generate(ctx, key)
+		if err == nil || err == ErrCircuitOpen || err == ErrThrottled || policy == nil || attempt >= policy.MaxAttempts || (policy.Retryable != nil && !policy.Retryable(err)) {
+			return
+		}
+		wait, release := c.after(backoff)
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			release()
+			return
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"encoding/gob"
+	"fmt"
+)
+
+// ScopedKey is the form in which keys are shared with a SecondaryStore, snapshot or Invalidator when Scope is set.
+type ScopedKey struct {
+	Scope string
+	Key   interface{}
+}
+
+// String formats the key as "scope/key", for stores that need string keys.
+func (k ScopedKey) String() string {
+	return fmt.Sprintf("%s/%v", k.Scope, k.Key)
+}
+
+func init() {
+	gob.Register(ScopedKey{})
+}
+
+// scoped returns key as it is shared outside the process.
+func (c *Cache) scoped(key interface{}) interface{} {
+	if c.Scope == "" {
+		return key
+	}
+	return ScopedKey{c.Scope, key}
+}
+
+// unscoped reverses scoped, reporting false for keys shared under another scope.
+func (c *Cache) unscoped(key interface{}) (interface{}, bool) {
+	scopedKey, isScoped := key.(ScopedKey)
+	if c.Scope == "" {
+		return key, !isScoped
+	}
+	if !isScoped || scopedKey.Scope != c.Scope {
+		return nil, false
+	}
+	return scopedKey.Key, true
+}
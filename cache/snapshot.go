@@ -0,0 +1,279 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrSchemaMismatch is returned by VersionedCodec when decoding data written under another schema version.
+var ErrSchemaMismatch = errors.New("Value was encoded with a different schema version")
+
+// Codec serializes keys and values for snapshots, stores and transports between caches.
+// GobCodec, JSONCodec and VersionedCodec are provided; the msgpack subpackage adds MessagePack.
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// GobCodec is the default Codec. Concrete types stored as keys or values must be registered with gob.Register.
+type GobCodec struct{}
+
+// Encode encodes v as a gob interface value.
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a value written by Encode.
+func (GobCodec) Decode(data []byte) (interface{}, error) {
+	var v interface{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// VersionedCodec tags each encoded value with Version, refusing to decode values tagged with any other version.
+// Use it to keep values written before a change to their types from being misread, in snapshots or a SecondaryStore.
+type VersionedCodec struct {
+	Codec   Codec // The underlying codec, GobCodec if nil
+	Version string
+}
+
+func (v VersionedCodec) codec() Codec {
+	if v.Codec != nil {
+		return v.Codec
+	}
+	return GobCodec{}
+}
+
+// Encode encodes val with the underlying codec, prefixed by the version.
+func (v VersionedCodec) Encode(val interface{}) ([]byte, error) {
+	if len(v.Version) > 255 {
+		return nil, errors.New("Schema version must be at most 255 bytes")
+	}
+	data, err := v.codec().Encode(val)
+	if err != nil {
+		return nil, err
+	}
+	tagged := make([]byte, 0, 1+len(v.Version)+len(data))
+	tagged = append(tagged, byte(len(v.Version)))
+	tagged = append(tagged, v.Version...)
+	return append(tagged, data...), nil
+}
+
+// Decode returns ErrSchemaMismatch unless data was encoded with the same version.
+func (v VersionedCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) || string(data[1:1+data[0]]) != v.Version {
+		return nil, ErrSchemaMismatch
+	}
+	return v.codec().Decode(data[1+data[0]:])
+}
+
+func (c *Cache) codec() Codec {
+	if c.Codec != nil {
+		return c.Codec
+	}
+	return GobCodec{}
+}
+
+// snapshotHeader begins every snapshot.
+type snapshotHeader struct {
+	SchemaVersion string
+}
+
+// snapshotEntry is the record written by SaveTo for each entry.
+type snapshotEntry struct {
+	Key   []byte
+	Value []byte
+	TTL   time.Duration // Remaining at the time of the snapshot
+}
+
+// SaveTo writes every completed, unexpired entry to w along with its remaining TTL, encoding keys and values with Codec.
+// Entries are collected under the lock and encoded after it is released; hold a Freeze around SaveTo
+// to keep writes such as Patch from changing values while they are encoded.
+func (c *Cache) SaveTo(w io.Writer) error {
+	type saved struct {
+		key, val interface{}
+		ttl      time.Duration
+	}
+	var entries []saved
+	c.lockMap()
+	for key, item := range c.data {
+		if item.pending || item.created.IsZero() || item.err != nil || item.stale || item.ttl == 0 || c.expired(item) || isFieldSet(item.val) {
+			continue
+		}
+		entries = append(entries, saved{c.scoped(key), item.val, c.remaining(item)})
+	}
+	c.unlock()
+	codec := c.codec()
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{c.SchemaVersion}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		key, err := codec.Encode(e.key)
+		if err != nil {
+			return err
+		}
+		val, err := codec.Encode(c.expanded(e.val))
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(snapshotEntry{key, val, e.ttl}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFrom reads entries written by SaveTo, storing each with its remaining TTL.
+// Keys already in the cache are left untouched, and entries that expired in transit are skipped.
+//
+// A snapshot saved under a different SchemaVersion is skipped entirely, as are entries the codec
+// rejects with ErrSchemaMismatch and entries saved under a different Scope.
+func (c *Cache) LoadFrom(r io.Reader) error {
+	codec := c.codec()
+	dec := gob.NewDecoder(r)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if header.SchemaVersion != c.SchemaVersion {
+		return nil
+	}
+	for {
+		var e snapshotEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		key, err := codec.Decode(e.Key)
+		if err == ErrSchemaMismatch {
+			continue
+		} else if err != nil {
+			return err
+		}
+		val, err := codec.Decode(e.Value)
+		if err == ErrSchemaMismatch {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if key, ok := c.unscoped(key); ok && e.TTL > 0 {
+			c.restore(key, val, e.TTL)
+		}
+	}
+}
+
+// keyEntry is the record written by ExportKeys for each entry.
+type keyEntry struct {
+	Key []byte
+	TTL time.Duration // The entry's full TTL, for its regeneration
+}
+
+// ExportKeys writes the key and TTL of every completed, unexpired entry to w, most recently used first, encoding
+// keys with Codec. Unlike SaveTo no values are written, so the snapshot stays small and WarmFromKeys regenerates
+// the values from their source in the next process.
+func (c *Cache) ExportKeys(w io.Writer) error {
+	type exported struct {
+		key  interface{}
+		ttl  time.Duration
+		used time.Time
+	}
+	var entries []exported
+	c.lockMap()
+	for key, item := range c.data {
+		if item.pending || item.created.IsZero() || item.err != nil || item.stale || item.ttl == 0 || c.expired(item) || isFieldSet(item.val) {
+			continue
+		}
+		used := item.lastUsed
+		if used.IsZero() {
+			used = item.created
+		}
+		entries = append(entries, exported{c.scoped(key), item.ttl, used})
+	}
+	c.unlock()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].used.After(entries[j].used)
+	})
+	codec := c.codec()
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{c.SchemaVersion}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		key, err := codec.Encode(e.key)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(keyEntry{key, e.ttl}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WarmFromKeys reads keys written by ExportKeys and warms them as Warm does, generating each with generate
+// and its exported TTL, at most concurrency at once and in the order they were written. Keys are skipped
+// under the same rules as LoadFrom. The whole snapshot is read before any key is generated.
+func (c *Cache) WarmFromKeys(ctx context.Context, r io.Reader, generate func(interface{}) (interface{}, error), concurrency int) error {
+	codec := c.codec()
+	dec := gob.NewDecoder(r)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if header.SchemaVersion != c.SchemaVersion {
+		return nil
+	}
+	var tasks []WarmTask
+	for {
+		var e keyEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		key, err := codec.Decode(e.Key)
+		if err == ErrSchemaMismatch {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if key, ok := c.unscoped(key); ok && e.TTL > 0 {
+			tasks = append(tasks, WarmTask{Key: key, TTL: e.TTL, Generate: generate})
+		}
+	}
+	return c.warm(ctx, tasks, concurrency, c.callerPC(1))
+}
+
+// restore stores a loaded value unless key is already present.
+func (c *Cache) restore(key, val interface{}, ttl time.Duration) {
+	val = c.compress(val)
+	size := c.sizeOf(key, val)
+	c.lockMutable()
+	defer c.unlock()
+	if _, ok := c.data[key]; ok {
+		return
+	}
+	if c.oversized(key) {
+		c.stats.OversizedKeys++
+		return
+	}
+	item := &cacheItem{val: val, future: &sync.WaitGroup{}, ttl: ttl, created: c.now(), size: size, origin: c.newOrigin(SourceImport, "", 0)}
+	c.insert(key, item)
+	c.index(key, item)
+}
@@ -0,0 +1,48 @@
+package cache
+
+import "context"
+
+// throughSpill wraps generate to reload a key from Spill before generating it, removing it from Spill as it
+// returns to memory. Spill errors are counted in Stats and otherwise ignored, falling back to generate.
+func (c *Cache) throughSpill(generate generator) generator {
+	spill := c.Spill
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		entry, ok, err := spill.GetEntry(c.scoped(key))
+		c.mutex.Lock()
+		if err != nil {
+			c.stats.SpillErrors++
+		} else if ok {
+			c.stats.SpillHits++
+		}
+		c.unlock()
+		if err != nil || !ok {
+			return generate(ctx, key)
+		}
+		spill.Delete(c.scoped(key))
+		provenance := append(entry.Provenance[:len(entry.Provenance):len(entry.Provenance)], spill.Tier())
+		return provenanced{entry.Value, entry.Generated, provenance}, nil
+	}
+}
+
+// spill queues a write of an entry being evicted to Spill, if it holds a value with time left to live.
+// The cache must be locked.
+func (c *Cache) spill(key interface{}, item *cacheItem) {
+	spill := c.Spill
+	if spill == nil || item.pending || item.created.IsZero() || item.err != nil || item.ttl == 0 || c.expired(item) || isFieldSet(item.val) {
+		return
+	}
+	ttl := c.remaining(item)
+	entry := StoredEntry{Value: item.val, Generated: item.created, Provenance: item.provenance}
+	scoped := c.scoped(key)
+	c.events = append(c.events, func() {
+		entry.Value = c.expanded(entry.Value)
+		err := spill.SetEntry(scoped, entry, ttl)
+		c.mutex.Lock()
+		if err != nil {
+			c.stats.SpillErrors++
+		} else {
+			c.stats.SpillWrites++
+		}
+		c.unlock()
+	})
+}
@@ -0,0 +1,112 @@
+package cache
+
+import "time"
+
+// LatencyBuckets are the upper bounds of the generation latency histogram reported in Stats.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Stats holds counters describing a cache's activity since it was created.
+type Stats struct {
+	Entries int    // Entries currently held, including unpurged expired entries
+	Storage uint64 // Estimated storage currently used
+
+	StorageLimit uint64 // The storage limit in force: MaxStorage unless lowered by SetMaxStorage or MemoryPressure
+
+	Hits        uint64 // Gets answered by an existing or in-flight entry
+	Misses      uint64 // Gets that started a new generation
+	Evictions   uint64 // Entries pruned to make room
+	Expirations uint64 // Expired entries purged
+	Rejections  uint64 // Generated values not stored because Admission rejected them
+	CheapValues uint64 // Generated values not stored because they took less than MinGenerationTime
+
+	OversizedKeys uint64 // Gets, Sets and other writes not cached because their key exceeded MaxKeySize
+
+	Cancellations uint64 // Generations cancelled because every waiting caller timed out
+
+	Hedges    uint64 // Second generator calls started under HedgeDelay
+	HedgeWins uint64 // Hedging calls that answered before the call they hedged
+
+	Throttled uint64 // Generator calls refused by RateLimit or KeyRateLimit
+
+	Audits           uint64 // Entries verified against their source under Audit
+	AuditDivergences uint64 // Audited entries whose cached value differed from their source
+	AuditErrors      uint64 // Audits whose regeneration failed
+
+	Generations      uint64        // Completed generator calls, including refreshes
+	GenerationErrors uint64        // Generator calls that returned an error
+	GenerationTime   time.Duration // Total time spent in generators
+	// GenerationBuckets counts generator calls by latency, one bucket per LatencyBuckets entry
+	// (calls up to and including that bound) plus a final bucket for slower calls.
+	GenerationBuckets []uint64
+
+	Sizings        uint64 // Values whose storage was estimated
+	SizingsSkipped uint64 // Values whose size was reused because their fingerprint was unchanged
+
+	StoreHits   uint64 // Misses answered by Store instead of the generator
+	StoreErrors uint64 // Failed Store calls
+
+	StoreWrites     uint64        // Successful Store writes
+	StoreWriteTime  time.Duration // Total time spent in Store writes, including failed attempts
+	StoreRetries    uint64        // Failed Store writes retried under WriteBehind
+	StoreDropped    uint64        // Writes abandoned under WriteBehind because the queue was full or retries ran out
+	StoreQueueDepth int           // Writes waiting under WriteBehind
+
+	InvalidationErrors uint64 // Invalidations Invalidator failed to publish
+	AnnounceErrors     uint64 // Misses Announcer failed to publish
+	Prefetches         uint64 // Generations started by FollowPeers for keys other caches announced
+	PrefetchesDropped  uint64 // Announced keys not prefetched because the FollowPeers queue was full
+
+	SpillWrites uint64 // Evicted entries written to Spill
+	SpillHits   uint64 // Misses answered by Spill
+	SpillErrors uint64 // Failed Spill reads and writes
+}
+
+// HitRatio returns the fraction of Gets answered without starting a generation.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s *Stats) recordGeneration(elapsed time.Duration, err error) {
+	if s.GenerationBuckets == nil {
+		s.GenerationBuckets = make([]uint64, len(LatencyBuckets)+1)
+	}
+	bucket := len(LatencyBuckets)
+	for i, bound := range LatencyBuckets {
+		if elapsed <= bound {
+			bucket = i
+			break
+		}
+	}
+	s.GenerationBuckets[bucket]++
+	s.Generations++
+	s.GenerationTime += elapsed
+	if err != nil {
+		s.GenerationErrors++
+	}
+}
+
+// Stats returns a snapshot of the cache's counters.
+func (c *Cache) Stats() Stats {
+	c.mutex.Lock()
+	defer c.unlock()
+	stats := c.stats
+	stats.Entries = len(c.data)
+	stats.Storage = c.storage
+	stats.StorageLimit = c.storageLimit()
+	stats.StoreQueueDepth = len(c.writes)
+	stats.GenerationBuckets = append([]uint64(nil), c.stats.GenerationBuckets...)
+	return stats
+}
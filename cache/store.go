@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// SecondaryStore is a slower, usually shared, tier consulted before generating a missing key,
+// such as Redis, memcached or a disk store. Implementations must be safe for concurrent use.
+type SecondaryStore interface {
+	// Get returns the value stored under key, and whether it was found.
+	Get(key interface{}) (interface{}, bool, error)
+	// Set stores value under key for ttl.
+	Set(key, value interface{}, ttl time.Duration) error
+	// Delete removes key.
+	Delete(key interface{}) error
+}
+
+// ProvenanceStore is a SecondaryStore that also records where and when each value was generated.
+// Entries read from it keep their original generation time, so a value's lifetime is measured
+// from when it was first generated rather than restarting at each tier it passes through.
+type ProvenanceStore interface {
+	SecondaryStore
+	// Tier names the store, and is appended to the provenance of values read from it.
+	Tier() string
+	// GetEntry returns the entry stored under key, and whether it was found.
+	GetEntry(key interface{}) (StoredEntry, bool, error)
+	// SetEntry stores entry under key for ttl.
+	SetEntry(key interface{}, entry StoredEntry, ttl time.Duration) error
+}
+
+// StoredEntry is a value held in a ProvenanceStore along with its provenance.
+type StoredEntry struct {
+	Value      interface{}
+	Generated  time.Time // When the value was originally generated
+	Provenance []string  // The tiers the value passed through before reaching the store, oldest first
+}
+
+// provenanced carries a value read from a ProvenanceStore back to generateItem.
+type provenanced struct {
+	val        interface{}
+	generated  time.Time
+	provenance []string
+}
+
+// throughStore wraps generate to read from Store first and write generated values back to it.
+// Store errors are counted in Stats and otherwise ignored, falling back to generate.
+func (c *Cache) throughStore(generate generator, item *cacheItem) generator {
+	store, ttl := c.Store, item.ttl
+	if ps, ok := store.(ProvenanceStore); ok {
+		return c.throughProvenanceStore(generate, ps, item)
+	}
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		val, ok, err := store.Get(c.scoped(key))
+		c.storeResult(ok, err)
+		if err == nil && ok {
+			return val, nil
+		}
+		val, err = generate(ctx, key)
+		if err == nil && ttl != 0 {
+			c.persist(func() error {
+				if c.replacedBySet(item) {
+					return nil
+				}
+				return store.Set(c.scoped(key), val, ttl)
+			})
+		}
+		return val, err
+	}
+}
+
+// throughProvenanceStore is throughStore for a ProvenanceStore. Stored entries too old to be
+// cached for ttl without immediately needing a refresh are ignored and regenerated.
+func (c *Cache) throughProvenanceStore(generate generator, store ProvenanceStore, item *cacheItem) generator {
+	ttl := item.ttl
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		entry, ok, err := store.GetEntry(c.scoped(key))
+		c.storeResult(ok, err)
+		if err == nil && ok && c.usable(entry, ttl) {
+			provenance := append(entry.Provenance[:len(entry.Provenance):len(entry.Provenance)], store.Tier())
+			return provenanced{entry.Value, entry.Generated, provenance}, nil
+		}
+		start := c.now()
+		val, err := generate(ctx, key)
+		if err == nil && ttl != 0 {
+			c.persist(func() error {
+				if c.replacedBySet(item) {
+					return nil
+				}
+				return store.SetEntry(c.scoped(key), StoredEntry{Value: val, Generated: start}, ttl)
+			})
+		}
+		return val, err
+	}
+}
+
+// replacedBySet reports whether a Set replaced item while it was being generated, in which case the generated
+// value must not overwrite the Set's in Store. It is checked as the write is made, since Set writes after it
+// marks the item.
+func (c *Cache) replacedBySet(item *cacheItem) bool {
+	c.mutex.Lock()
+	defer c.unlock()
+	return item.superseded
+}
+
+// usable reports whether a stored entry is young enough to be cached for ttl.
+func (c *Cache) usable(entry StoredEntry, ttl time.Duration) bool {
+	if ttl == 0 || entry.Generated.IsZero() {
+		return true
+	}
+	c.mutex.Lock()
+	refresh := c.Refresh
+	c.mutex.Unlock()
+	limit := ttl
+	if refresh {
+		limit = c.refreshAge(ttl)
+	}
+	return c.since(entry.Generated) < limit
+}
+
+// storeResult records the outcome of a Store read in Stats.
+func (c *Cache) storeResult(hit bool, err error) {
+	if !hit && err == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		c.stats.StoreErrors++
+	} else {
+		c.stats.StoreHits++
+	}
+}
+
+// storeWrite passes a Set or Delete through to Store.
+func (c *Cache) storeWrite(key, value interface{}, ttl time.Duration) {
+	store := c.Store
+	if store == nil {
+		return
+	}
+	if ttl == 0 {
+		c.persist(func() error { return store.Delete(c.scoped(key)) })
+	} else if ps, ok := store.(ProvenanceStore); ok {
+		generated := c.now()
+		c.persist(func() error { return ps.SetEntry(c.scoped(key), StoredEntry{Value: value, Generated: generated}, ttl) })
+	} else {
+		c.persist(func() error { return store.Set(c.scoped(key), value, ttl) })
+	}
+}
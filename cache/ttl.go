@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// NoExpiry is a TTL for entries that never expire. They stay cached until evicted, deleted or replaced.
+const NoExpiry time.Duration = math.MaxInt64
+
+// ZeroTTLMode determines what a TTL of zero asks for.
+type ZeroTTLMode int
+
+const (
+	// ZeroTTLNoCache returns the value to its callers without caching it.
+	ZeroTTLNoCache ZeroTTLMode = iota
+	// ZeroTTLForever caches the value without expiry, as NoExpiry does.
+	ZeroTTLForever
+)
+
+// lifetime translates a TTL given to the cache according to ZeroTTL.
+func (c *Cache) lifetime(ttl time.Duration) time.Duration {
+	if ttl == 0 && c.ZeroTTL == ZeroTTLForever {
+		return NoExpiry
+	}
+	return ttl
+}
+
+// remaining returns the time left before item expires, negative once it has, or NoExpiry if it never will.
+func (c *Cache) remaining(item *cacheItem) time.Duration {
+	if item.ttl == NoExpiry {
+		return NoExpiry // Adding it to the last use would overflow
+	}
+	return c.lastTouched(item).Add(item.ttl).Sub(c.now())
+}
+
+// DoNotCache wraps err, returned by a generator, so that it reaches the callers waiting on the generation
+// but is never cached, even under ErrorTTL. errors.Is matches the wrapped error against err.
+func DoNotCache(err error) error {
+	return uncached{err}
+}
+
+type uncached struct{ error }
+
+func (u uncached) Unwrap() error {
+	return u.error
+}
+
+// cacheableError reports whether err may be kept under ErrorTTL: it neither comes from a context nor was
+// wrapped by DoNotCache.
+func cacheableError(err error) bool {
+	var u uncached
+	return !contextError(err) && !errors.As(err, &u)
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Validate checks the cache's configuration for settings that conflict or have no effect, returning an error
+// describing every problem found. Some checks use the activity recorded so far, so calling Validate again
+// after the cache has warmed up may report problems not visible at startup.
+//
+// NewCache calls Validate once its options are applied.
+func (c *Cache) Validate() error {
+	c.mutex.Lock()
+	stats, uncached := c.stats, c.uncached
+	c.mutex.Unlock()
+	var problems []string
+	check := func(bad bool, format string, args ...interface{}) {
+		if bad {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(c.MaxSize < 1, "MaxSize is %d; every insert would evict all other entries, set it to at least 1", c.MaxSize)
+	check(c.MaxStorage == 0 && c.Sizer != nil, "Sizer is set but MaxStorage is zero, so values are never sized; set MaxStorage or remove Sizer")
+	check(c.MaxStorage == 0 && c.MemoryPressure != nil, "MemoryPressure is set but MaxStorage is zero, so storage is never limited; set MaxStorage")
+	check(c.MaxStorage == 0 && c.Fingerprint != nil, "Fingerprint is set but MaxStorage is zero, so sizes are never reused; set MaxStorage or remove Fingerprint")
+	check(!c.Refresh && (c.RefreshBeta > 0 || c.RefreshFraction > 0 || c.RefreshAfter > 0), "RefreshBeta, RefreshFraction or RefreshAfter is set but Refresh is disabled; set Refresh")
+	check(c.RefreshFraction < 0 || c.RefreshFraction >= 1, "RefreshFraction is %v; it must be in (0, 1)", c.RefreshFraction)
+	check(c.TTLJitter < 0 || c.TTLJitter >= 1, "TTLJitter is %v; it must be in [0, 1)", c.TTLJitter)
+	check(c.CompactRatio < 0 || c.CompactRatio >= 1, "CompactRatio is %v; it must be in [0, 1)", c.CompactRatio)
+	check(c.MaxWaiters < 0, "MaxWaiters is %d; it must not be negative", c.MaxWaiters)
+	check(c.MaxWaiters == 0 && c.OverflowValue != nil, "OverflowValue is set but MaxWaiters is zero, so it is never returned; set MaxWaiters")
+	check(c.EvictVetoBudget != 0 && c.OnEvictCandidate == nil, "EvictVetoBudget is set but OnEvictCandidate is nil; set OnEvictCandidate")
+	check(c.Refresh && uncached > 0 && uncached*2 >= stats.Hits+stats.Misses,
+		"Refresh is set but %d of %d Gets used a zero TTL, which is never cached or refreshed; give those Gets a TTL", uncached, stats.Hits+stats.Misses)
+	if c.GetTimeout > 0 && stats.Generations > 0 {
+		mean := stats.GenerationTime / time.Duration(stats.Generations)
+		check(mean > c.GetTimeout, "GetTimeout is %v but generation takes %v on average, so most misses time out; raise GetTimeout", c.GetTimeout, mean)
+	}
+	if len(problems) > 0 {
+		return errors.New("Invalid cache configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
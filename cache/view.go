@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// View is an immutable copy of a cache's completed, unexpired and successful entries as of one moment, for
+// analysis that would otherwise hold up live Gets, such as repeated scans or sorting. It copies each entry's
+// bookkeeping but shares values with the cache, which must therefore not be modified in place.
+// Reading a View never locks the cache, counts as a use of an entry, or observes later changes.
+type View struct {
+	cache   *Cache // Expands compressed values
+	taken   time.Time
+	entries map[interface{}]viewEntry
+}
+
+type viewEntry struct {
+	val  interface{}
+	info EntryInfo
+}
+
+// View captures the cache's current entries. The cache is locked only while their bookkeeping is copied.
+func (c *Cache) View() *View {
+	c.lockMap()
+	defer c.unlock()
+	v := &View{cache: c, taken: c.now(), entries: make(map[interface{}]viewEntry, len(c.data))}
+	for key, item := range c.data {
+		if c.visible(item) {
+			v.entries[key] = viewEntry{item.val, c.info(item)}
+		}
+	}
+	return v
+}
+
+// Taken returns when the view was captured.
+func (v *View) Taken() time.Time {
+	return v.taken
+}
+
+// Len returns the number of entries in the view.
+func (v *View) Len() int {
+	return len(v.entries)
+}
+
+// Get returns the value held under key when the view was captured.
+func (v *View) Get(key interface{}) (interface{}, bool) {
+	e, ok := v.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return v.cache.expanded(e.val), true
+}
+
+// Info returns the bookkeeping of the entry under key when the view was captured.
+func (v *View) Info(key interface{}) (EntryInfo, bool) {
+	e, ok := v.entries[key]
+	return e.info, ok
+}
+
+// Keys returns the keys in the view, in no particular order.
+func (v *View) Keys() []interface{} {
+	keys := make([]interface{}, 0, len(v.entries))
+	for key := range v.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Range calls fn for every entry in the view until fn returns false.
+func (v *View) Range(fn func(key, value interface{}, meta EntryInfo) bool) {
+	for key, e := range v.entries {
+		if !fn(key, v.cache.expanded(e.val), e.info) {
+			return
+		}
+	}
+}
+
+// TopKeys returns up to n keys with the most hits, most hit first.
+func (v *View) TopKeys(n int) []interface{} {
+	keys := v.Keys()
+	sort.Slice(keys, func(i, j int) bool {
+		return v.entries[keys[i]].info.Hits > v.entries[keys[j]].info.Hits
+	})
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrDependencyFailed is reported for warm tasks that were skipped because a dependency failed.
+	ErrDependencyFailed = errors.New("Warm dependency failed")
+	// ErrDependencyCycle is reported for warm tasks whose dependencies can never be satisfied.
+	ErrDependencyCycle = errors.New("Warm dependency cycle")
+)
+
+// WarmTask describes a single key to populate ahead of traffic.
+type WarmTask struct {
+	Key       interface{}
+	TTL       time.Duration
+	Generate  func(interface{}) (interface{}, error)
+	DependsOn []interface{} // Keys that must be generated before this one. Keys without a task are assumed available.
+}
+
+// WarmErrors maps keys that could not be warmed to the reason.
+type WarmErrors map[interface{}]error
+
+func (e WarmErrors) Error() string {
+	return fmt.Sprintf("%d keys failed to warm", len(e))
+}
+
+// WarmGraph populates the cache with the given tasks, running at most concurrency generators at once.
+// Each task is only started after all of the tasks it depends on have completed successfully,
+// so derived entries find their inputs already cached.
+//
+// Tasks go through Get, so keys already cached or being generated are shared rather than regenerated.
+// Once ctx is done, running tasks stop waiting for their generators, which are cancelled under CancelAbandoned
+// as for a Get that timed out. If any task fails, is skipped or cannot finish before ctx is done, a WarmErrors
+// is returned.
+func (c *Cache) WarmGraph(ctx context.Context, tasks []WarmTask, concurrency int) error {
+	return c.warm(ctx, tasks, concurrency, c.callerPC(1))
+}
+
+// Warm populates the cache with keys, generating each with generate and ttl, running at most concurrency
+// generators at once. It is WarmGraph for keys without dependencies: keys already cached or being generated
+// are shared, and if any key fails or cannot start before ctx is done, a WarmErrors maps it to the reason.
+func (c *Cache) Warm(ctx context.Context, keys []interface{}, ttl time.Duration, generate func(interface{}) (interface{}, error), concurrency int) error {
+	tasks := make([]WarmTask, len(keys))
+	for i, key := range keys {
+		tasks[i] = WarmTask{Key: key, TTL: ttl, Generate: generate}
+	}
+	return c.warm(ctx, tasks, concurrency, c.callerPC(1))
+}
+
+// warm runs WarmGraph, recording caller as the origin of the entries it creates.
+func (c *Cache) warm(ctx context.Context, tasks []WarmTask, concurrency int, caller uintptr) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	index := make(map[interface{}]int, len(tasks))
+	for i, task := range tasks {
+		index[task.Key] = i
+	}
+	blocking := make([]int, len(tasks))
+	dependents := make([][]int, len(tasks))
+	for i, task := range tasks {
+		for _, dep := range task.DependsOn {
+			if j, ok := index[dep]; ok {
+				blocking[i]++
+				dependents[j] = append(dependents[j], i)
+			}
+		}
+	}
+	type outcome struct {
+		task int
+		err  error
+	}
+	done := make(chan outcome)
+	errs := WarmErrors{}
+	var ready []int
+	for i := range tasks {
+		if blocking[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	// fail records err for task i and transitively skips everything depending on it
+	var fail func(i int, err error)
+	fail = func(i int, err error) {
+		errs[tasks[i].Key] = err
+		for _, j := range dependents[i] {
+			if _, ok := errs[tasks[j].Key]; !ok {
+				fail(j, ErrDependencyFailed)
+			}
+		}
+	}
+	started := make([]bool, len(tasks))
+	running := 0
+	for {
+		for len(ready) > 0 && running < concurrency && ctx.Err() == nil {
+			i := ready[0]
+			ready = ready[1:]
+			started[i] = true
+			running++
+			go func(i int) {
+				_, err := c.get(tasks[i].Key, GetOptions{TTL: tasks[i].TTL, source: SourceWarm, caller: caller, ctx: ctx}, withoutContext(tasks[i].Generate))()
+				done <- outcome{i, err}
+			}(i)
+		}
+		if running == 0 {
+			break
+		}
+		result := <-done
+		running--
+		if result.err != nil {
+			fail(result.task, result.err)
+			continue
+		}
+		for _, j := range dependents[result.task] {
+			blocking[j]--
+			if blocking[j] == 0 {
+				if _, ok := errs[tasks[j].Key]; !ok {
+					ready = append(ready, j)
+				}
+			}
+		}
+	}
+	for i, task := range tasks {
+		if _, ok := errs[task.Key]; ok || started[i] {
+			continue
+		}
+		if ctx.Err() != nil {
+			errs[task.Key] = ctx.Err()
+		} else {
+			errs[task.Key] = ErrDependencyCycle
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
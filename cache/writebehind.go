@@ -0,0 +1,94 @@
+package cache
+
+import "time"
+
+// defaultWriteQueue is the number of writes that may wait under WriteBehind when QueueSize is zero.
+const defaultWriteQueue = 1024
+
+// WriteBehind makes Store writes asynchronous. Writes are queued and applied in order by a single background
+// goroutine, retrying failures, so callers never wait on Store. Queue depth, write latency, retries and dropped
+// writes are reported in Stats.
+type WriteBehind struct {
+	QueueSize  int           // Writes that may wait before further writes are dropped; 1024 if zero
+	MaxRetries int           // Retries of a failed write before it is dropped
+	Backoff    time.Duration // Wait before each retry
+}
+
+// persist applies a Store write, queueing it under WriteBehind. Writes made after Close are applied synchronously.
+func (c *Cache) persist(write func() error) {
+	if c.WriteBehind == nil {
+		c.flush(write, 0)
+		return
+	}
+	c.mutex.Lock()
+	if c.closed {
+		c.unlock()
+		c.flush(write, 0)
+		return
+	}
+	if c.writes == nil {
+		size := c.WriteBehind.QueueSize
+		if size <= 0 {
+			size = defaultWriteQueue
+		}
+		c.writes = make(chan func() error, size)
+		c.flushing.Add(1)
+		go c.flusher(c.writes, c.WriteBehind.MaxRetries)
+	}
+	select {
+	case c.writes <- write:
+	default:
+		c.stats.StoreDropped++
+	}
+	c.unlock()
+}
+
+// flusher applies queued writes until the queue is closed.
+func (c *Cache) flusher(writes <-chan func() error, retries int) {
+	defer c.flushing.Done()
+	for write := range writes {
+		c.flush(write, retries)
+	}
+}
+
+// flush applies a Store write, retrying it up to retries times, and records the outcome in Stats.
+func (c *Cache) flush(write func() error, retries int) {
+	for attempt := 0; ; attempt++ {
+		start := c.now()
+		err := write()
+		elapsed := c.since(start)
+		retry := err != nil && attempt < retries
+		c.mutex.Lock()
+		c.stats.StoreWriteTime += elapsed
+		if err == nil {
+			c.stats.StoreWrites++
+		} else {
+			c.stats.StoreErrors++
+		}
+		if retry {
+			c.stats.StoreRetries++
+		} else if err != nil && c.WriteBehind != nil {
+			c.stats.StoreDropped++
+		}
+		backoff := time.Duration(0)
+		if c.WriteBehind != nil {
+			backoff = c.WriteBehind.Backoff
+		}
+		c.unlock()
+		if !retry {
+			return
+		}
+		c.sleep(backoff)
+	}
+}
+
+// drainWrites stops accepting queued writes and waits for those already queued to be applied.
+func (c *Cache) drainWrites() {
+	c.mutex.Lock()
+	if c.writes != nil {
+		close(c.writes)
+		c.writes = nil
+	}
+	c.unlock()
+	c.flushing.Wait()
+}